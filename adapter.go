@@ -2,6 +2,7 @@ package gotcha
 
 import (
 	"net/http"
+	"net/url"
 	"sync"
 )
 
@@ -10,10 +11,38 @@ type Adapter interface {
 	DoRequest(options *Options) (*Response, error)
 }
 
+// Capabilities describes which optional features an Adapter supports.
+type Capabilities struct {
+	// Whether Options.HeaderOrder is honored.
+	HeaderOrder bool
+
+	// Whether Options.PseudoHeaderOrder is honored.
+	PseudoHeaderOrder bool
+
+	// Whether header keys are sent with the casing used in Options.Headers.
+	HeaderCasing bool
+}
+
+// CapabilityReporter is implemented by adapters that report their Capabilities.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// AdapterCapabilities returns the Capabilities of adapter.
+// Adapters that don't implement CapabilityReporter are assumed to support none of the optional features.
+func AdapterCapabilities(adapter Adapter) Capabilities {
+	if cr, ok := adapter.(CapabilityReporter); ok {
+		return cr.Capabilities()
+	}
+	return Capabilities{}
+}
+
 // RequestAdapter is a default implementation of Adapter.
 // Gotcha will use this adapter when no other is specified.
 type RequestAdapter struct {
 	// RoundTripper is a http.RoundTripper that will be used to do the request.
+	// When it's a *http.Transport, requests with Options.Proxy are sent by a copy of it that connects through the proxy.
+	// Requests through a SOCKS proxy fail with ProxyDialTLSError when it has a custom DialTLS or DialTLSContext function.
 	//
 	// Defaults to http.DefaultTransport.
 	RoundTripper http.RoundTripper
@@ -24,10 +53,18 @@ type RequestAdapter struct {
 	Request func(*Options) *http.Request
 
 	mu sync.Mutex
+	// copies of RoundTripper per proxy
+	proxyTransports map[proxyTransportKey]*http.Transport
+}
+
+// proxyTransportKey identifies the copy of a transport that connects through a proxy.
+type proxyTransportKey struct {
+	transport *http.Transport
+	proxy     string
 }
 
 // Initializes adapter defaults.
-func (ra *RequestAdapter) init(options *Options) {
+func (ra *RequestAdapter) init() {
 	ra.mu.Lock()
 
 	if ra.Request == nil {
@@ -42,28 +79,76 @@ func (ra *RequestAdapter) init(options *Options) {
 	}
 
 	if ra.RoundTripper == nil {
-		var defaultTransport = http.DefaultTransport.(*http.Transport)
-		if p := options.Proxy; p != nil {
-			defaultTransport.Proxy = http.ProxyURL(p)
-		}
-		ra.RoundTripper = defaultTransport
+		ra.RoundTripper = http.DefaultTransport
 	}
 
 	ra.mu.Unlock()
 }
 
+// roundTripper returns the http.RoundTripper that sends requests through proxy, which may be nil.
+func (ra *RequestAdapter) roundTripper(proxy *url.URL) (http.RoundTripper, error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	t, ok := ra.RoundTripper.(*http.Transport)
+	if proxy == nil || !ok {
+		return ra.RoundTripper, nil
+	}
+
+	key := proxyTransportKey{t, proxy.String()}
+	if c, ok := ra.proxyTransports[key]; ok {
+		return c, nil
+	}
+
+	c := t.Clone()
+	if isHTTPProxy(proxy) {
+		// net/http performs the TLS handshake over the tunnel itself, custom TLS dialers only connect to HTTPS proxies
+		c.Proxy = http.ProxyURL(proxy)
+	} else {
+		// custom TLS dialers would connect to the server directly instead of through DialContext
+		if t.DialTLS != nil || t.DialTLSContext != nil {
+			return nil, ProxyDialTLSError
+		}
+		// net/http only supports socks5 proxies and always lets them resolve host names
+		c.Proxy = nil
+		c.DialContext = (&ProxyDialer{Proxy: proxy, Dial: t.DialContext}).DialContext
+	}
+
+	if ra.proxyTransports == nil {
+		ra.proxyTransports = make(map[proxyTransportKey]*http.Transport)
+	}
+	ra.proxyTransports[key] = c
+
+	return c, nil
+}
+
+// Capabilities reports that header order can't be controlled, because net/http sorts headers.
+// Use the RawAdapter instead if header order matters.
+func (ra *RequestAdapter) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
 func (ra *RequestAdapter) DoRequest(options *Options) (*Response, error) {
-	ra.init(options)
+	ra.init()
 
 	req := ra.Request(options)
 
+	if ctx := options.RequestContext; ctx != nil {
+		req = req.WithContext(ctx)
+	}
+
 	if options.CookieJar != nil {
 		for _, cookie := range options.CookieJar.Cookies(options.FullUrl) {
 			req.AddCookie(cookie)
 		}
 	}
 
-	res, err := ra.RoundTripper.RoundTrip(req)
+	rt, err := ra.roundTripper(options.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := rt.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
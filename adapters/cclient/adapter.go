@@ -1,9 +1,13 @@
 package cclient
 
 import (
+	"fmt"
 	tls "github.com/refraction-networking/utls"
 	"github.com/sleeyax/gotcha"
-	"github.com/x04/cclient"
+	"github.com/sleeyax/gotcha/fingerprint"
+	"github.com/sleeyax/gotcha/profiles"
+	"net/url"
+	"sync"
 )
 
 type Adapter struct {
@@ -12,6 +16,28 @@ type Adapter struct {
 
 	// TLS client hello ID to use.
 	ClientHello tls.ClientHelloID
+
+	// Optional JA3 fingerprint string or raw JA4 fingerprint to build a custom ClientHello from, instead of using ClientHello.
+	// See fingerprint.Parse.
+	//
+	// cclient only supports predefined ClientHellos, so these requests are made by a gotcha.RawAdapter over HTTP/1.1.
+	Fingerprint string
+
+	// Optional contents of the extensions that aren't part of Fingerprint.
+	// The ALPN protocols are always set to http/1.1.
+	FingerprintOptions *fingerprint.Options
+
+	raw            *gotcha.RawAdapter
+	rawFingerprint string
+	rawOptions     *fingerprint.Options
+	roundTrippers  map[roundTripperKey]*roundTripper
+	mu             sync.Mutex
+}
+
+// roundTripperKey identifies the round tripper that pools the connections of a ClientHello and proxy.
+type roundTripperKey struct {
+	clientHello tls.ClientHelloID
+	proxy       string
 }
 
 func parseProxy(proxies []string) string {
@@ -28,19 +54,139 @@ func NewAdapter(clientHello tls.ClientHelloID, proxyUrl ...string) *Adapter {
 	}
 }
 
+// Capabilities reports that header order can't be controlled, because cclient is based on net/http.
+func (a *Adapter) Capabilities() gotcha.Capabilities {
+	return gotcha.Capabilities{}
+}
+
+// ApplyProfile configures the adapter to send the TLS ClientHello of p.
+// An error is returned when utls doesn't know the ClientHello.
+// The HTTP/2 fingerprint of p isn't supported by cclient, HTTP/2 connections use the settings of golang.org/x/net/http2.
+func (a *Adapter) ApplyProfile(p *profiles.Profile) error {
+	clientHello := tls.ClientHelloID{Client: p.ClientHello.Client, Version: p.ClientHello.Version}
+	// building the ClientHello fails for IDs without a preset
+	if err := tls.UClient(nil, &tls.Config{ServerName: "localhost"}, clientHello).BuildHandshakeState(); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	a.mu.Lock()
+	a.ClientHello = clientHello
+	a.mu.Unlock()
+	return nil
+}
+
 func (a *Adapter) DoRequest(options *gotcha.Options) (*gotcha.Response, error) {
+	a.mu.Lock()
+	clientHello := a.ClientHello
+	proxy := a.proxyUrl
+	a.mu.Unlock()
+
+	raw, err := a.rawAdapter()
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil {
+		if options.Proxy == nil && proxy != "" {
+			proxyUrl, err := url.Parse(proxy)
+			if err != nil {
+				return nil, err
+			}
+			o := *options
+			o.Proxy = proxyUrl
+			options = &o
+		}
+		return raw.DoRequest(options)
+	}
+
 	if options.Proxy != nil {
-		a.proxyUrl = options.Proxy.String()
+		proxy = options.Proxy.String()
 	}
 
-	client, err := cclient.NewClient(a.ClientHello, a.proxyUrl)
+	rt, err := a.roundTripper(clientHello, proxy)
 	if err != nil {
 		return nil, err
 	}
 
 	requestAdapter := gotcha.RequestAdapter{
-		RoundTripper: client.Transport,
+		RoundTripper: rt,
 	}
 
 	return requestAdapter.DoRequest(options)
 }
+
+// roundTripper returns the round tripper for clientHello and proxy, creating it if needed.
+func (a *Adapter) roundTripper(clientHello tls.ClientHelloID, proxy string) (*roundTripper, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := roundTripperKey{clientHello, proxy}
+	if rt, ok := a.roundTrippers[key]; ok {
+		return rt, nil
+	}
+
+	var proxyUrl *url.URL
+	if proxy != "" {
+		var err error
+		if proxyUrl, err = url.Parse(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	rt := newRoundTripper(clientHello, proxyUrl)
+	if a.roundTrippers == nil {
+		a.roundTrippers = make(map[roundTripperKey]*roundTripper)
+	}
+	a.roundTrippers[key] = rt
+
+	return rt, nil
+}
+
+// CloseIdleConnections closes the connections of all ClientHellos and proxies that aren't in use.
+func (a *Adapter) CloseIdleConnections() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, rt := range a.roundTrippers {
+		rt.CloseIdleConnections()
+	}
+	if a.raw != nil {
+		a.raw.CloseIdleConnections()
+	}
+}
+
+// rawAdapter returns the RawAdapter that makes requests with a ClientHello built from Fingerprint, or nil when Fingerprint is empty.
+// The adapter is recreated when Fingerprint or FingerprintOptions change.
+func (a *Adapter) rawAdapter() (*gotcha.RawAdapter, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Fingerprint == "" {
+		return nil, nil
+	}
+
+	var fo fingerprint.Options
+	if a.FingerprintOptions != nil {
+		fo = *a.FingerprintOptions
+	}
+	fo.ALPN = []string{"http/1.1"}
+
+	// validate the fingerprint before any connection is made
+	if _, err := fingerprint.Parse(a.Fingerprint, &fo); err != nil {
+		return nil, err
+	}
+
+	if a.raw == nil || a.rawFingerprint != a.Fingerprint || a.rawOptions != a.FingerprintOptions {
+		if a.raw != nil {
+			a.raw.CloseIdleConnections()
+		}
+		fp := a.Fingerprint
+		a.raw = &gotcha.RawAdapter{
+			TLSClient: fingerprint.TLSClient(func() (*tls.ClientHelloSpec, error) {
+				return fingerprint.Parse(fp, &fo)
+			}, nil),
+		}
+		a.rawFingerprint, a.rawOptions = a.Fingerprint, a.FingerprintOptions
+	}
+
+	return a.raw, nil
+}
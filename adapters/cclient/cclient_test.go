@@ -3,24 +3,50 @@ package cclient
 // this code originally came from: https://github.com/x04/cclient/blob/master/client_test.go
 
 import (
+	"context"
 	"encoding/json"
 	tls "github.com/refraction-networking/utls"
 	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/internal/echo"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"golang.org/x/net/http2"
 	"io"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 const Chrome83Hash = "b32309a26951912be7dba376398abc3b"
 
+var server *echo.Server
+
 var client, _ = gotcha.NewClient(&gotcha.Options{
 	Adapter: NewAdapter(tls.HelloChrome_83),
 })
 
-type JA3Response struct {
-	JA3Hash   string `json:"ja3_hash"`
-	JA3       string `json:"ja3"`
-	UserAgent string `json:"User-Agent"`
+func TestMain(m *testing.M) {
+	server = echo.NewServer()
+
+	// cclient verifies certificates against the system roots, which are loaded from SSL_CERT_FILE on the first handshake
+	dir, err := ioutil.TempDir("", "cclient")
+	if err != nil {
+		panic(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	if err = ioutil.WriteFile(certFile, server.CertificatePEM(), 0600); err != nil {
+		panic(err)
+	}
+	os.Setenv("SSL_CERT_FILE", certFile)
+
+	code := m.Run()
+
+	server.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
 }
 
 func readAndClose(r io.ReadCloser) ([]byte, error) {
@@ -31,8 +57,8 @@ func readAndClose(r io.ReadCloser) ([]byte, error) {
 	return readBytes, r.Close()
 }
 
-func TestCClient_JA3(t *testing.T) {
-	resp, err := client.Get("https://ja3er.com/json")
+func getFingerprint(t *testing.T, client *gotcha.Client) (*gotcha.Response, *echo.Fingerprint) {
+	resp, err := client.Get(server.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -41,28 +67,200 @@ func TestCClient_JA3(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	var ja3Response JA3Response
-	if err := json.Unmarshal(respBody, &ja3Response); err != nil {
+	var f echo.Fingerprint
+	if err := json.Unmarshal(respBody, &f); err != nil {
 		t.Fatal(err)
 	}
 
-	if ja3Response.JA3Hash != Chrome83Hash {
-		t.Error("unexpected JA3 hash; expected:", Chrome83Hash, "| got:", ja3Response.JA3Hash)
+	return resp, &f
+}
+
+func TestCClient_JA3(t *testing.T) {
+	_, f := getFingerprint(t, client)
+
+	if f.JA3Hash != Chrome83Hash {
+		t.Error("unexpected JA3 hash; expected:", Chrome83Hash, "| got:", f.JA3Hash)
 	}
 }
 
 func TestCClient_HTTP2(t *testing.T) {
-	resp, err := client.Get("https://www.google.com")
+	resp, f := getFingerprint(t, client)
+
+	if resp.ProtoMajor != 2 || resp.ProtoMinor != 0 {
+		t.Error("unexpected response proto; expected: HTTP/2.0 | got: ", resp.Proto)
+	}
+	if f.Akamai == "" {
+		t.Error("unexpected Akamai fingerprint:", f.Akamai)
+	}
+}
+
+func TestCClient_Fingerprint(t *testing.T) {
+	ja3 := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-21,29-23-24,0"
+
+	adapter := NewAdapter(tls.HelloChrome_83)
+	adapter.Fingerprint = ja3
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = readAndClose(resp.Body)
+	resp, f := getFingerprint(t, client)
+
+	if f.JA3 != ja3 {
+		t.Fatalf(tests.MismatchFormat, "JA3", ja3, f.JA3)
+	}
+	if resp.ProtoMajor != 1 {
+		t.Error("unexpected response proto; expected: HTTP/1.1 | got: ", resp.Proto)
+	}
+}
+
+func TestCClient_ConnectionReuse(t *testing.T) {
+	adapter := NewAdapter(tls.HelloChrome_83)
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if resp.ProtoMajor != 2 || resp.ProtoMinor != 0 {
-		t.Error("unexpected response proto; expected: HTTP/2.0 | got: ", resp.Proto)
+	get := func() {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err = readAndClose(resp.Body); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// concurrent requests are safe, including the ones that create the connection pool
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get()
+		}()
+	}
+	wg.Wait()
+
+	adapter.CloseIdleConnections()
+	before := settledHandshakes()
+	for i := 0; i < 3; i++ {
+		get()
+	}
+	if n := settledHandshakes() - before; n != 1 {
+		t.Fatalf(tests.MismatchFormat, "handshakes", 1, n)
+	}
+}
+
+// settledHandshakes returns the number of handshakes of the server once it stops changing,
+// because the server may count a handshake after the client already sent its request.
+func settledHandshakes() int {
+	n := server.Handshakes()
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+		m := server.Handshakes()
+		if m == n {
+			break
+		}
+		n = m
+	}
+	return n
+}
+
+func TestCClient_ConcurrentConnections(t *testing.T) {
+	adapter := NewAdapter(tls.HelloChrome_83)
+	defer adapter.CloseIdleConnections()
+	before := settledHandshakes()
+
+	// every request learns the protocol of the server with a connection of its own
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// clients can't be shared between goroutines, the adapter can
+			client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			readAndClose(resp.Body)
+		}()
+	}
+	wg.Wait()
+
+	// each of those connections is pooled by HTTP/2 instead of being left behind for later requests
+	rt, err := adapter.roundTripper(adapter.ClientHello, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.h2Pool.mu.Lock()
+	pooled := 0
+	for _, conns := range rt.h2Pool.conns {
+		pooled += len(conns)
+	}
+	rt.h2Pool.mu.Unlock()
+	if n := settledHandshakes() - before; n != pooled {
+		t.Fatalf(tests.MismatchFormat, "pooled connections", n, pooled)
+	}
+}
+
+func TestCClient_HTTP2DialContext(t *testing.T) {
+	// a server that never completes the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalled := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(stalled)
+				return
+			}
+			stalled <- conn
+		}
+	}()
+
+	adapter := NewAdapter(tls.HelloChrome_83)
+	defer adapter.CloseIdleConnections()
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new HTTP/2 connections are dialed with the context of the request, so the stalled handshake is aborted
+	rt, err := adapter.roundTripper(adapter.ClientHello, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.protocols[ln.Addr().String()] = http2.NextProtoTLS
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get("https://"+ln.Addr().String(), &gotcha.Options{RequestContext: ctx})
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Fatal("expected the request to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handshake to be aborted")
+	}
+
+	ln.Close()
+	for conn := range stalled {
+		conn.Close()
 	}
 }
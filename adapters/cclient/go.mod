@@ -5,5 +5,10 @@ go 1.16
 require (
 	github.com/refraction-networking/utls v0.0.0-20210713165636-0b2885c8c0d4
 	github.com/sleeyax/gotcha v0.0.2
-	github.com/x04/cclient v0.0.0-20210412053913-a370c55a8adf
+	github.com/sleeyax/gotcha/fingerprint v0.0.0-00010101000000-000000000000
+	golang.org/x/net v0.0.0-20210610132358-84b48f89b13b
 )
+
+replace github.com/sleeyax/gotcha/fingerprint => ../../fingerprint
+
+replace github.com/sleeyax/gotcha => ../..
@@ -0,0 +1,275 @@
+package cclient
+
+// this code is based on the round tripper of https://github.com/x04/cclient,
+// which doesn't support concurrent requests and can't close its idle connections.
+
+import (
+	"context"
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"github.com/sleeyax/gotcha"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roundTripper sends requests over connections with the same ClientHello and proxy.
+// HTTPS requests are sent over HTTP/2 or HTTP/1.1, depending on the protocol the server negotiates with ALPN.
+type roundTripper struct {
+	clientHello tls.ClientHelloID
+	dialer      *gotcha.ProxyDialer
+
+	h1     *http.Transport
+	h2     *http2.Transport
+	h2Pool *h2Pool
+
+	mu sync.Mutex
+	// protocol the server negotiated, per address
+	protocols map[string]string
+}
+
+// pendingConn is a connection that was dialed to learn the protocol of an address,
+// waiting to be used by h1 for the request that dialed it.
+type pendingConn struct {
+	mu   sync.Mutex
+	conn *tls.UConn
+}
+
+type pendingConnKey struct{}
+
+// take returns the connection, or nil if it was taken before.
+func (pc *pendingConn) take() *tls.UConn {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	conn := pc.conn
+	pc.conn = nil
+	return conn
+}
+
+func newRoundTripper(clientHello tls.ClientHelloID, proxy *url.URL) *roundTripper {
+	rt := &roundTripper{
+		clientHello: clientHello,
+		dialer:      &gotcha.ProxyDialer{Proxy: proxy},
+		protocols:   make(map[string]string),
+	}
+
+	rt.h1 = &http.Transport{
+		DialContext: rt.dialer.DialContext,
+		DialTLSContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			// ctx carries the values of the request
+			if pc, ok := ctx.Value(pendingConnKey{}).(*pendingConn); ok {
+				if conn := pc.take(); conn != nil {
+					return conn, nil
+				}
+			}
+			conn, err := rt.dialTLS(ctx, addr, "http/1.1")
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+
+	rt.h2Pool = &h2Pool{rt: rt, conns: make(map[string][]*http2.ClientConn)}
+	rt.h2 = &http2.Transport{ConnPool: rt.h2Pool}
+
+	return rt
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch strings.ToLower(req.URL.Scheme) {
+	case "http":
+		return rt.h1.RoundTrip(req)
+	case "https":
+	default:
+		return nil, fmt.Errorf("invalid URL scheme: [%v]", req.URL.Scheme)
+	}
+
+	addr := dialAddr(req.URL)
+
+	rt.mu.Lock()
+	protocol, ok := rt.protocols[addr]
+	rt.mu.Unlock()
+
+	if ok {
+		if protocol == http2.NextProtoTLS {
+			return rt.h2.RoundTrip(req)
+		}
+		return rt.h1.RoundTrip(req)
+	}
+
+	// the connection that's used to learn the protocol is only handed to the request that dialed it
+	conn, err := rt.dial(req.Context(), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if negotiatedProtocol(conn) == http2.NextProtoTLS {
+		cc, err := rt.h2Pool.add(addr, conn)
+		if err != nil {
+			return nil, err
+		}
+		return cc.RoundTrip(req)
+	}
+
+	// h1 only dials when it has no idle connection for the request, in which case the pending connection isn't needed
+	pc := &pendingConn{conn: conn}
+	res, err := rt.h1.RoundTrip(req.WithContext(context.WithValue(req.Context(), pendingConnKey{}, pc)))
+	if conn := pc.take(); conn != nil {
+		conn.Close()
+	}
+	if res != nil {
+		res.Request = req
+	}
+	return res, err
+}
+
+// dialTLS dials a new connection to addr.
+// An error is returned when the server doesn't negotiate protocol,
+// the next request to addr is then sent with the transport of the negotiated protocol instead.
+func (rt *roundTripper) dialTLS(ctx context.Context, addr string, protocol string) (*tls.UConn, error) {
+	conn, err := rt.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if p := negotiatedProtocol(conn); p != protocol {
+		conn.Close()
+		return nil, fmt.Errorf("cclient: server at %s negotiated protocol %q instead of %q", addr, p, protocol)
+	}
+
+	return conn, nil
+}
+
+// dial connects to addr and performs the TLS handshake with the ClientHello of the round tripper.
+func (rt *roundTripper) dial(ctx context.Context, addr string) (*tls.UConn, error) {
+	rawConn, err := rt.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var host string
+	if host, _, err = net.SplitHostPort(addr); err != nil {
+		host = addr
+	}
+
+	conn := tls.UClient(rawConn, &tls.Config{ServerName: host}, rt.clientHello)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err = conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	rt.mu.Lock()
+	rt.protocols[addr] = negotiatedProtocol(conn)
+	rt.mu.Unlock()
+
+	return conn, nil
+}
+
+// CloseIdleConnections closes the connections that aren't in use.
+func (rt *roundTripper) CloseIdleConnections() {
+	rt.h1.CloseIdleConnections()
+	rt.h2Pool.closeIdleConnections()
+}
+
+// h2Pool is the http2.ClientConnPool of a roundTripper.
+// Unlike the default pool, it dials with the context of the request and accepts the connections the round tripper dialed itself.
+type h2Pool struct {
+	rt *roundTripper
+
+	mu    sync.Mutex
+	conns map[string][]*http2.ClientConn
+}
+
+func (p *h2Pool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	p.mu.Lock()
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			p.mu.Unlock()
+			return cc, nil
+		}
+	}
+	p.mu.Unlock()
+
+	conn, err := p.rt.dialTLS(req.Context(), addr, http2.NextProtoTLS)
+	if err != nil {
+		return nil, err
+	}
+	return p.add(addr, conn)
+}
+
+// add adds a connection to addr that negotiated HTTP/2 to the pool.
+func (p *h2Pool) add(addr string, conn *tls.UConn) (*http2.ClientConn, error) {
+	cc, err := p.rt.h2.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.conns[addr] = append(p.conns[addr], cc)
+	p.mu.Unlock()
+
+	return cc, nil
+}
+
+// MarkDead removes cc from the pool, http2.Transport calls it when cc is closed.
+func (p *h2Pool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for i, c := range conns {
+			if c == cc {
+				p.conns[addr] = append(conns[:i:i], conns[i+1:]...)
+				if len(p.conns[addr]) == 0 {
+					delete(p.conns, addr)
+				}
+				return
+			}
+		}
+	}
+}
+
+// closeIdleConnections removes all connections from the pool and closes them once their requests are done.
+func (p *h2Pool) closeIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for _, cc := range conns {
+			go cc.Shutdown(context.Background())
+		}
+		delete(p.conns, addr)
+	}
+}
+
+// negotiatedProtocol returns the protocol that was negotiated with ALPN, which is http/1.1 if ALPN wasn't used.
+func negotiatedProtocol(conn *tls.UConn) string {
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "" {
+		return p
+	}
+	return "http/1.1"
+}
+
+// dialAddr returns the host and port of u, using the default port of the scheme if none is specified.
+func dialAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "http":
+			port = "80"
+		default:
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
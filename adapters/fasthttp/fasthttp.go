@@ -2,119 +2,348 @@ package fasthttp
 
 import (
 	"bytes"
-	"encoding/base64"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/sleeyax/gotcha"
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpadaptor"
-	"github.com/valyala/fasthttp/fasthttpproxy"
-	"io/ioutil"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-type Adapter struct{}
+var BodyClosedError = errors.New("fasthttp: read on closed response body")
+
+type Adapter struct {
+	// Client that sends requests without a proxy.
+	// Requests through a proxy are sent by a client per proxy, which is created with the same configuration.
+	//
+	// Defaults to a fasthttp.Client that uses TLSConfig.
+	Client *fasthttp.Client
+
+	// Optional TLS configuration of the clients the adapter creates.
+	TLSConfig *tls.Config
+
+	// StreamResponseBody makes the default Client stream response bodies from the connection instead of reading them into memory.
+	// The connection is returned to the pool when the body is closed, so response bodies must always be closed.
+	// Set Client.StreamResponseBody instead when providing a Client.
+	StreamResponseBody bool
+
+	mu      sync.Mutex
+	clients map[string]*fasthttp.Client
+}
 
 func NewAdapter() *Adapter {
 	return &Adapter{}
 }
 
+// Capabilities reports that header order and casing are honored.
+// The special header processing of fasthttp is disabled, so it sends every header as is,
+// but it doesn't add a User-Agent or Content-Type header either.
+func (a *Adapter) Capabilities() gotcha.Capabilities {
+	return gotcha.Capabilities{HeaderOrder: true, HeaderCasing: true}
+}
+
+// DoRequest sends the request with the client of options.Proxy.
+// The request is aborted when options.Timeout elapses or when the deadline of options.RequestContext is exceeded,
+// canceling the context itself isn't supported by fasthttp.
 func (a *Adapter) DoRequest(options *gotcha.Options) (*gotcha.Response, error) {
+	client := a.client(options.Proxy)
+
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
+	// released by toResponse, or by closing the body when it's streamed
 	res := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(res)
 
 	req.Header.SetMethod(options.Method)
-	req.Header.SetRequestURI(options.FullUrl.RequestURI())
+	req.SetRequestURI(options.FullUrl.String())
 
-	for key, values := range options.Headers {
-		for _, value := range values {
-			req.Header.Add(key, value)
+	var body []byte
+	if options.Body != nil {
+		var err error
+		body, err = io.ReadAll(options.Body)
+		options.Body.Close()
+		if err != nil {
+			return nil, err
 		}
+		req.SetBody(body)
 	}
 
-	var c *fasthttp.Client
+	var cookies []*http.Cookie
+	if options.CookieJar != nil {
+		cookies = options.CookieJar.Cookies(options.FullUrl)
+	}
+	setHeaders(&req.Header, options, cookies, len(body))
 
-	if options.Proxy != nil {
-		proxy := options.Proxy.RequestURI()
-		c.Dial = fasthttpproxy.FasthttpHTTPDialer(proxy)
-		if v := strings.Split(proxy, ":"); len(v) >= 4 {
-			var auth = "Basic "
-			if len(v) == 5 {
-				auth += base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", v[1], v[2])))
-			} else {
-				auth += base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", v[0], v[1])))
-			}
+	var err error
+	if deadline, ok := deadline(options); ok {
+		err = client.DoDeadline(req, res, deadline)
+	} else {
+		err = client.Do(req, res)
+	}
+	if err != nil {
+		fasthttp.ReleaseResponse(res)
+		return nil, err
+	}
 
-			req.Header.Set("Proxy-Authorization", auth)
+	r := toResponse(res, options, client.StreamResponseBody)
+
+	if options.CookieJar != nil {
+		if rc := r.Cookies(); len(rc) > 0 {
+			options.CookieJar.SetCookies(options.FullUrl, rc)
 		}
 	}
 
-	if options.CookieJar != nil {
-		var cookies []*http.Cookie
-		res.Header.VisitAllCookie(func(key, value []byte) {
-			sk := string(key)
-			sv := string(value)
-			cookies = append(cookies, &http.Cookie{
-				Name:       sk,
-				Value:      sv,
-				Domain:     string(req.RequestURI()),
-			})
-		})
+	return &gotcha.Response{Response: r, UnmarshalJsonFunc: options.UnmarshalJson}, nil
+}
 
-		options.CookieJar.SetCookies(options.FullUrl, cookies)
+// setHeaders adds the headers of options to h in the order of options.HeaderOrder, with their original casing.
+// fasthttp only sends the headers that are added when its special header processing is disabled,
+// so the Host and Content-Length headers are added here, and the cookies are added to the Cookie header.
+func setHeaders(h *fasthttp.RequestHeader, options *gotcha.Options, cookies []*http.Cookie, length int) {
+	o := *options
+	o.Headers = options.Headers.Clone()
+	if o.Headers == nil {
+		o.Headers = make(http.Header)
 	}
 
-	if err := c.Do(req, res); err != nil {
-		return nil, err
+	// Host goes first unless its position is specified
+	if len(o.HeaderOrder) == 0 {
+		o.HeaderOrder = o.Headers[gotcha.HeaderOrderKey]
+	}
+	if !containsFold(o.HeaderOrder, "Host") {
+		o.HeaderOrder = append([]string{"Host"}, o.HeaderOrder...)
+	}
+	if headerKey(o.Headers, "Host") == "" {
+		o.Headers["Host"] = []string{options.FullUrl.Host}
 	}
 
-	reqCtx := &fasthttp.RequestCtx{}
+	method := o.Method
+	if headerKey(o.Headers, "Content-Length") == "" && (length > 0 || method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch) {
+		o.Headers["Content-Length"] = []string{strconv.Itoa(length)}
+	}
 
-	req.CopyTo(&reqCtx.Request)
-	res.CopyTo(&reqCtx.Response)
+	if len(cookies) > 0 {
+		var values []string
+		for _, cookie := range cookies {
+			values = append(values, (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
+		}
+		if key := headerKey(o.Headers, "Cookie"); key != "" && len(o.Headers[key]) != 0 {
+			o.Headers[key] = []string{o.Headers[key][0] + "; " + strings.Join(values, "; ")}
+		} else {
+			o.Headers["Cookie"] = []string{strings.Join(values, "; ")}
+		}
+	}
 
-	r, err := toResponse(reqCtx)
-	if err != nil {
-		return nil, err
+	h.DisableSpecialHeader()
+	h.DisableNormalizing()
+	for _, key := range o.OrderedHeaderKeys() {
+		for _, value := range o.Headers[key] {
+			h.Add(key, value)
+		}
+	}
+}
+
+// headerKey returns the key of h that equals name, ignoring case, or an empty string if there is none.
+func headerKey(h http.Header, name string) string {
+	if _, ok := h[name]; ok {
+		return name
 	}
+	for key := range h {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return ""
+}
 
-	return &gotcha.Response{r, options.UnmarshalJson}, nil
+// containsFold reports whether keys contains name, ignoring case.
+func containsFold(keys []string, name string) bool {
+	for _, key := range keys {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
 }
 
-func toResponse(ctx *fasthttp.RequestCtx) (*http.Response, error) {
-	var r *http.Request
+// client returns the client that sends requests through proxy, creating it if needed.
+func (a *Adapter) client(proxy *url.URL) *fasthttp.Client {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	if err := fasthttpadaptor.ConvertRequest(ctx, r, false); err != nil {
-		return nil, err
+	if a.Client == nil {
+		a.Client = &fasthttp.Client{TLSConfig: a.TLSConfig, StreamResponseBody: a.StreamResponseBody}
+	}
+	if proxy == nil {
+		return a.Client
+	}
+
+	key := proxy.String()
+	if c, ok := a.clients[key]; ok {
+		return c
+	}
+
+	c := newClient(a.Client, proxyDialer(proxy, a.TLSConfig))
+	if c.TLSConfig == nil {
+		c.TLSConfig = a.TLSConfig
+	}
+	if a.clients == nil {
+		a.clients = make(map[string]*fasthttp.Client)
 	}
+	a.clients[key] = c
+
+	return c
+}
+
+// CloseIdleConnections closes the connections of all clients that aren't in use.
+func (a *Adapter) CloseIdleConnections() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	resp := &http.Response{
-		Status:           statusMap[ctx.Response.StatusCode()],
-		StatusCode:       ctx.Response.StatusCode(),
-		Proto:            "HTTP/1.1",
-		ProtoMajor:       1,
-		ProtoMinor:       1,
-		Body:             ioutil.NopCloser(bytes.NewBuffer(ctx.Response.Body())),
-		ContentLength:    int64(len(ctx.Response.Body())),
-		Uncompressed:     false,
-		Request:          r,
-		TLS:              ctx.TLSConnectionState(),
-	}
-
-	ctx.Response.Header.VisitAll(func(k, v []byte) {
-		sk := string(k)
-		sv := string(v)
-
-		switch sk {
-		case "Transfer-Encoding":
-			resp.TransferEncoding = append(resp.TransferEncoding, sv)
-		default:
-			r.Header.Set(sk, sv)
+	if a.Client != nil {
+		a.Client.CloseIdleConnections()
+	}
+	for _, c := range a.clients {
+		c.CloseIdleConnections()
+	}
+}
+
+// newClient returns a client with the configuration of template that connects with dial.
+func newClient(template *fasthttp.Client, dial fasthttp.DialFunc) *fasthttp.Client {
+	return &fasthttp.Client{
+		Name:                          template.Name,
+		NoDefaultUserAgentHeader:      template.NoDefaultUserAgentHeader,
+		Dial:                          dial,
+		DialDualStack:                 template.DialDualStack,
+		TLSConfig:                     template.TLSConfig,
+		MaxConnsPerHost:               template.MaxConnsPerHost,
+		MaxIdleConnDuration:           template.MaxIdleConnDuration,
+		MaxConnDuration:               template.MaxConnDuration,
+		MaxIdemponentCallAttempts:     template.MaxIdemponentCallAttempts,
+		ReadBufferSize:                template.ReadBufferSize,
+		WriteBufferSize:               template.WriteBufferSize,
+		ReadTimeout:                   template.ReadTimeout,
+		WriteTimeout:                  template.WriteTimeout,
+		MaxResponseBodySize:           template.MaxResponseBodySize,
+		DisableHeaderNamesNormalizing: template.DisableHeaderNamesNormalizing,
+		DisablePathNormalizing:        template.DisablePathNormalizing,
+		MaxConnWaitTimeout:            template.MaxConnWaitTimeout,
+		RetryIf:                       template.RetryIf,
+		StreamResponseBody:            template.StreamResponseBody,
+	}
+}
+
+// proxyDialer returns a function that connects to addresses through proxy, see gotcha.ProxyDialer for the supported proxies.
+func proxyDialer(proxy *url.URL, tlsConfig *tls.Config) fasthttp.DialFunc {
+	d := &gotcha.ProxyDialer{Proxy: proxy, TLSConfig: tlsConfig}
+	return func(addr string) (net.Conn, error) {
+		return d.DialContext(context.Background(), "tcp", addr)
+	}
+}
+
+// deadline returns the earliest of options.Timeout and the deadline of options.RequestContext.
+func deadline(options *gotcha.Options) (time.Time, bool) {
+	var d time.Time
+	if options.Timeout > 0 {
+		d = time.Now().Add(options.Timeout)
+	}
+	if ctx := options.RequestContext; ctx != nil {
+		if cd, ok := ctx.Deadline(); ok && (d.IsZero() || cd.Before(d)) {
+			d = cd
 		}
+	}
+	return d, !d.IsZero()
+}
+
+// toResponse converts res to a http.Response.
+// When stream is true the body is read from the connection and res is released when it's closed,
+// otherwise the body is copied and res is released right away.
+func toResponse(res *fasthttp.Response, options *gotcha.Options, stream bool) *http.Response {
+	code := res.StatusCode()
 
+	r := &http.Response{
+		Status:     strconv.Itoa(code) + " " + statusMap[code],
+		StatusCode: code,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request: &http.Request{
+			Method: options.Method,
+			URL:    options.FullUrl,
+			Header: options.Headers,
+		},
+	}
+	if !res.Header.IsHTTP11() {
+		r.Proto, r.ProtoMinor = "HTTP/1.0", 0
+	}
+
+	res.Header.VisitAll(func(k, v []byte) {
+		r.Header.Add(string(k), string(v))
 	})
 
-	return resp, nil
+	if stream && res.BodyStream() != nil {
+		r.Body = &bodyStream{res: res}
+		r.ContentLength = int64(res.Header.ContentLength())
+		if r.ContentLength < 0 {
+			r.ContentLength = -1
+		}
+		return r
+	}
+
+	body := append([]byte{}, res.Body()...)
+	fasthttp.ReleaseResponse(res)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	return r
+}
+
+// bodyStream reads the body of a streamed response.
+// Closing it releases the response and returns the connection to the pool,
+// or closes the connection if the body wasn't read until the end.
+type bodyStream struct {
+	mu  sync.Mutex
+	res *fasthttp.Response
+	eof bool
+}
+
+func (b *bodyStream) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.res == nil {
+		return 0, BodyClosedError
+	}
+
+	n, err := b.res.BodyStream().Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *bodyStream) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.res == nil {
+		return nil
+	}
+
+	if !b.eof {
+		// the rest of the body is still on the connection, so it can't be reused
+		b.res.SetConnectionClose()
+	}
+	err := b.res.CloseBodyStream()
+	fasthttp.ReleaseResponse(b.res)
+	b.res = nil
+
+	return err
 }
@@ -0,0 +1,348 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/internal/echo"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newGotchaClient(t *testing.T, adapter *Adapter) *gotcha.Client {
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func mustText(t *testing.T, res *gotcha.Response) string {
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAdapter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Add("X-Values", "a")
+		w.Header().Add("X-Values", "b")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Header.Get("X-Test") + ":" + string(b)))
+	}))
+	defer ts.Close()
+
+	adapter := NewAdapter()
+	defer adapter.CloseIdleConnections()
+
+	res, err := newGotchaClient(t, adapter).Post(ts.URL, &gotcha.Options{
+		Headers: http.Header{"X-Test": {"gotcha"}},
+		Body:    io.NopCloser(strings.NewReader("body")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != "201 Created" || res.StatusCode != http.StatusCreated {
+		t.Fatalf(tests.MismatchFormat, "status", "201 Created", res.Status)
+	}
+	if m := res.Header.Get("X-Method"); m != http.MethodPost {
+		t.Fatalf(tests.MismatchFormat, "X-Method header", http.MethodPost, m)
+	}
+	if v := res.Header.Values("X-Values"); len(v) != 2 || v[0] != "a" || v[1] != "b" {
+		t.Fatalf(tests.MismatchFormat, "X-Values header", []string{"a", "b"}, v)
+	}
+	if res.Request == nil || res.Request.URL.String() != ts.URL {
+		t.Fatalf("expected the request to be set, but got %+v instead", res.Request)
+	}
+	if text := mustText(t, res); text != "gotcha:body" {
+		t.Fatalf(tests.MismatchFormat, "body", "gotcha:body", text)
+	}
+}
+
+func TestAdapter_Cookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: NewAdapter(), CookieJar: jar})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"", "session=abc"} {
+		res, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := mustText(t, res); text != expected {
+			t.Fatalf(tests.MismatchFormat, "cookie", expected, text)
+		}
+	}
+}
+
+func TestAdapter_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	if _, err := newGotchaClient(t, NewAdapter()).Get(ts.URL, &gotcha.Options{Timeout: 20 * time.Millisecond}); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
+
+func TestAdapter_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer ts.Close()
+
+	adapter := NewAdapter()
+	adapter.TLSConfig = &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+
+	res, err := newGotchaClient(t, adapter).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "secure" {
+		t.Fatalf(tests.MismatchFormat, "body", "secure", text)
+	}
+}
+
+func TestAdapter_Fingerprint(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	// fasthttp speaks HTTP/1.1 only and sends the ClientHello of crypto/tls without ALPN, just like net/http without HTTP/2
+	var fingerprints []echo.Fingerprint
+	for _, adapter := range []gotcha.Adapter{
+		&Adapter{TLSConfig: &tls.Config{RootCAs: server.CertPool()}},
+		&gotcha.RequestAdapter{RoundTripper: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: server.CertPool()}}},
+	} {
+		client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var f echo.Fingerprint
+		err = json.NewDecoder(res.Body).Decode(&f)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprints = append(fingerprints, f)
+	}
+
+	f, expected := fingerprints[0], fingerprints[1]
+	if f.Protocol != "HTTP/1.1" || len(f.ALPN) != 0 {
+		t.Fatalf(tests.MismatchFormat, "protocol", "HTTP/1.1 without ALPN", f.Protocol+" "+strings.Join(f.ALPN, ","))
+	}
+	if f.JA3 == "" || f.JA3 != expected.JA3 {
+		t.Fatalf(tests.MismatchFormat, "JA3 fingerprint", expected.JA3, f.JA3)
+	}
+}
+
+func TestAdapter_HeaderOrder(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "abc"}})
+
+	adapter := &Adapter{TLSConfig: &tls.Config{RootCAs: server.CertPool()}}
+	if c := gotcha.AdapterCapabilities(adapter); !c.HeaderOrder || !c.HeaderCasing {
+		t.Fatalf("expected header order and casing to be honored, but got %+v", c)
+	}
+
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter, CookieJar: jar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Post(server.URL, &gotcha.Options{
+		Headers: http.Header{
+			"user-agent":   {"gotcha"},
+			"content-type": {"text/plain"},
+			"cookie":       {"theme=dark"},
+			"X-Test":       {"gotcha"},
+		},
+		HeaderOrder: []string{"x-test", "cookie", "content-type", "user-agent"},
+		Body:        io.NopCloser(strings.NewReader("body")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f echo.Fingerprint
+	err = json.NewDecoder(res.Body).Decode(&f)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"Host: " + u.Host,
+		"X-Test: gotcha",
+		"cookie: theme=dark; session=abc",
+		"content-type: text/plain",
+		"user-agent: gotcha",
+		"Content-Length: 4",
+	}
+	if strings.Join(f.Headers, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf(tests.MismatchFormat, "headers", expected, f.Headers)
+	}
+}
+
+func TestAdapter_Proxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	defer target.Close()
+
+	var connects int32
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") == "" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer httpProxy.Close()
+
+	socksProxy := tests.NewSOCKSServer("user", "pass")
+	defer socksProxy.Close()
+
+	httpProxyUrl, _ := url.Parse(httpProxy.URL)
+	httpProxyUrl.User = url.UserPassword("user", "pass")
+	proxies := []*url.URL{httpProxyUrl}
+	for _, scheme := range []string{"socks5", "socks5h", "socks4a"} {
+		proxies = append(proxies, &url.URL{Scheme: scheme, Host: socksProxy.Addr, User: url.UserPassword("user", "pass")})
+	}
+
+	adapter := NewAdapter()
+	defer adapter.CloseIdleConnections()
+	client := newGotchaClient(t, adapter)
+
+	for _, proxy := range proxies {
+		res, err := client.Get(target.URL, &gotcha.Options{Proxy: proxy})
+		if err != nil {
+			t.Fatalf("%s: %v", proxy.Scheme, err)
+		}
+		if text := mustText(t, res); text != "proxied" {
+			t.Fatalf(tests.MismatchFormat, proxy.Scheme+" body", "proxied", text)
+		}
+	}
+
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "CONNECT requests", 1, n)
+	}
+	if requests := socksProxy.Requests(); len(requests) != 3 {
+		t.Fatalf(tests.MismatchFormat, "SOCKS requests", 3, len(requests))
+	}
+
+	if _, err := client.Get(target.URL, &gotcha.Options{Proxy: &url.URL{Scheme: "ftp", Host: "127.0.0.1:1"}}); err == nil {
+		t.Fatal("expected an error for an unsupported proxy scheme")
+	}
+}
+
+func TestAdapter_StreamResponseBody(t *testing.T) {
+	release := make(chan struct{})
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first,"))
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/wait" {
+			<-release
+		}
+		w.Write([]byte("second"))
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	adapter := NewAdapter()
+	adapter.StreamResponseBody = true
+	defer adapter.CloseIdleConnections()
+	client := newGotchaClient(t, adapter)
+
+	// the response is returned before the server finished writing the body
+	res, err := client.Get(ts.URL + "/wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len("first,"))
+	if _, err = io.ReadFull(res.Body, b); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if text := string(b) + mustText(t, res); text != "first,second" {
+		t.Fatalf(tests.MismatchFormat, "body", "first,second", text)
+	}
+	if _, err = res.Body.Read(b); err != BodyClosedError {
+		t.Fatalf(tests.MismatchFormat, "error", BodyClosedError, err)
+	}
+
+	// a body that was read until the end returns its connection to the pool
+	res, err = client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustText(t, res)
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "connections", 1, n)
+	}
+
+	// a body that was closed early closes its connection
+	res, err = client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res, err = client.Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "first,second" {
+		t.Fatalf(tests.MismatchFormat, "body", "first,second", text)
+	}
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Fatalf(tests.MismatchFormat, "connections", 2, n)
+	}
+}
@@ -1,8 +1,21 @@
 module github.com/sleeyax/gotcha/adapters/fasthttp
 
-go 1.16
+go 1.20
 
 require (
 	github.com/sleeyax/gotcha v0.0.2
-	github.com/valyala/fasthttp v1.29.0
+	github.com/valyala/fasthttp v1.50.0
 )
+
+require (
+	github.com/Sleeyax/urlValues v1.0.0 // indirect
+	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/imdario/mergo v0.3.13 // indirect
+	github.com/klauspost/compress v1.16.3 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/sleeyax/gotcha => ../..
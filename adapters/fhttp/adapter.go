@@ -2,35 +2,82 @@ package fhttp
 
 import (
 	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/profiles"
 	fhttp "github.com/useflyent/fhttp"
 	"net/http"
+	"net/url"
+	"sync"
 )
 
 type Adapter struct {
 	// Optional fhttp Transport options.
+	//
+	// Requests with Options.Proxy are sent by a copy of Transport that tunnels its connections through the proxy.
+	// The copy performs the TLS handshake with TLSClientConfig, so these requests fail with ProxyDialTLSError
+	// when DialTLS or DialTLSContext is set, since these can't dial through a proxy.
 	Transport *fhttp.Transport
+
+	// Optional HTTP/2 fingerprint: the SETTINGS (in order), connection WINDOW_UPDATE and PRIORITY frames
+	// sent after the connection preface, and the priority of HEADERS frames.
+	// Leave nil to use the defaults of fhttp.
+	//
+	// Use ApplyAkamai to set it from an Akamai fingerprint.
+	HTTP2 *profiles.HTTP2
+
+	// Optional order of pseudo headers, used when Options.PseudoHeaderOrder is empty.
+	PseudoHeaderOrder []string
+
+	// transports built from Transport and HTTP2 per proxy, the key of requests without a proxy is empty
+	transports map[string]*fhttp.Transport
+	base       *fhttp.Transport
+	http2      *profiles.HTTP2
+	// HTTP/2 connection pools of the transports that send the HTTP2 fingerprint, with the same keys as transports
+	pools map[string]*http2ConnPool
+	mu    sync.Mutex
 }
 
 func NewAdapter() *Adapter {
 	return &Adapter{}
 }
 
+// Capabilities reports that header order, pseudo header order and casing are honored.
+func (a *Adapter) Capabilities() gotcha.Capabilities {
+	return gotcha.Capabilities{HeaderOrder: true, PseudoHeaderOrder: true, HeaderCasing: true}
+}
+
 func (a *Adapter) DoRequest(options *gotcha.Options) (*gotcha.Response, error) {
+	header := fhttp.Header(options.Headers.Clone())
+	if header == nil {
+		header = make(fhttp.Header)
+	}
+	// fhttp matches the keys in the header order exactly, so pass the keys as they appear in the header
+	if len(options.HeaderOrder) != 0 || len(header[fhttp.HeaderOrderKey]) != 0 {
+		header[fhttp.HeaderOrderKey] = options.OrderedHeaderKeys()
+	}
+	a.mu.Lock()
+	pseudoHeaderOrder := a.PseudoHeaderOrder
+	a.mu.Unlock()
+	if len(options.PseudoHeaderOrder) != 0 {
+		header[fhttp.PHeaderOrderKey] = options.PseudoHeaderOrder
+	} else if len(pseudoHeaderOrder) != 0 {
+		header[fhttp.PHeaderOrderKey] = pseudoHeaderOrder
+	}
+
 	req := &fhttp.Request{
 		Method: options.Method,
 		URL:    options.FullUrl,
-		Header: fhttp.Header(options.Headers.Clone()),
+		Header: header,
 		Body:   options.Body,
 	}
 
-	if a.Transport == nil {
-		a.Transport = fhttp.DefaultTransport.(*fhttp.Transport)
+	if ctx := options.RequestContext; ctx != nil {
+		req = req.WithContext(ctx)
 	}
 
-	// TODO: fix upstream: proxies seem to not work with custom TLS settings via DialTLSContext. As a work around, ssers should connect to the proxy in DialTLScontext instead.
-	/*if options.Proxy != nil {
-		a.Transport.Proxy = fhttp.ProxyURL(options.Proxy)
-	}*/
+	transport, err := a.transport(options.Proxy)
+	if err != nil {
+		return nil, err
+	}
 
 	if options.CookieJar != nil {
 		for _, cookie := range options.CookieJar.Cookies(options.FullUrl) {
@@ -38,7 +85,7 @@ func (a *Adapter) DoRequest(options *gotcha.Options) (*gotcha.Response, error) {
 		}
 	}
 
-	res, err := a.Transport.RoundTrip(req)
+	res, err := transport.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +101,94 @@ func (a *Adapter) DoRequest(options *gotcha.Options) (*gotcha.Response, error) {
 	return &gotcha.Response{r, options.UnmarshalJson}, nil
 }
 
+// ApplyProfile configures the adapter to use the HTTP/2 fingerprint of p.
+// The TLS fingerprint of p isn't supported by fhttp, the ClientHello of Transport is sent instead.
+func (a *Adapter) ApplyProfile(p *profiles.Profile) error {
+	h := p.HTTP2
+	a.mu.Lock()
+	a.HTTP2 = &h
+	a.PseudoHeaderOrder = p.PseudoHeaderOrder
+	a.mu.Unlock()
+	return nil
+}
+
+// ApplyAkamai configures the adapter to use the HTTP/2 settings and pseudo header order of an Akamai fingerprint.
+// See profiles.ParseAkamai.
+func (a *Adapter) ApplyAkamai(fingerprint string) error {
+	h, pseudoHeaderOrder, err := profiles.ParseAkamai(fingerprint)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.HTTP2 = &h
+	a.PseudoHeaderOrder = pseudoHeaderOrder
+	a.mu.Unlock()
+	return nil
+}
+
+// transport returns the transport to make requests through proxy with.
+// When HTTP2 is set, this is a copy of Transport that sends its fingerprint.
+// Transports are rebuilt when either field changes.
+func (a *Adapter) transport(proxy *url.URL) (*fhttp.Transport, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Transport == nil {
+		a.Transport = fhttp.DefaultTransport.(*fhttp.Transport)
+	}
+
+	if a.transports == nil || a.base != a.Transport || a.http2 != a.HTTP2 {
+		for key, t := range a.transports {
+			if t != a.base {
+				t.CloseIdleConnections()
+			}
+			if pool := a.pools[key]; pool != nil {
+				pool.closeIdleConnections()
+			}
+		}
+		a.transports, a.pools, a.base, a.http2 = make(map[string]*fhttp.Transport), make(map[string]*http2ConnPool), a.Transport, a.HTTP2
+	}
+
+	var key string
+	if proxy != nil {
+		key = proxy.String()
+	}
+	if t, ok := a.transports[key]; ok {
+		return t, nil
+	}
+
+	t := a.Transport
+	var pool *http2ConnPool
+	var err error
+	if proxy != nil {
+		t, pool, err = newProxyTransport(a.Transport, proxy, a.HTTP2)
+	} else if a.HTTP2 != nil {
+		t, pool, err = newHTTP2Transport(a.Transport, a.HTTP2)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.transports[key] = t
+	if pool != nil {
+		a.pools[key] = pool
+	}
+
+	return t, nil
+}
+
+// CloseIdleConnections closes the connections of Transport and the transports built from it that aren't in use.
+func (a *Adapter) CloseIdleConnections() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, t := range a.transports {
+		t.CloseIdleConnections()
+		if pool := a.pools[key]; pool != nil {
+			pool.closeIdleConnections()
+		}
+	}
+}
+
 // toResponse converts fhttp response to an original http response.
 func toResponse(req *fhttp.Request, res *fhttp.Response) *http.Response {
 	return &http.Response{
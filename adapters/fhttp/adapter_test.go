@@ -0,0 +1,276 @@
+package fhttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/internal/echo"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"github.com/sleeyax/gotcha/profiles"
+	fhttp "github.com/useflyent/fhttp"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newClient(t *testing.T, adapter *Adapter) *gotcha.Client {
+	adapter.Transport = &fhttp.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// getFingerprint requests the fingerprint of client from the echo server at url.
+func getFingerprint(t *testing.T, client *gotcha.Client, url string, options ...*gotcha.Options) *echo.Fingerprint {
+	res, err := client.Get(url, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var f echo.Fingerprint
+	if err = json.NewDecoder(res.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	return &f
+}
+
+// newStdClient returns a client that makes requests with net/http through transport.
+func newStdClient(t *testing.T, transport *http.Transport) *gotcha.Client {
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: &gotcha.RequestAdapter{RoundTripper: transport}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAdapter_HTTP2(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	adapter := NewAdapter()
+	if err := adapter.ApplyProfile(profiles.Firefox65Windows); err != nil {
+		t.Fatal(err)
+	}
+	f := getFingerprint(t, newClient(t, adapter), server.URL)
+
+	if f.Protocol != "HTTP/2.0" {
+		t.Fatalf(tests.MismatchFormat, "protocol", "HTTP/2.0", f.Protocol)
+	}
+	if expected := profiles.Firefox65Windows.Akamai(); f.Akamai != expected {
+		t.Fatalf(tests.MismatchFormat, "Akamai fingerprint", expected, f.Akamai)
+	}
+
+	// the ClientHello is the one of crypto/tls
+	ts := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true}
+	defer ts.CloseIdleConnections()
+	g := getFingerprint(t, newStdClient(t, ts), server.URL)
+	if f.JA3 != g.JA3 {
+		t.Fatalf(tests.MismatchFormat, "JA3 fingerprint", g.JA3, f.JA3)
+	}
+}
+
+func TestAdapter_HTTP2FlowControl(t *testing.T) {
+	body := bytes.Repeat([]byte("gotcha"), 2<<20)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	adapter := NewAdapter()
+	// a stream window larger than the one of fhttp and a connection window smaller than the response
+	if err := adapter.ApplyAkamai("1:65536;3:1000;4:6291456;6:262144|65535|0|m,a,s,p"); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, adapter)
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ProtoMajor != 2 {
+			t.Fatalf("expected HTTP/2, but got %s instead", res.Proto)
+		}
+
+		// give the server time to fill the stream window
+		time.Sleep(100 * time.Millisecond)
+
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, body) {
+			t.Fatalf("expected a body of %d bytes, but got %d bytes instead", len(body), len(b))
+		}
+	}
+}
+
+func TestAdapter_ApplyAkamai(t *testing.T) {
+	adapter := NewAdapter()
+	if err := adapter.ApplyAkamai(profiles.Chrome83Windows.Akamai()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(adapter.HTTP2.Settings, profiles.Chrome83Windows.HTTP2.Settings) || !reflect.DeepEqual(adapter.PseudoHeaderOrder, profiles.Chrome83Windows.PseudoHeaderOrder) {
+		t.Fatalf("unexpected fingerprint %+v %v", adapter.HTTP2, adapter.PseudoHeaderOrder)
+	}
+
+	if err := adapter.ApplyAkamai("invalid"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestAdapter_CloseIdleConnections(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	adapter := NewAdapter()
+	if err := adapter.ApplyProfile(profiles.Chrome83Windows); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, adapter)
+
+	for i := 0; i < 2; i++ {
+		getFingerprint(t, client, server.URL)
+	}
+	if n := server.Handshakes(); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "handshakes", 1, n)
+	}
+
+	// the HTTP/2 connections of the fingerprint are closed too
+	adapter.CloseIdleConnections()
+	getFingerprint(t, client, server.URL)
+	if n := server.Handshakes(); n != 2 {
+		t.Fatalf(tests.MismatchFormat, "handshakes", 2, n)
+	}
+
+	// and when the transport is rebuilt for another fingerprint
+	pool := adapter.pools[""]
+	if err := adapter.ApplyProfile(profiles.Firefox65Windows); err != nil {
+		t.Fatal(err)
+	}
+	getFingerprint(t, client, server.URL)
+	pool.mu.Lock()
+	n := len(pool.conns)
+	pool.mu.Unlock()
+	if n != 0 {
+		t.Fatalf(tests.MismatchFormat, "pooled connections of the old transport", 0, n)
+	}
+	adapter.CloseIdleConnections()
+}
+
+func TestAdapter_Proxy(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	proxyUrl := &url.URL{Scheme: "http", User: url.UserPassword("user", "pass")}
+	var connects int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+	proxyUrl.Host = proxy.Listener.Addr().String()
+
+	adapter := NewAdapter()
+	if err := adapter.ApplyAkamai("1:65536;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p"); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, adapter)
+
+	// HTTP/2 connections are reused
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(ts.URL, &gotcha.Options{Proxy: proxyUrl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(b) != "HTTP/2.0" || res.TLS == nil {
+			t.Fatalf(tests.MismatchFormat, "protocol", "HTTP/2.0", string(b))
+		}
+	}
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "CONNECT requests", 1, n)
+	}
+
+	proxyUrl.User = nil
+	if _, err := client.Get(ts.URL, &gotcha.Options{Proxy: proxyUrl}); err == nil {
+		t.Fatal("expected an error for a proxy that requires authentication")
+	}
+
+	// a custom TLS dialer would connect to the server directly
+	adapter = NewAdapter()
+	client = newClient(t, adapter)
+	adapter.Transport.DialTLS = func(network string, addr string) (net.Conn, error) {
+		return tls.Dial(network, addr, adapter.Transport.TLSClientConfig)
+	}
+	if _, err := client.Get(ts.URL, &gotcha.Options{Proxy: proxyUrl}); !errors.Is(err, ProxyDialTLSError) {
+		t.Fatalf(tests.MismatchFormat, "error", ProxyDialTLSError, err)
+	}
+}
+
+func TestAdapter_ProxyHeaderOrder(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	proxy := tests.NewSOCKSServer("", "")
+	defer proxy.Close()
+
+	f := getFingerprint(t, newClient(t, NewAdapter()), "http://"+strings.TrimPrefix(server.URL, "https://"), &gotcha.Options{
+		Proxy:       &url.URL{Scheme: "socks5", Host: proxy.Addr},
+		Headers:     http.Header{"b": {"2"}, "a": {"1"}, "c": {"3"}},
+		HeaderOrder: []string{"b", "c", "a"},
+	})
+
+	var keys []string
+	for _, line := range f.Headers {
+		if key := line[:strings.Index(line, ":")]; key == "b" || key == "a" || key == "c" {
+			keys = append(keys, key)
+		}
+	}
+	if expected := []string{"b", "c", "a"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf(tests.MismatchFormat, "header order", expected, keys)
+	}
+	if requests := proxy.Requests(); len(requests) != 1 {
+		t.Fatalf(tests.MismatchFormat, "SOCKS5 requests", 1, len(requests))
+	}
+}
+
+var _ net.Conn = (*http2Conn)(nil)
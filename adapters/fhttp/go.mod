@@ -6,3 +6,5 @@ require (
 	github.com/sleeyax/gotcha v0.1.1
 	github.com/useflyent/fhttp v0.0.0-20211004035111-333f430cfbbf
 )
+
+replace github.com/sleeyax/gotcha => ../..
@@ -0,0 +1,434 @@
+package fhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/sleeyax/gotcha/profiles"
+	fhttp "github.com/useflyent/fhttp"
+	"github.com/useflyent/fhttp/http2"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	frameHeaderLen = 9
+
+	// minMaxFrameSize is the smallest SETTINGS_MAX_FRAME_SIZE a server can announce.
+	minMaxFrameSize = 1 << 14
+
+	// initialWindowSize is the flow control window of a connection before any WINDOW_UPDATE.
+	initialWindowSize = 65535
+
+	// transportDefaultStreamFlow is the receive window fhttp grants every stream,
+	// regardless of the SETTINGS_INITIAL_WINDOW_SIZE it sends.
+	transportDefaultStreamFlow = 4 << 20
+)
+
+// newHTTP2Transport returns a copy of t1 that speaks HTTP/2 with the fingerprint h, and the pool of its HTTP/2 connections.
+//
+// fhttp always sends its own SETTINGS and connection WINDOW_UPDATE frames and never sends PRIORITY frames,
+// so every HTTP/2 connection is wrapped in an http2Conn that rewrites them.
+func newHTTP2Transport(t1 *fhttp.Transport, h *profiles.HTTP2) (*fhttp.Transport, *http2ConnPool, error) {
+	t1 = t1.Clone()
+	pool, err := configureHTTP2(t1, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t1, pool, nil
+}
+
+// configureHTTP2 configures t1 to speak HTTP/2 with the fingerprint h.
+// t1.CloseIdleConnections doesn't close the HTTP/2 connections of the returned pool, use its closeIdleConnections instead.
+func configureHTTP2(t1 *fhttp.Transport, h *profiles.HTTP2) (*http2ConnPool, error) {
+	t1.ForceAttemptHTTP2 = true
+
+	t2, err := http2.ConfigureTransports(t1)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &http2ConnPool{conns: make(map[string][]*http2.ClientConn)}
+	t2.ConnPool = pool
+
+	// the transport must decode headers and handle pushes according to the settings that are actually sent
+	push := true
+	for _, s := range h.Settings {
+		switch http2.SettingID(s.ID) {
+		case http2.SettingHeaderTableSize:
+			t2.HeaderTableSize = s.Val
+		case http2.SettingMaxHeaderListSize:
+			t2.MaxHeaderListSize = s.Val
+		case http2.SettingEnablePush:
+			push = s.Val != 0
+		}
+	}
+	if push {
+		t2.PushHandler = cancelPushes{}
+	}
+
+	t1.TLSNextProto["h2"] = func(authority string, c *tls.Conn) fhttp.RoundTripper {
+		cc, err := t2.NewClientConn(newHTTP2Conn(c, h))
+		if err != nil {
+			c.Close()
+			return erringRoundTripper{err}
+		}
+		pool.add(authority, cc)
+		return t2
+	}
+
+	return pool, nil
+}
+
+// http2ConnPool is the pool of HTTP/2 connections of a transport configured by configureHTTP2.
+// Connections are added to it by the transport after it dialed them.
+type http2ConnPool struct {
+	mu    sync.Mutex
+	conns map[string][]*http2.ClientConn
+}
+
+func (p *http2ConnPool) GetClientConn(req *fhttp.Request, addr string) (*http2.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			return cc, nil
+		}
+	}
+
+	// makes the transport dial a new connection
+	return nil, http2.ErrNoCachedConn
+}
+
+func (p *http2ConnPool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for i, c := range conns {
+			if c == cc {
+				p.conns[addr] = append(conns[:i:i], conns[i+1:]...)
+				if len(p.conns[addr]) == 0 {
+					delete(p.conns, addr)
+				}
+				return
+			}
+		}
+	}
+}
+
+func (p *http2ConnPool) add(addr string, cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.conns[addr] = append(p.conns[addr], cc)
+}
+
+// closeIdleConnections removes all connections from the pool and closes them once their requests are done.
+func (p *http2ConnPool) closeIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for _, cc := range conns {
+			go cc.Shutdown(context.Background())
+		}
+		delete(p.conns, addr)
+	}
+}
+
+// cancelPushes cancels every pushed stream.
+type cancelPushes struct{}
+
+func (cancelPushes) HandlePush(*http2.PushedRequest) {}
+
+// erringRoundTripper makes fhttp return err when a connection couldn't be set up.
+type erringRoundTripper struct {
+	err error
+}
+
+func (rt erringRoundTripper) RoundTripErr() error {
+	return rt.err
+}
+
+func (rt erringRoundTripper) RoundTrip(*fhttp.Request) (*fhttp.Response, error) {
+	return nil, rt.err
+}
+
+// http2Conn rewrites the HTTP/2 frames fhttp exchanges over a connection to match an HTTP/2 fingerprint.
+//
+// The initial SETTINGS and WINDOW_UPDATE frames are replaced by those of the fingerprint, followed by its PRIORITY frames,
+// and the priority of the fingerprint is added to HEADERS frames.
+// Since the server then uses other flow control windows than the ones fhttp believes it granted,
+// http2Conn also takes over connection-level flow control and holds back DATA frames that would
+// overflow the stream-level window of fhttp until fhttp grants more.
+type http2Conn struct {
+	*tls.Conn
+	http2 *profiles.HTTP2
+
+	// guards writes to the connection
+	wmu sync.Mutex
+	// bytes written by fhttp that don't form a complete frame yet
+	wbuf []byte
+	// whether the client preface and the initial SETTINGS frame have been written
+	wrotePreface  bool
+	wroteSettings bool
+
+	// guards windows and closed
+	mu   sync.Mutex
+	cond *sync.Cond
+	// stream-level receive windows of the open streams, as fhttp tracks them
+	windows map[uint32]int64
+	closed  bool
+
+	// unread bytes of the last frame that was read
+	rbuf []byte
+	// connection-level flow controlled bytes received since the last WINDOW_UPDATE
+	received uint32
+}
+
+func newHTTP2Conn(conn *tls.Conn, h *profiles.HTTP2) *http2Conn {
+	c := &http2Conn{Conn: conn, http2: h, windows: make(map[uint32]int64)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *http2Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, p...)
+
+	var out []byte
+	if !c.wrotePreface {
+		if len(c.wbuf) < len(http2.ClientPreface) {
+			return len(p), nil
+		}
+		out = append(out, c.wbuf[:len(http2.ClientPreface)]...)
+		c.wbuf = c.wbuf[len(http2.ClientPreface):]
+		c.wrotePreface = true
+	}
+
+	for len(c.wbuf) >= frameHeaderLen {
+		fh, err := http2.ReadFrameHeader(bytes.NewReader(c.wbuf))
+		if err != nil {
+			return 0, err
+		}
+		n := frameHeaderLen + int(fh.Length)
+		if len(c.wbuf) < n {
+			break
+		}
+		out = c.rewrite(out, fh, c.wbuf[frameHeaderLen:n])
+		c.wbuf = c.wbuf[n:]
+	}
+	if len(c.wbuf) == 0 {
+		c.wbuf = nil
+	}
+
+	if len(out) != 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// rewrite appends the frame fhttp wrote, or the frames to send instead, to out.
+func (c *http2Conn) rewrite(out []byte, fh http2.FrameHeader, payload []byte) []byte {
+	switch fh.Type {
+	case http2.FrameSettings:
+		if !c.wroteSettings && !fh.Flags.Has(http2.FlagSettingsAck) {
+			c.wroteSettings = true
+			return c.appendSettings(out)
+		}
+	case http2.FrameWindowUpdate:
+		if fh.StreamID == 0 {
+			// see receiveData
+			return out
+		}
+		c.mu.Lock()
+		if _, ok := c.windows[fh.StreamID]; ok {
+			c.windows[fh.StreamID] += int64(binary.BigEndian.Uint32(payload) & (1<<31 - 1))
+			c.cond.Broadcast()
+		}
+		c.mu.Unlock()
+	case http2.FrameRSTStream:
+		c.closeStream(fh.StreamID)
+	case http2.FrameHeaders:
+		c.mu.Lock()
+		if _, ok := c.windows[fh.StreamID]; !ok {
+			c.windows[fh.StreamID] = transportDefaultStreamFlow
+		}
+		c.mu.Unlock()
+
+		if p := c.http2.HeaderPriority; p != nil && !fh.Flags.Has(http2.FlagHeadersPriority) && fh.Length+5 <= minMaxFrameSize {
+			var prefix []byte
+			if fh.Flags.Has(http2.FlagHeadersPadded) && len(payload) != 0 {
+				prefix, payload = payload[:1], payload[1:]
+			}
+			b := appendPriority(append([]byte{}, prefix...), *p)
+			return appendFrame(out, http2.FrameHeaders, fh.Flags|http2.FlagHeadersPriority, fh.StreamID, append(b, payload...))
+		}
+	}
+
+	return appendFrame(out, fh.Type, fh.Flags, fh.StreamID, payload)
+}
+
+// appendSettings appends the SETTINGS, WINDOW_UPDATE and PRIORITY frames of the fingerprint to out.
+func (c *http2Conn) appendSettings(out []byte) []byte {
+	var settings []byte
+	for _, s := range c.http2.Settings {
+		settings = append(settings, byte(s.ID>>8), byte(s.ID))
+		settings = appendUint32(settings, s.Val)
+	}
+	out = appendFrame(out, http2.FrameSettings, 0, 0, settings)
+
+	if c.http2.ConnectionFlow != 0 {
+		out = appendFrame(out, http2.FrameWindowUpdate, 0, 0, appendUint32(nil, c.http2.ConnectionFlow))
+	}
+
+	for _, f := range c.http2.PriorityFrames {
+		out = appendFrame(out, http2.FramePriority, 0, f.StreamID, appendPriority(nil, f.HTTP2Priority))
+	}
+
+	return out
+}
+
+func (c *http2Conn) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+
+	return n, nil
+}
+
+// readFrame reads the next frame from the connection into rbuf.
+func (c *http2Conn) readFrame() error {
+	fh, err := http2.ReadFrameHeader(c.Conn)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, frameHeaderLen+int(fh.Length))
+	appendFrameHeader(frame[:0], fh.Type, fh.Flags, fh.StreamID, int(fh.Length))
+	if _, err = io.ReadFull(c.Conn, frame[frameHeaderLen:]); err != nil {
+		return err
+	}
+	payload := frame[frameHeaderLen:]
+
+	switch fh.Type {
+	case http2.FrameData:
+		if err = c.receiveData(fh); err != nil {
+			return err
+		}
+	case http2.FrameHeaders:
+		if fh.Flags.Has(http2.FlagHeadersEndStream) {
+			c.closeStream(fh.StreamID)
+		}
+	case http2.FrameRSTStream:
+		c.closeStream(fh.StreamID)
+	case http2.FramePushPromise:
+		if fh.Flags.Has(http2.FlagPushPromisePadded) && len(payload) != 0 {
+			payload = payload[1:]
+		}
+		if len(payload) >= 4 {
+			c.mu.Lock()
+			c.windows[binary.BigEndian.Uint32(payload)&(1<<31-1)] = transportDefaultStreamFlow
+			c.mu.Unlock()
+		}
+	}
+
+	c.rbuf = frame
+
+	return nil
+}
+
+// receiveData waits until fhttp can receive the DATA frame fh and does the connection-level flow control.
+func (c *http2Conn) receiveData(fh http2.FrameHeader) error {
+	c.mu.Lock()
+	for {
+		w, ok := c.windows[fh.StreamID]
+		if !ok || w >= int64(fh.Length) || c.closed {
+			break
+		}
+		c.cond.Wait()
+	}
+	if _, ok := c.windows[fh.StreamID]; ok {
+		c.windows[fh.StreamID] -= int64(fh.Length)
+		if fh.Flags.Has(http2.FlagDataEndStream) {
+			delete(c.windows, fh.StreamID)
+		}
+	}
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return net.ErrClosed
+	}
+
+	// fhttp grants a connection-level window of 1GB, so data is acknowledged on receipt
+	// like browsers do, once half of the window of the fingerprint is used up.
+	c.received += fh.Length
+	if c.received >= (initialWindowSize+c.http2.ConnectionFlow)/2 {
+		c.wmu.Lock()
+		_, err := c.Conn.Write(appendFrame(nil, http2.FrameWindowUpdate, 0, 0, appendUint32(nil, c.received)))
+		c.wmu.Unlock()
+		if err != nil {
+			return err
+		}
+		c.received = 0
+	}
+
+	return nil
+}
+
+func (c *http2Conn) closeStream(id uint32) {
+	c.mu.Lock()
+	delete(c.windows, id)
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+func (c *http2Conn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+// ConnectionState makes fhttp report the TLS connection state of responses.
+func (c *http2Conn) ConnectionState() tls.ConnectionState {
+	return c.Conn.ConnectionState()
+}
+
+func appendFrame(b []byte, t http2.FrameType, flags http2.Flags, streamID uint32, payload []byte) []byte {
+	return append(appendFrameHeader(b, t, flags, streamID, len(payload)), payload...)
+}
+
+func appendFrameHeader(b []byte, t http2.FrameType, flags http2.Flags, streamID uint32, length int) []byte {
+	b = append(b, byte(length>>16), byte(length>>8), byte(length), byte(t), byte(flags))
+	return appendUint32(b, streamID&(1<<31-1))
+}
+
+func appendPriority(b []byte, p profiles.HTTP2Priority) []byte {
+	dep := p.StreamDep & (1<<31 - 1)
+	if p.Exclusive {
+		dep |= 1 << 31
+	}
+	return append(appendUint32(b, dep), p.Weight)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,108 @@
+package fhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/profiles"
+	fhttp "github.com/useflyent/fhttp"
+	"github.com/useflyent/fhttp/http2"
+	"net"
+	"net/url"
+	"time"
+)
+
+// ProxyDialTLSError is returned for requests through a proxy when the Transport has a custom TLS dialer,
+// since fhttp can't tunnel the connections of DialTLS or DialTLSContext through the proxy.
+var ProxyDialTLSError = errors.New("fhttp: DialTLS and DialTLSContext can't be used with a proxy")
+
+// newProxyTransport returns a copy of t that tunnels its connections through proxy.
+// When h is set, the copy speaks HTTP/2 with this fingerprint and the pool of its HTTP/2 connections is returned too.
+//
+// fhttp performs the TLS handshake with the proxy instead of the server when the transport has a custom TLS dialer,
+// and skips the custom TLS dialer when it has a proxy.
+// So instead of setting the Proxy of the transport, every connection is tunneled through the proxy by the dialers of the copy,
+// which perform the TLS handshake with the TLSClientConfig of t.
+// ProxyDialTLSError is returned when t has a custom TLS dialer, rather than skipping it and sending another ClientHello.
+func newProxyTransport(t *fhttp.Transport, proxy *url.URL, h *profiles.HTTP2) (*fhttp.Transport, *http2ConnPool, error) {
+	if t.DialTLS != nil || t.DialTLSContext != nil {
+		return nil, nil, ProxyDialTLSError
+	}
+
+	c := t.Clone()
+	c.Proxy = nil
+
+	// the HTTP/2 connections of t would be shared with the copy, so HTTP/2 is configured again if t uses it
+	_, h2 := t.TLSNextProto[http2.NextProtoTLS]
+	c.TLSNextProto = make(map[string]func(string, *tls.Conn) fhttp.RoundTripper)
+	var pool *http2ConnPool
+	if h != nil {
+		var err error
+		if pool, err = configureHTTP2(c, h); err != nil {
+			return nil, nil, err
+		}
+	} else if h2 {
+		if _, err := http2.ConfigureTransports(c); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	d := &gotcha.ProxyDialer{Proxy: proxy, Dial: t.DialContext, TLSConfig: proxyTLSConfig(c.TLSClientConfig)}
+	if d.Dial == nil && t.Dial != nil {
+		d.Dial = func(_ context.Context, network string, addr string) (net.Conn, error) {
+			return t.Dial(network, addr)
+		}
+	}
+
+	c.Dial = nil
+	c.DialContext = d.DialContext
+	c.DialTLSContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if c.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.TLSHandshakeTimeout)
+			defer cancel()
+		}
+
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &tls.Config{}
+		if c.TLSClientConfig != nil {
+			config = c.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			if config.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+				config.ServerName = addr
+			}
+		}
+
+		tc := tls.Client(conn, config)
+		if deadline, ok := ctx.Deadline(); ok {
+			tc.SetDeadline(deadline)
+		}
+		if err = tc.Handshake(); err != nil {
+			tc.Close()
+			return nil, err
+		}
+		tc.SetDeadline(time.Time{})
+
+		return tc, nil
+	}
+
+	return c, pool, nil
+}
+
+// proxyTLSConfig returns the TLS configuration to connect to HTTPS proxies with.
+// It's config without the server name and protocols that are meant for the server.
+func proxyTLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		return nil
+	}
+	config = config.Clone()
+	config.ServerName = ""
+	config.NextProtos = nil
+	return config
+}
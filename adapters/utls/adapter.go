@@ -0,0 +1,166 @@
+package utls
+
+import (
+	"context"
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/profiles"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Adapter makes requests over connections that perform the TLS handshake with utls.
+// HTTPS requests are sent over HTTP/2 when the server negotiates it with ALPN and over HTTP/1.1 otherwise.
+//
+// Connections are pooled per ClientHello and proxy.
+// TLSConfig, ALPN, Dial and IdleConnTimeout are read when the pool of a ClientHello and proxy is created,
+// so they should be set before the first request.
+type Adapter struct {
+	// Optional proxy to connect to.
+	proxyUrl string
+
+	// TLS client hello ID to use.
+	// Use WithClientHello to send a different ClientHello for a single request.
+	ClientHello tls.ClientHelloID
+
+	// Optional TLS configuration.
+	// ServerName defaults to the host of the request.
+	TLSConfig *tls.Config
+
+	// Protocols to offer with ALPN, in order of preference.
+	// Only h2 and http/1.1 are supported.
+	//
+	// Defaults to the protocols of ClientHello.
+	// The ALPN extension isn't added to ClientHellos that don't have one.
+	ALPN []string
+
+	// Dial connects to the address on the named network.
+	//
+	// Defaults to net.Dialer.DialContext.
+	Dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	// Maximum amount of time an idle HTTP/1.1 connection is kept before it's closed.
+	// Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+
+	mu         sync.Mutex
+	transports map[transportKey]*transport
+}
+
+// transportKey identifies the transport that pools the connections of a ClientHello and proxy.
+type transportKey struct {
+	clientHello tls.ClientHelloID
+	proxy       string
+}
+
+type clientHelloKey struct{}
+
+func parseProxy(proxies []string) string {
+	if len(proxies) == 0 {
+		return ""
+	}
+	return proxies[0]
+}
+
+func NewAdapter(clientHello tls.ClientHelloID, proxyUrl ...string) *Adapter {
+	return &Adapter{
+		proxyUrl:    parseProxy(proxyUrl),
+		ClientHello: clientHello,
+	}
+}
+
+// WithClientHello returns a copy of ctx that makes the Adapter send clientHello instead of Adapter.ClientHello.
+// Set it as the Options.RequestContext of a request to switch ClientHellos per request,
+// which is safe while other requests are in flight, unlike changing Adapter.ClientHello.
+func WithClientHello(ctx context.Context, clientHello tls.ClientHelloID) context.Context {
+	return context.WithValue(ctx, clientHelloKey{}, clientHello)
+}
+
+// Capabilities reports that header order can't be controlled, because the adapter is based on net/http.
+func (a *Adapter) Capabilities() gotcha.Capabilities {
+	return gotcha.Capabilities{}
+}
+
+// ApplyProfile configures the adapter to send the TLS ClientHello of p.
+// An error is returned when utls doesn't know the ClientHello.
+// The HTTP/2 fingerprint of p isn't supported, HTTP/2 connections use the settings of golang.org/x/net/http2.
+// Set ALPN to http/1.1 to avoid sending HTTP/2 settings that don't match p.
+func (a *Adapter) ApplyProfile(p *profiles.Profile) error {
+	clientHello := tls.ClientHelloID{Client: p.ClientHello.Client, Version: p.ClientHello.Version}
+	// building the ClientHello fails for IDs without a preset
+	if err := tls.UClient(nil, &tls.Config{ServerName: "localhost"}, clientHello).BuildHandshakeState(); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	a.mu.Lock()
+	a.ClientHello = clientHello
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Adapter) DoRequest(options *gotcha.Options) (*gotcha.Response, error) {
+	a.mu.Lock()
+	clientHello := a.ClientHello
+	proxy := a.proxyUrl
+	a.mu.Unlock()
+
+	if ctx := options.RequestContext; ctx != nil {
+		if id, ok := ctx.Value(clientHelloKey{}).(tls.ClientHelloID); ok {
+			clientHello = id
+		}
+	}
+	if options.Proxy != nil {
+		proxy = options.Proxy.String()
+	}
+
+	t, err := a.transport(clientHello, proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	requestAdapter := gotcha.RequestAdapter{
+		RoundTripper: t,
+	}
+
+	return requestAdapter.DoRequest(options)
+}
+
+// transport returns the transport for clientHello and proxy, creating it if needed.
+func (a *Adapter) transport(clientHello tls.ClientHelloID, proxy string) (*transport, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := transportKey{clientHello, proxy}
+	if t, ok := a.transports[key]; ok {
+		return t, nil
+	}
+
+	var proxyUrl *url.URL
+	if proxy != "" {
+		var err error
+		if proxyUrl, err = url.Parse(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	t := newTransport(clientHello, proxyUrl, a)
+	if a.transports == nil {
+		a.transports = make(map[transportKey]*transport)
+	}
+	a.transports[key] = t
+
+	return t, nil
+}
+
+// CloseIdleConnections closes the connections of all ClientHellos and proxies that aren't in use.
+func (a *Adapter) CloseIdleConnections() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, t := range a.transports {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,424 @@
+package utls
+
+import (
+	"context"
+	"encoding/json"
+	tls "github.com/refraction-networking/utls"
+	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/internal/echo"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"github.com/sleeyax/gotcha/profiles"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const Chrome83Hash = "b32309a26951912be7dba376398abc3b"
+
+func getFingerprint(t *testing.T, client *gotcha.Client, url string, options ...*gotcha.Options) (*gotcha.Response, *echo.Fingerprint) {
+	res, err := client.Get(url, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var f echo.Fingerprint
+	if err = json.NewDecoder(res.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	return res, &f
+}
+
+func newClient(t *testing.T, adapter *Adapter) *gotcha.Client {
+	client, err := gotcha.NewClient(&gotcha.Options{Adapter: adapter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAdapter(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	adapter := NewAdapter(tls.HelloChrome_83)
+	adapter.TLSConfig = &tls.Config{RootCAs: server.CertPool()}
+	defer adapter.CloseIdleConnections()
+
+	res, f := getFingerprint(t, newClient(t, adapter), server.URL)
+	if res.Proto != "HTTP/2.0" || f.Protocol != "HTTP/2.0" {
+		t.Fatalf(tests.MismatchFormat, "protocol", "HTTP/2.0", res.Proto)
+	}
+	if f.JA3Hash != Chrome83Hash {
+		t.Fatalf(tests.MismatchFormat, "JA3 hash", Chrome83Hash, f.JA3Hash)
+	}
+}
+
+func TestAdapter_ApplyProfile(t *testing.T) {
+	adapter := NewAdapter(tls.HelloGolang)
+	if err := adapter.ApplyProfile(profiles.Chrome83Windows); err != nil {
+		t.Fatal(err)
+	}
+	if adapter.ClientHello != tls.HelloChrome_83 {
+		t.Fatalf(tests.MismatchFormat, "ClientHello", tls.HelloChrome_83, adapter.ClientHello)
+	}
+
+	// ClientHellos without a preset are rejected instead of failing every handshake
+	p := *profiles.Chrome83Windows
+	p.ClientHello.Version = "0"
+	if err := adapter.ApplyProfile(&p); err == nil {
+		t.Fatal("expected an error for an unknown ClientHello")
+	}
+	if adapter.ClientHello != tls.HelloChrome_83 {
+		t.Fatalf(tests.MismatchFormat, "ClientHello", tls.HelloChrome_83, adapter.ClientHello)
+	}
+}
+
+func TestAdapter_ApplyProfile_All(t *testing.T) {
+	adapter := NewAdapter(tls.HelloGolang)
+	for _, p := range profiles.All() {
+		if err := adapter.ApplyProfile(p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+}
+
+func TestAdapter_ALPN(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	adapter := NewAdapter(tls.HelloChrome_83)
+	adapter.TLSConfig = &tls.Config{RootCAs: server.CertPool()}
+	adapter.ALPN = []string{"http/1.1"}
+	defer adapter.CloseIdleConnections()
+
+	res, f := getFingerprint(t, newClient(t, adapter), server.URL)
+	if res.Proto != "HTTP/1.1" {
+		t.Fatalf(tests.MismatchFormat, "protocol", "HTTP/1.1", res.Proto)
+	}
+	if !reflect.DeepEqual(f.ALPN, adapter.ALPN) {
+		t.Fatalf(tests.MismatchFormat, "ALPN", adapter.ALPN, f.ALPN)
+	}
+}
+
+func TestAdapter_WithClientHello(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	adapter := NewAdapter(tls.HelloChrome_83)
+	adapter.TLSConfig = &tls.Config{RootCAs: server.CertPool()}
+	defer adapter.CloseIdleConnections()
+	client := newClient(t, adapter)
+
+	_, firefox := getFingerprint(t, client, server.URL, &gotcha.Options{RequestContext: WithClientHello(context.Background(), tls.HelloFirefox_65)})
+	if firefox.JA3Hash == Chrome83Hash {
+		t.Fatal("expected the ClientHello of the request context to be used")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		clientHello, expected := tls.HelloChrome_83, Chrome83Hash
+		if i%2 == 0 {
+			clientHello, expected = tls.HelloFirefox_65, firefox.JA3Hash
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(server.URL, &gotcha.Options{RequestContext: WithClientHello(context.Background(), clientHello)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+
+			var f echo.Fingerprint
+			if err = json.NewDecoder(res.Body).Decode(&f); err != nil {
+				t.Error(err)
+				return
+			}
+			if f.JA3Hash != expected {
+				t.Errorf(tests.MismatchFormat, "JA3 hash", expected, f.JA3Hash)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAdapter_ConnectionReuse(t *testing.T) {
+	for _, test := range []struct {
+		alpn     []string
+		protocol string
+	}{
+		{nil, "HTTP/2.0"},
+		{[]string{"http/1.1"}, "HTTP/1.1"},
+	} {
+		var conns int32
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}))
+		ts.EnableHTTP2 = true
+		ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		ts.StartTLS()
+
+		adapter := NewAdapter(tls.HelloChrome_83)
+		adapter.TLSConfig = &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+		adapter.ALPN = test.alpn
+		client := newClient(t, adapter)
+
+		for i := 0; i < 3; i++ {
+			res, err := client.Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != test.protocol {
+				t.Fatalf(tests.MismatchFormat, "protocol", test.protocol, string(b))
+			}
+		}
+
+		adapter.CloseIdleConnections()
+		ts.Close()
+
+		if n := atomic.LoadInt32(&conns); n != 1 {
+			t.Fatalf(tests.MismatchFormat, test.protocol+" connections", 1, n)
+		}
+	}
+}
+
+func TestAdapter_ConcurrentConnections(t *testing.T) {
+	for _, alpn := range [][]string{nil, {"http/1.1"}} {
+		var mu sync.Mutex
+		conns := make(map[net.Conn]http.ConnState)
+		active := make(map[net.Conn]bool)
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}))
+		ts.EnableHTTP2 = true
+		ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			mu.Lock()
+			conns[conn] = state
+			if state == http.StateActive {
+				active[conn] = true
+			}
+			mu.Unlock()
+		}
+		ts.StartTLS()
+
+		adapter := NewAdapter(tls.HelloChrome_83)
+		adapter.TLSConfig = &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+		adapter.ALPN = alpn
+		client := newClient(t, adapter)
+
+		// every request learns the protocol of the server with a connection of its own
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := client.Get(ts.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.ReadAll(res.Body)
+				res.Body.Close()
+			}()
+		}
+		wg.Wait()
+
+		// net/http may keep a connection it dialed for a request that got another one in the meantime,
+		// HTTP/2 connections are used by the request that dialed them
+		mu.Lock()
+		unused := len(conns) - len(active)
+		mu.Unlock()
+		if alpn == nil && unused != 0 {
+			t.Fatalf(tests.MismatchFormat, "unused connections", 0, unused)
+		}
+
+		// none of those connections is left behind
+		adapter.CloseIdleConnections()
+		deadline := time.Now().Add(time.Second)
+		for {
+			open := 0
+			mu.Lock()
+			for _, state := range conns {
+				if state != http.StateClosed {
+					open++
+				}
+			}
+			mu.Unlock()
+			if open == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf(tests.MismatchFormat, "open connections", 0, open)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		ts.Close()
+	}
+}
+
+// stallingListener hands its first connection to the server and keeps the others open without serving them.
+type stallingListener struct {
+	net.Listener
+	accepted int32
+	stalled  chan net.Conn
+}
+
+func (l *stallingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if atomic.AddInt32(&l.accepted, 1) == 1 {
+			return conn, nil
+		}
+		l.stalled <- conn
+	}
+}
+
+func TestAdapter_HTTP2DialContext(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.EnableHTTP2 = true
+	l := &stallingListener{Listener: ts.Listener, stalled: make(chan net.Conn, 10)}
+	ts.Listener = l
+	ts.StartTLS()
+
+	adapter := NewAdapter(tls.HelloChrome_83)
+	adapter.TLSConfig = &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	defer adapter.CloseIdleConnections()
+	client := newClient(t, adapter)
+
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// the next HTTP/2 connection is dialed with the context of the request, so the stalled handshake is aborted
+	adapter.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get(ts.URL, &gotcha.Options{RequestContext: ctx})
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Fatal("expected the request to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handshake to be aborted")
+	}
+
+	ts.Close()
+	close(l.stalled)
+	for conn := range l.stalled {
+		conn.Close()
+	}
+}
+
+func TestAdapter_Proxy(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+
+	var connects int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	adapter := NewAdapter(tls.HelloChrome_83, proxy.URL)
+	adapter.TLSConfig = &tls.Config{RootCAs: server.CertPool()}
+	defer adapter.CloseIdleConnections()
+
+	res, f := getFingerprint(t, newClient(t, adapter), server.URL)
+	if res.Proto != "HTTP/2.0" || f.JA3Hash != Chrome83Hash {
+		t.Fatalf("unexpected response %s with JA3 hash %s", res.Proto, f.JA3Hash)
+	}
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "CONNECT requests", 1, n)
+	}
+
+	// the proxy of the request takes precedence
+	proxyUrl, _ := url.Parse("http://127.0.0.1:1")
+	if _, err := newClient(t, adapter).Get(server.URL, &gotcha.Options{Proxy: proxyUrl}); err == nil {
+		t.Fatal("expected an error for an unreachable proxy")
+	}
+}
+
+func TestAdapter_SOCKSProxy(t *testing.T) {
+	server := echo.NewServer()
+	defer server.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+	defer ts.Close()
+
+	proxy := tests.NewSOCKSServer("user", "pass")
+	defer proxy.Close()
+
+	adapter := NewAdapter(tls.HelloChrome_83, "socks5h://user:pass@"+proxy.Addr)
+	adapter.TLSConfig = &tls.Config{RootCAs: server.CertPool()}
+	defer adapter.CloseIdleConnections()
+	client := newClient(t, adapter)
+
+	_, f := getFingerprint(t, client, server.URL)
+	if f.JA3Hash != Chrome83Hash {
+		t.Fatalf(tests.MismatchFormat, "JA3 hash", Chrome83Hash, f.JA3Hash)
+	}
+
+	// plain HTTP requests are tunneled through SOCKS proxies as well
+	res, err := client.Get(ts.URL, &gotcha.Options{Proxy: &url.URL{Scheme: "socks4a", Host: proxy.Addr, User: url.User("user")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "plain" {
+		t.Fatalf(tests.MismatchFormat, "body", "plain", string(b))
+	}
+
+	if requests := proxy.Requests(); len(requests) != 2 {
+		t.Fatalf(tests.MismatchFormat, "SOCKS requests", 2, len(requests))
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/sleeyax/gotcha/adapters/utls
+
+go 1.16
+
+require (
+	github.com/refraction-networking/utls v0.0.0-20210713165636-0b2885c8c0d4
+	github.com/sleeyax/gotcha v0.1.1
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de // indirect
+	golang.org/x/net v0.0.0-20210610132358-84b48f89b13b
+)
+
+replace github.com/sleeyax/gotcha => ../..
@@ -0,0 +1,341 @@
+package utls
+
+import (
+	"context"
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"github.com/sleeyax/gotcha"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// transport is a http.RoundTripper that sends requests over connections with the same ClientHello and proxy.
+// HTTPS requests are sent over HTTP/2 or HTTP/1.1, depending on the protocol the server negotiates with ALPN.
+type transport struct {
+	clientHello tls.ClientHelloID
+	config      *tls.Config
+	alpn        []string
+	dialer      *gotcha.ProxyDialer
+
+	h1     *http.Transport
+	h2     *http2.Transport
+	h2Pool *h2Pool
+
+	mu sync.Mutex
+	// protocol the server negotiated, per address
+	protocols map[string]string
+}
+
+// pendingConn is a connection that was dialed to learn the protocol of an address,
+// waiting to be used by h1 for the request that dialed it.
+type pendingConn struct {
+	mu   sync.Mutex
+	conn *tls.UConn
+}
+
+type pendingConnKey struct{}
+
+// take returns the connection, or nil if it was taken before.
+func (pc *pendingConn) take() *tls.UConn {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	conn := pc.conn
+	pc.conn = nil
+	return conn
+}
+
+func newTransport(clientHello tls.ClientHelloID, proxy *url.URL, a *Adapter) *transport {
+	dial := a.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	idleConnTimeout := a.IdleConnTimeout
+	if idleConnTimeout == 0 {
+		idleConnTimeout = 90 * time.Second
+	}
+
+	t := &transport{
+		clientHello: clientHello,
+		config:      &tls.Config{},
+		alpn:        append([]string{}, a.ALPN...),
+		dialer:      &gotcha.ProxyDialer{Proxy: proxy, Dial: dial},
+		protocols:   make(map[string]string),
+	}
+	if a.TLSConfig != nil {
+		t.config = a.TLSConfig.Clone()
+	}
+
+	// plain HTTP requests are sent to HTTP proxies themselves, all other requests are tunneled through the proxy by the dialer
+	httpProxy := proxy != nil && (proxy.Scheme == "http" || proxy.Scheme == "https")
+	h1Dial := dial
+	if proxy != nil && !httpProxy {
+		h1Dial = t.dialer.DialContext
+	}
+
+	t.h1 = &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			if r.URL.Scheme == "https" || !httpProxy {
+				return nil, nil
+			}
+			return proxy, nil
+		},
+		DialContext: h1Dial,
+		DialTLSContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			// ctx carries the values of the request
+			if pc, ok := ctx.Value(pendingConnKey{}).(*pendingConn); ok {
+				if conn := pc.take(); conn != nil {
+					return conn, nil
+				}
+			}
+			conn, err := t.dialTLS(ctx, addr, "http/1.1")
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		},
+		IdleConnTimeout: idleConnTimeout,
+	}
+
+	t.h2Pool = &h2Pool{t: t, conns: make(map[string][]*http2.ClientConn)}
+	t.h2 = &http2.Transport{ConnPool: t.h2Pool}
+
+	return t
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.h1.RoundTrip(req)
+	}
+
+	addr := canonicalAddr(req.URL)
+
+	t.mu.Lock()
+	protocol, ok := t.protocols[addr]
+	t.mu.Unlock()
+
+	if ok {
+		if protocol == http2.NextProtoTLS {
+			return t.h2.RoundTrip(req)
+		}
+		return t.h1.RoundTrip(req)
+	}
+
+	// the connection that's used to learn the protocol is handed to the transport of that protocol
+	conn, err := t.dial(req.Context(), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if negotiatedProtocol(conn) == http2.NextProtoTLS {
+		cc, err := t.h2Pool.add(addr, conn)
+		if err != nil {
+			return nil, err
+		}
+		return cc.RoundTrip(req)
+	}
+
+	// h1 only dials when it has no idle connection for the request, in which case the pending connection isn't needed
+	pc := &pendingConn{conn: conn}
+	res, err := t.h1.RoundTrip(req.WithContext(context.WithValue(req.Context(), pendingConnKey{}, pc)))
+	if conn := pc.take(); conn != nil {
+		conn.Close()
+	}
+	if res != nil {
+		res.Request = req
+	}
+	return res, err
+}
+
+// dialTLS dials a new connection to addr.
+// An error is returned when the server doesn't negotiate protocol,
+// the next request to addr is then sent with the transport of the negotiated protocol instead.
+func (t *transport) dialTLS(ctx context.Context, addr string, protocol string) (*tls.UConn, error) {
+	conn, err := t.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if p := negotiatedProtocol(conn); p != protocol {
+		conn.Close()
+		return nil, fmt.Errorf("utls: server at %s negotiated protocol %q instead of %q", addr, p, protocol)
+	}
+
+	return conn, nil
+}
+
+// dial connects to addr through the proxy, if any, and performs the TLS handshake with the ClientHello of the transport.
+func (t *transport) dial(ctx context.Context, addr string) (*tls.UConn, error) {
+	conn, err := t.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := t.config.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	if len(t.alpn) != 0 {
+		config.NextProtos = t.alpn
+	}
+
+	uconn := tls.UClient(conn, config, t.clientHello)
+	if err = handshake(ctx, uconn, t.alpn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.protocols[addr] = negotiatedProtocol(uconn)
+	t.mu.Unlock()
+
+	return uconn, nil
+}
+
+// CloseIdleConnections closes the connections that aren't in use.
+func (t *transport) CloseIdleConnections() {
+	t.h1.CloseIdleConnections()
+	t.h2Pool.closeIdleConnections()
+}
+
+// h2Pool is the http2.ClientConnPool of a transport.
+// Unlike the default pool, it dials with the context of the request and accepts the connections the transport dialed itself.
+type h2Pool struct {
+	t *transport
+
+	mu    sync.Mutex
+	conns map[string][]*http2.ClientConn
+}
+
+func (p *h2Pool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	p.mu.Lock()
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			p.mu.Unlock()
+			return cc, nil
+		}
+	}
+	p.mu.Unlock()
+
+	conn, err := p.t.dialTLS(req.Context(), addr, http2.NextProtoTLS)
+	if err != nil {
+		return nil, err
+	}
+	return p.add(addr, conn)
+}
+
+// add adds a connection to addr that negotiated HTTP/2 to the pool.
+func (p *h2Pool) add(addr string, conn *tls.UConn) (*http2.ClientConn, error) {
+	cc, err := p.t.h2.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.conns[addr] = append(p.conns[addr], cc)
+	p.mu.Unlock()
+
+	return cc, nil
+}
+
+// MarkDead removes cc from the pool, http2.Transport calls it when cc is closed.
+func (p *h2Pool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for i, c := range conns {
+			if c == cc {
+				p.conns[addr] = append(conns[:i:i], conns[i+1:]...)
+				if len(p.conns[addr]) == 0 {
+					delete(p.conns, addr)
+				}
+				return
+			}
+		}
+	}
+}
+
+// closeIdleConnections removes all connections from the pool and closes them once their requests are done.
+func (p *h2Pool) closeIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for _, cc := range conns {
+			go cc.Shutdown(context.Background())
+		}
+		delete(p.conns, addr)
+	}
+}
+
+// handshake performs the TLS handshake of uconn, offering the protocols in alpn if it's not empty.
+// The handshake is aborted when ctx is done.
+func handshake(ctx context.Context, uconn *tls.UConn, alpn []string) error {
+	if len(alpn) != 0 {
+		if err := uconn.BuildHandshakeState(); err != nil {
+			return err
+		}
+		for _, ext := range uconn.Extensions {
+			if e, ok := ext.(*tls.ALPNExtension); ok {
+				e.AlpnProtocols = alpn
+				uconn.HandshakeState.Hello.AlpnProtocols = alpn
+				if err := uconn.MarshalClientHello(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		uconn.SetDeadline(deadline)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			uconn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	err := uconn.Handshake()
+	close(stop)
+	<-stopped
+	uconn.SetDeadline(time.Time{})
+
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// negotiatedProtocol returns the protocol that was negotiated with ALPN, which is http/1.1 if ALPN wasn't used.
+func negotiatedProtocol(conn *tls.UConn) string {
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "" {
+		return p
+	}
+	return "http/1.1"
+}
+
+// canonicalAddr returns the host and port of u, using the default port of the scheme if none is specified.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,327 @@
+package gotcha
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var UnsupportedDigestAlgorithmError = errors.New("unsupported digest algorithm")
+
+// Authenticator adds credentials to outgoing requests.
+type Authenticator interface {
+	// Authenticate is called with normalized Options, after the BeforeRequest hooks and right before the Adapter sends them.
+	Authenticate(options *Options) error
+}
+
+// ChallengeAuthenticator is an Authenticator that can respond to an authentication challenge.
+type ChallengeAuthenticator interface {
+	Authenticator
+
+	// Challenge is called when the server responds with 401 Unauthorized.
+	// It returns true when the request should be sent again, in which case Authenticate will be called again first.
+	//
+	// Challenge is called at most once per request.
+	Challenge(options *Options, response *Response) (bool, error)
+}
+
+// BasicAuth authenticates using the Basic HTTP authentication scheme.
+//
+// When both Username and Password are empty, the userinfo of the request URL is used instead.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (b *BasicAuth) Authenticate(options *Options) error {
+	username, password := b.Username, b.Password
+
+	if username == "" && password == "" && options.FullUrl.User != nil {
+		username = options.FullUrl.User.Username()
+		password, _ = options.FullUrl.User.Password()
+	}
+
+	options.Headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+
+	return nil
+}
+
+// BearerAuth authenticates using a static bearer token.
+type BearerAuth struct {
+	Token string
+}
+
+func (b *BearerAuth) Authenticate(options *Options) error {
+	options.Headers.Set("Authorization", "Bearer "+b.Token)
+	return nil
+}
+
+// DigestAuth authenticates using the Digest HTTP authentication scheme (RFC 7616).
+//
+// The first request is sent without credentials.
+// Once the server responded with a challenge, subsequent requests are authenticated preemptively using the same nonce.
+// MD5, SHA-256 and their -sess variants are supported, with qop 'auth'.
+//
+// A DigestAuth is safe for concurrent use.
+type DigestAuth struct {
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+
+	// Only used for testing.
+	cnonce func() string
+}
+
+// digestChallenge holds the parameters of a WWW-Authenticate Digest challenge.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	userhash  bool
+}
+
+func (d *DigestAuth) Authenticate(options *Options) error {
+	d.mu.Lock()
+	if d.challenge == nil {
+		d.mu.Unlock()
+		return nil
+	}
+	d.nc++
+	challenge, nc := *d.challenge, d.nc
+	d.mu.Unlock()
+
+	cnonce := d.newCnonce()
+
+	header, err := d.authorization(&challenge, options.Method, options.FullUrl.RequestURI(), nc, cnonce)
+	if err != nil {
+		return err
+	}
+
+	options.Headers.Set("Authorization", header)
+
+	return nil
+}
+
+func (d *DigestAuth) Challenge(options *Options, response *Response) (bool, error) {
+	var challenge *digestChallenge
+
+	for _, value := range response.Header.Values("WWW-Authenticate") {
+		c := parseDigestChallenge(value)
+		if c == nil || newDigestHash(c.algorithm) == nil {
+			continue
+		}
+		// prefer SHA-256 over MD5 when the server offers both
+		if challenge == nil || strings.HasPrefix(strings.ToUpper(c.algorithm), "SHA-256") {
+			challenge = c
+		}
+	}
+
+	if challenge == nil {
+		return false, nil
+	}
+
+	d.mu.Lock()
+	d.challenge = challenge
+	d.nc = 0
+	d.mu.Unlock()
+
+	return true, nil
+}
+
+func (d *DigestAuth) newCnonce() string {
+	if d.cnonce != nil {
+		return d.cnonce()
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// authorization computes the Authorization header value.
+func (d *DigestAuth) authorization(c *digestChallenge, method string, uri string, nc uint32, cnonce string) (string, error) {
+	h := newDigestHash(c.algorithm)
+	if h == nil {
+		return "", UnsupportedDigestAlgorithmError
+	}
+
+	digest := func(s string) string {
+		h.Reset()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	ha1 := digest(d.Username + ":" + c.realm + ":" + d.Password)
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		ha1 = digest(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := digest(method + ":" + uri)
+
+	username := d.Username
+	if c.userhash {
+		username = digest(d.Username + ":" + c.realm)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `Digest username="%s", realm="%s", nonce="%s", uri="%s"`, username, c.realm, c.nonce, uri)
+
+	if c.algorithm != "" {
+		fmt.Fprintf(&sb, ", algorithm=%s", c.algorithm)
+	}
+
+	if c.qop == "" {
+		// RFC 2069 compatibility
+		fmt.Fprintf(&sb, `, response="%s"`, digest(ha1+":"+c.nonce+":"+ha2))
+	} else {
+		ncValue := fmt.Sprintf("%08x", nc)
+		response := digest(ha1 + ":" + c.nonce + ":" + ncValue + ":" + cnonce + ":" + c.qop + ":" + ha2)
+		fmt.Fprintf(&sb, `, response="%s", qop=%s, nc=%s, cnonce="%s"`, response, c.qop, ncValue, cnonce)
+	}
+
+	if c.opaque != "" {
+		fmt.Fprintf(&sb, `, opaque="%s"`, c.opaque)
+	}
+	if c.userhash {
+		sb.WriteString(", userhash=true")
+	}
+
+	return sb.String(), nil
+}
+
+// newDigestHash returns the hash function for given algorithm or nil when it's not supported.
+func newDigestHash(algorithm string) hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return md5.New()
+	case "SHA-256", "SHA-256-SESS":
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// parseDigestChallenge parses a WWW-Authenticate header value.
+// It returns nil when value isn't a Digest challenge or doesn't support qop 'auth'.
+func parseDigestChallenge(value string) *digestChallenge {
+	parts := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "digest") {
+		return nil
+	}
+
+	c := &digestChallenge{}
+	for key, val := range parseAuthParams(parts[1]) {
+		switch key {
+		case "realm":
+			c.realm = val
+		case "nonce":
+			c.nonce = val
+		case "opaque":
+			c.opaque = val
+		case "algorithm":
+			c.algorithm = val
+		case "userhash":
+			c.userhash = strings.EqualFold(val, "true")
+		case "qop":
+			for _, qop := range strings.Split(val, ",") {
+				if strings.TrimSpace(qop) == "auth" {
+					c.qop = "auth"
+				}
+			}
+			if c.qop == "" {
+				return nil
+			}
+		}
+	}
+
+	return c
+}
+
+// parseAuthParams parses a comma separated list of auth-params (RFC 7235), with keys in lowercase.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			value = sb.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end == -1 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[key] = value
+	}
+}
+
+// authenticate applies Options.Auth to the request.
+func (c *Client) authenticate(o *Options) error {
+	if o.authDisabled {
+		return nil
+	}
+
+	auth := o.Auth
+	if auth == nil && o.FullUrl.User != nil {
+		auth = &BasicAuth{}
+	}
+	if auth == nil {
+		return nil
+	}
+	return auth.Authenticate(o)
+}
+
+// challenge lets Options.Auth respond to a 401 Unauthorized response.
+// It returns true when the request should be sent again.
+func (c *Client) challenge(o *Options, res *Response) (bool, error) {
+	if o.challenged || o.authDisabled || res.StatusCode != http.StatusUnauthorized {
+		return false, nil
+	}
+
+	ca, ok := o.Auth.(ChallengeAuthenticator)
+	if !ok {
+		return false, nil
+	}
+
+	o.challenged = true
+
+	return ca.Challenge(o, res)
+}
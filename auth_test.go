@@ -0,0 +1,171 @@
+package gotcha
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "foo" || password != "bar" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	res, err := Get(ts.URL, &Options{Auth: &BasicAuth{Username: "foo", Password: "bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf(tests.MismatchFormat, "status code", http.StatusOK, res.StatusCode)
+	}
+
+	// credentials from the URL userinfo
+	res, err = Get(strings.Replace(ts.URL, "http://", "http://foo:bar@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf(tests.MismatchFormat, "status code", http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	client := createClient(t, Hooks{})
+
+	res, err := client.Get("https://example.com", &Options{Auth: &BearerAuth{Token: "token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := res.Request.Header.Get("Authorization"); a != "Bearer token" {
+		t.Fatalf(tests.MismatchFormat, "authorization", "Bearer token", a)
+	}
+}
+
+func TestDigestAuth_Authorization(t *testing.T) {
+	// test vectors from RFC 7616, section 3.9.1
+	d := &DigestAuth{Username: "Mufasa", Password: "Circle of Life"}
+
+	for algorithm, response := range map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	} {
+		header, err := d.authorization(&digestChallenge{
+			realm:     "http-auth@example.org",
+			nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			opaque:    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+			algorithm: algorithm,
+			qop:       "auth",
+		}, http.MethodGet, "/dir/index.html", 1, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if params := parseAuthParams(strings.TrimPrefix(header, "Digest ")); params["response"] != response {
+			t.Errorf(tests.MismatchFormat, algorithm+" response", response, params["response"])
+		}
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	const realm = "gotcha"
+	const nonce = "abc123"
+	var challenges int
+	var lastNc string
+
+	hash := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			challenges++
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=MD5, nonce="%s"`, realm, nonce))
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=SHA-256, nonce="%s", opaque="xyz"`, realm, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		p := parseAuthParams(strings.TrimPrefix(auth, "Digest "))
+		ha1 := hash("foo:" + realm + ":bar")
+		ha2 := hash(r.Method + ":" + r.URL.RequestURI())
+		expected := hash(ha1 + ":" + nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+		if p["response"] != expected || p["opaque"] != "xyz" || p["algorithm"] != "SHA-256" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		lastNc = p["nc"]
+
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(&Options{
+		Auth: &DigestAuth{Username: "foo", Password: "bar"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the body should be sent again after the challenge
+	res, err := client.Put(ts.URL+"/path?a=b", &Options{Body: io.NopCloser(strings.NewReader("hello world"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf(tests.MismatchFormat, "status code", http.StatusOK, res.StatusCode)
+	}
+	if body, _ := res.Text(); body != "hello world" {
+		t.Fatalf(tests.MismatchFormat, "body", "hello world", body)
+	}
+
+	// subsequent requests are authenticated preemptively
+	res, err = client.Get(ts.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf(tests.MismatchFormat, "status code", http.StatusOK, res.StatusCode)
+	}
+	if challenges != 1 {
+		t.Fatalf(tests.MismatchFormat, "challenges", 1, challenges)
+	}
+	if lastNc != "00000002" {
+		t.Fatalf(tests.MismatchFormat, "nonce count", "00000002", lastNc)
+	}
+}
+
+func TestAuth_CrossHostRedirect(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a := r.Header.Get("Authorization"); a != "" {
+			t.Errorf(tests.MismatchFormat, "authorization", "", a)
+		}
+	}))
+	defer other.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer ts.Close()
+
+	res, err := Get(ts.URL, &Options{Auth: &BearerAuth{Token: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Close()
+}
@@ -13,6 +13,7 @@ import (
 
 type Client struct {
 	// Instance-specific configuration options.
+	// Every request works on a copy of them, so a Client can be shared between goroutines.
 	Options *Options
 }
 
@@ -36,7 +37,10 @@ func (c *Client) DoRequest(method string, url string, options ...*Options) (*Res
 		hook(c.Options)
 	}
 
-	o := &(*c.Options)
+	// copy the Options of the client, so concurrent requests without Options don't share their state
+	opts := *c.Options
+	opts.Headers = opts.Headers.Clone()
+	o := &opts
 
 	for _, option := range options {
 		var err error
@@ -77,14 +81,49 @@ func (c *Client) DoRequest(method string, url string, options ...*Options) (*Res
 		timeout = o.RetryOptions.CalculateTimeout(o.retries, o.RetryOptions, timeout, err)
 		time.Sleep(timeout)
 		o.retries++
+		o.rewindBody()
 		return c.DoRequest(method, url, o)
 	}
 
+	if o.ProxyPool != nil {
+		if err := o.ProxyPool.pick(o); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, hook := range o.Hooks.BeforeRequest {
 		hook(o)
 	}
 
-	res, err := o.Adapter.DoRequest(o)
+	// Buffer the body so that it can be sent again in response to an authentication challenge.
+	if _, ok := o.Auth.(ChallengeAuthenticator); ok && !o.challenged && !o.authDisabled {
+		if _, err := o.ReplayableBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.authenticate(o); err != nil {
+		return nil, err
+	}
+
+	res, err := c.send(o)
+
+	var proxyFailed bool
+	if o.ProxyPool != nil {
+		proxyFailed = o.ProxyPool.report(o, res, err)
+	}
+
+	if err == nil {
+		challenged, e := c.challenge(o, res)
+		if e != nil {
+			return nil, e
+		}
+		if challenged {
+			res.Body.Close()
+			o.rewindBody()
+			return c.DoRequest(o.Method, url, o)
+		}
+	}
 
 	if err == nil {
 		for _, hook := range o.Hooks.AfterResponse {
@@ -105,18 +144,20 @@ func (c *Client) DoRequest(method string, url string, options ...*Options) (*Res
 		}
 	}
 
-	if err != nil && (!o.Retry || (o.retries >= o.RetryOptions.Limit)) {
-		return nil, err
-	}
-
-	if o.Retry {
-		if o.retries >= o.RetryOptions.Limit {
+	// requests that failed because of their proxy are retried as well, the ProxyPool picks a different proxy for the next attempt
+	shouldRetry := o.Retry && ((err != nil && utils.StringArrayContains(o.RetryOptions.ErrorCodes, err.Error())) ||
+		((proxyFailed || (err == nil && utils.IntArrayContains(o.RetryOptions.StatusCodes, res.StatusCode))) && utils.StringArrayContains(o.RetryOptions.Methods, method)))
+	if shouldRetry {
+		if o.retries < o.RetryOptions.Limit {
+			return retry(res, err)
+		}
+		if err == nil {
 			return res, MaxRetriesExceededError
 		}
+	}
 
-		if (err != nil && utils.StringArrayContains(o.RetryOptions.ErrorCodes, err.Error())) || (utils.IntArrayContains(o.RetryOptions.StatusCodes, res.StatusCode) && utils.StringArrayContains(o.RetryOptions.Methods, method)) {
-			return retry(res, err)
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	if o.FollowRedirect && res.Header.Get("location") != "" && utils.IntArrayContains(RedirectStatusCodes, res.StatusCode) {
@@ -140,14 +181,17 @@ func (c *Client) DoRequest(method string, url string, options ...*Options) (*Res
 			return nil, err
 		}
 
-		// remove sensitive headers when redirecting to a different domain
+		// remove sensitive headers and credentials when redirecting to a different domain
 		if redirectUrl.Hostname() != currentUrl.Hostname() || redirectUrl.Port() != currentUrl.Port() {
 			o.Headers.Del("host")
 			o.Headers.Del("cookie")
 			o.Headers.Del("authorization")
+			o.authDisabled = true
 		}
+		o.challenged = false
 
 		c.updateRequestCookies(o, res)
+		o.rewindBody()
 		o.PrefixURL = ""
 		o.redirectUrls = append(o.redirectUrls, redirectUrl)
 
@@ -161,13 +205,29 @@ func (c *Client) DoRequest(method string, url string, options ...*Options) (*Res
 	return res, nil
 }
 
+// send passes the normalized request Options to the Adapter.
+func (c *Client) send(o *Options) (*Response, error) {
+	if shouldCoalesce(o) {
+		return c.coalesce(o)
+	}
+	return c.roundTrip(o)
+}
+
+// roundTrip does the actual request through the Adapter, hedging it when configured to do so.
+func (c *Client) roundTrip(o *Options) (*Response, error) {
+	if shouldHedge(o) {
+		return c.hedge(o)
+	}
+	return o.Adapter.DoRequest(o)
+}
+
 // Do is an alias of DoRequest.
 func (c *Client) Do(method string, url string, options ...*Options) (*Response, error) {
 	return c.DoRequest(method, url, options...)
 }
 
 func (c *Client) getTimeout(o *Options, response *Response) (time.Duration, error) {
-	if o.RetryOptions.RetryAfter == false {
+	if o.RetryOptions.RetryAfter == false || response == nil {
 		return 0, nil
 	}
 
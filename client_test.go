@@ -2,6 +2,7 @@ package gotcha
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Sleeyax/urlValues"
 	"github.com/sleeyax/gotcha/internal/tests"
@@ -81,6 +82,90 @@ func TestClient_DoRequest_RetryAfter(t *testing.T) {
 	}
 }
 
+// errorAdapter fails every request with err.
+type errorAdapter struct {
+	err   error
+	calls int
+}
+
+func (ea *errorAdapter) DoRequest(options *Options) (*Response, error) {
+	ea.calls++
+	return nil, ea.err
+}
+
+func TestClient_DoRequest_RetrySucceeds(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(&Options{
+		Retry: true,
+		RetryOptions: &RetryOptions{
+			Limit:       1,
+			Methods:     []string{http.MethodGet},
+			StatusCodes: []int{500},
+			CalculateTimeout: func(retries int, retryOptions *RetryOptions, computedTimeout time.Duration, error error) time.Duration {
+				return 0
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last attempt succeeded, so the limit wasn't exceeded
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || requests != 2 {
+		t.Fatalf(tests.MismatchFormat, "status code after 2 requests", 200, fmt.Sprintf("%d after %d requests", res.StatusCode, requests))
+	}
+}
+
+func TestClient_DoRequest_RetryError(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		errorCodes []string
+		calls      int
+	}{
+		{"retryable error", []string{"ECONNRESET"}, 3},
+		{"non-retryable error", []string{"ETIMEDOUT"}, 1},
+	} {
+		adapter := &errorAdapter{err: errors.New("ECONNRESET")}
+		client, err := NewClient(&Options{
+			Adapter: adapter,
+			Retry:   true,
+			RetryOptions: &RetryOptions{
+				Limit:      2,
+				Methods:    []string{http.MethodGet},
+				ErrorCodes: test.errorCodes,
+				RetryAfter: true,
+				CalculateTimeout: func(retries int, retryOptions *RetryOptions, computedTimeout time.Duration, error error) time.Duration {
+					return 0
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the error is returned instead of a response, also when the limit is exceeded
+		res, err := client.Get("https://example.com")
+		if err != adapter.err || res != nil {
+			t.Fatalf(tests.MismatchFormat, test.name, adapter.err, err)
+		}
+		if adapter.calls != test.calls {
+			t.Fatalf(tests.MismatchFormat, test.name+" attempts", test.calls, adapter.calls)
+		}
+	}
+}
+
 func TestClient_DoRequest_Body(t *testing.T) {
 	url := "https://example.com"
 	var testType string
@@ -121,6 +206,8 @@ func TestClient_DoRequest_Body(t *testing.T) {
 	wantedBody = `{"a":"b","c":["d","e","f"],"g":{"h":"i"}}`
 	var result JSON
 	json.Unmarshal([]byte(wantedBody), &result)
+	// requests don't change the Options of the client, so the form has to be removed
+	client.Options.Form = nil
 	client.Options.Json = result
 	client.Post(url)
 }
@@ -301,12 +388,12 @@ func ExampleNewClient() {
 		},
 	})
 	if err != nil {
-		fmt.Sprintln("error:", err)
+		fmt.Println("error:", err)
 	}
 
 	res, err := client.Do(http.MethodGet, "https://httpbin.org/get")
 	if err != nil {
-		fmt.Sprintln("error:", err)
+		fmt.Println("error:", err)
 	}
 
 	j, _ := res.Json()
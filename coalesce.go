@@ -0,0 +1,117 @@
+package gotcha
+
+import (
+	bytesPkg "bytes"
+	"github.com/sleeyax/gotcha/internal/utils"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type CoalesceOptions struct {
+	// Request headers that are part of the deduplication key, in addition to the method and FullUrl.
+	// Requests that differ in any other header are still considered identical.
+	Headers []string
+
+	// Only coalesce when the request HTTP method equals one of these Methods.
+	// Defaults to GET and HEAD when empty.
+	//
+	// Requests with a Body are never coalesced.
+	Methods []string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is an in-flight or completed request that is shared by all identical callers.
+type coalescedCall struct {
+	wg sync.WaitGroup
+
+	res  *http.Response
+	body []byte
+	err  error
+}
+
+// key computes the deduplication key of the request.
+func (co *CoalesceOptions) key(o *Options) string {
+	var sb strings.Builder
+	sb.WriteString(o.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(o.FullUrl.String())
+	for _, header := range co.Headers {
+		sb.WriteByte('\n')
+		sb.WriteString(strings.ToLower(header))
+		sb.WriteByte(':')
+		sb.WriteString(strings.Join(o.Headers.Values(header), ","))
+	}
+	return sb.String()
+}
+
+// shouldCoalesce reports whether the request described by o can be coalesced.
+func shouldCoalesce(o *Options) bool {
+	co := o.Coalesce
+	if co == nil || o.Body != nil {
+		return false
+	}
+
+	methods := co.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	return utils.StringArrayContains(methods, o.Method)
+}
+
+// coalesce sends the request unless an identical request is already in-flight,
+// in which case it waits for that request to complete instead.
+// Each caller receives an independent copy of the Response, with the Body buffered in memory.
+func (c *Client) coalesce(o *Options) (*Response, error) {
+	co := o.Coalesce
+	key := co.key(o)
+
+	co.mu.Lock()
+	if co.calls == nil {
+		co.calls = make(map[string]*coalescedCall)
+	}
+	if call, ok := co.calls[key]; ok {
+		co.mu.Unlock()
+		call.wg.Wait()
+		return call.response(o)
+	}
+	call := &coalescedCall{}
+	call.wg.Add(1)
+	co.calls[key] = call
+	co.mu.Unlock()
+
+	res, err := c.roundTrip(o)
+	if err == nil {
+		call.res = res.Response
+		if res.Body != nil {
+			call.body, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+	}
+	call.err = err
+
+	co.mu.Lock()
+	delete(co.calls, key)
+	co.mu.Unlock()
+	call.wg.Done()
+
+	return call.response(o)
+}
+
+// response returns a copy of the shared response.
+func (call *coalescedCall) response(o *Options) (*Response, error) {
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	res := *call.res
+	res.Header = call.res.Header.Clone()
+	res.Trailer = call.res.Trailer.Clone()
+	res.Body = io.NopCloser(bytesPkg.NewReader(call.body))
+
+	return &Response{&res, o.UnmarshalJson}, nil
+}
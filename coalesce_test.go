@@ -0,0 +1,95 @@
+package gotcha
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http"
+	"net/http/httptest"
+	urlPkg "net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoRequest_Coalesce(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Header().Set("x-foo", "bar")
+		w.Write([]byte("coalesced"))
+	}))
+	defer ts.Close()
+
+	coalesce := &CoalesceOptions{Headers: []string{"authorization"}}
+
+	client, err := NewClient(&Options{
+		PrefixURL: ts.URL,
+		Coalesce:  coalesce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 10
+	var wg, started sync.WaitGroup
+	wg.Add(count)
+	started.Add(count)
+	bodies := make(chan string, count)
+
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			started.Done()
+			res, err := client.Get("coalesce", &Options{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if h := res.Header.Get("x-foo"); h != "bar" {
+				t.Errorf(tests.MismatchFormat, "header", "bar", h)
+			}
+			body, err := res.Text()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			bodies <- body
+		}()
+	}
+
+	// give the callers time to join the in-flight request before letting it complete
+	started.Wait()
+	deadline := time.Now().Add(time.Second * 5)
+	for atomic.LoadInt32(&requests) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	close(bodies)
+
+	for body := range bodies {
+		if body != "coalesced" {
+			t.Errorf(tests.MismatchFormat, "body", "coalesced", body)
+		}
+	}
+
+	// callers that were scheduled late may send a request of their own
+	if r := atomic.LoadInt32(&requests); r <= 0 || r >= int32(count) {
+		t.Fatalf("unexpected request count %d of %d callers", r, count)
+	}
+
+	// requests that differ in a key header aren't coalesced
+	u, _ := urlPkg.Parse(ts.URL)
+	if k1, k2 := coalesce.key(&Options{Method: http.MethodGet, FullUrl: u, Headers: http.Header{"Authorization": {"a"}}}),
+		coalesce.key(&Options{Method: http.MethodGet, FullUrl: u, Headers: http.Header{"Authorization": {"b"}}}); k1 == k2 {
+		t.Fatalf("expected keys of requests with different authorization headers to differ")
+	}
+}
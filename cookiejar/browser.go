@@ -0,0 +1,333 @@
+package cookiejar
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/sleeyax/gotcha/internal/sqlite"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Offset between the Windows epoch (1601-01-01) that Chrome uses and the Unix epoch, in seconds.
+const chromeEpochOffset = 11644473600
+
+// BrowserOptions are the options for loading cookies from a browser profile.
+type BrowserOptions struct {
+	// Only load cookies that apply to these domains or their subdomains.
+	// All cookies are loaded when empty.
+	Domains []string
+
+	// Password that Chrome uses to encrypt cookies with the v11 prefix, as stored in the system keyring ("Chrome Safe Storage").
+	// Cookies with the v10 prefix are encrypted with a hardcoded password and don't need it.
+	KeyringPassword string
+}
+
+// DecryptError is returned by LoadChrome when some cookies couldn't be decrypted.
+// The other cookies are loaded into the jar regardless.
+type DecryptError struct {
+	// Number of cookies that were skipped.
+	Skipped int
+
+	// Error of the first cookie that was skipped.
+	Err error
+}
+
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("cookiejar: skipped %d cookie(s) that couldn't be decrypted: %v", e.Skipped, e.Err)
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// LoadChrome loads the cookies of a Chrome (or Chromium) profile into jar.
+// profile is either the path of the profile directory, e.g. ~/.config/google-chrome/Default, or of its Cookies database.
+//
+// Encrypted cookies are decrypted the way Chrome does on Linux.
+// Cookies that can't be decrypted, e.g. v11 cookies without the KeyringPassword, are skipped and reported in a *DecryptError.
+// Changes that Chrome hasn't written to the database yet are missing, so close the browser first to get all cookies.
+func LoadChrome(jar http.CookieJar, profile string, options *BrowserOptions) error {
+	if options == nil {
+		options = &BrowserOptions{}
+	}
+
+	db, err := openBrowserDB(profile, filepath.Join("Network", "Cookies"), "Cookies")
+	if err != nil {
+		return err
+	}
+
+	// newer versions prefix the encrypted value with a hash of the domain
+	var version int
+	meta, err := db.Rows("meta")
+	if err != nil {
+		return err
+	}
+	for _, row := range meta {
+		if row["key"] == "version" {
+			version, _ = strconv.Atoi(fmt.Sprint(row["value"]))
+		}
+	}
+
+	rows, err := db.Rows("cookies")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var decryptErr *DecryptError
+
+	for _, row := range rows {
+		domain := text(row["host_key"])
+		if !matchDomains(domain, options.Domains) {
+			continue
+		}
+
+		value := text(row["value"])
+		if encrypted, _ := row["encrypted_value"].([]byte); value == "" && len(encrypted) != 0 {
+			if value, err = decryptChromeValue(encrypted, options.KeyringPassword, version >= 24); err != nil {
+				if decryptErr == nil {
+					decryptErr = &DecryptError{Err: fmt.Errorf("cookie %s of %s: %w", text(row["name"]), domain, err)}
+				}
+				decryptErr.Skipped++
+				continue
+			}
+		}
+
+		cookie := &http.Cookie{
+			Name:     text(row["name"]),
+			Value:    value,
+			Path:     text(row["path"]),
+			Secure:   integer(row["is_secure"], row["secure"]) == 1,
+			HttpOnly: integer(row["is_httponly"], row["httponly"]) == 1,
+		}
+
+		switch integer(row["samesite"]) {
+		case 0:
+			cookie.SameSite = http.SameSiteNoneMode
+		case 1:
+			cookie.SameSite = http.SameSiteLaxMode
+		case 2:
+			cookie.SameSite = http.SameSiteStrictMode
+		}
+
+		if integer(row["is_persistent"], row["persistent"]) == 1 {
+			micros := integer(row["expires_utc"])
+			cookie.Expires = time.Unix(micros/1e6-chromeEpochOffset, micros%1e6*1e3)
+			if !cookie.Expires.After(now) {
+				continue
+			}
+		}
+
+		setCookie(jar, domain, !strings.HasPrefix(domain, "."), cookie)
+	}
+
+	if decryptErr != nil {
+		return decryptErr
+	}
+
+	return nil
+}
+
+// LoadFirefox loads the cookies of a Firefox profile into jar.
+// profile is either the path of the profile directory, e.g. ~/.mozilla/firefox/abcd1234.default-release, or of its cookies.sqlite database.
+//
+// Cookies of containers and partitioned cookies are skipped.
+func LoadFirefox(jar http.CookieJar, profile string, options *BrowserOptions) error {
+	if options == nil {
+		options = &BrowserOptions{}
+	}
+
+	db, err := openBrowserDB(profile, "cookies.sqlite")
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Rows("moz_cookies")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, row := range rows {
+		domain := text(row["host"])
+		if text(row["originAttributes"]) != "" || !matchDomains(domain, options.Domains) {
+			continue
+		}
+
+		cookie := &http.Cookie{
+			Name:     text(row["name"]),
+			Value:    text(row["value"]),
+			Path:     text(row["path"]),
+			Secure:   integer(row["isSecure"]) == 1,
+			HttpOnly: integer(row["isHttpOnly"]) == 1,
+		}
+
+		switch integer(row["sameSite"]) {
+		case 1:
+			cookie.SameSite = http.SameSiteLaxMode
+		case 2:
+			cookie.SameSite = http.SameSiteStrictMode
+		}
+
+		// recent versions store the expiry in milliseconds instead of seconds
+		expiry := integer(row["expiry"])
+		if expiry > 1e11 {
+			cookie.Expires = time.Unix(expiry/1e3, expiry%1e3*1e6)
+		} else {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+		if !cookie.Expires.After(now) {
+			continue
+		}
+
+		setCookie(jar, domain, !strings.HasPrefix(domain, "."), cookie)
+	}
+
+	return nil
+}
+
+// openBrowserDB opens the database at path, or the first of given names that exists in the directory at path.
+func openBrowserDB(path string, names ...string) (*sqlite.DB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return sqlite.Open(path)
+	}
+
+	for _, name := range names {
+		db, err := sqlite.Open(filepath.Join(path, name))
+		if !os.IsNotExist(err) {
+			return db, err
+		}
+	}
+
+	return nil, fmt.Errorf("cookiejar: no cookie database found in %s", path)
+}
+
+// decryptChromeValue decrypts the value of a cookie that was encrypted by Chrome on Linux.
+// hostHash indicates whether the plaintext starts with the SHA-256 hash of the domain, which is the case since database version 24.
+func decryptChromeValue(encrypted []byte, keyringPassword string, hostHash bool) (string, error) {
+	if len(encrypted) < 3 {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	var password string
+	switch prefix := string(encrypted[:3]); prefix {
+	case "v10":
+		password = "peanuts"
+	case "v11":
+		if keyringPassword == "" {
+			return "", errors.New("v11 encryption requires the keyring password")
+		}
+		password = keyringPassword
+	default:
+		return "", fmt.Errorf("unsupported encryption version %q", prefix)
+	}
+	ciphertext := encrypted[3:]
+
+	key := pbkdf2SHA1([]byte(password), []byte("saltysalt"), 1, 16)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return "", errors.New("invalid ciphertext length")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, bytes.Repeat([]byte{' '}, aes.BlockSize)).CryptBlocks(plaintext, ciphertext)
+
+	// remove the PKCS #7 padding
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plaintext) {
+		return "", errors.New("invalid padding, the password is probably wrong")
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+
+	if hostHash {
+		if len(plaintext) < 32 {
+			return "", errors.New("decrypted value is too short")
+		}
+		plaintext = plaintext[32:]
+	}
+
+	return string(plaintext), nil
+}
+
+// pbkdf2SHA1 derives a key from password using PBKDF2 (RFC 8018) with HMAC-SHA1.
+func pbkdf2SHA1(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	var key []byte
+	for block := uint32(1); len(key) < keyLength; block++ {
+		h := hmac.New(sha1.New, password)
+		h.Write(salt)
+		h.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := h.Sum(nil)
+
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			h = hmac.New(sha1.New, password)
+			h.Write(u)
+			u = h.Sum(nil)
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
+
+// matchDomains reports whether a cookie of given domain applies to any of domains or their subdomains.
+// A leading dot in domain marks a cookie for all subdomains.
+func matchDomains(domain string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	host := strings.ToLower(strings.TrimPrefix(domain, "."))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || hasDotSuffix(host, d) || (strings.HasPrefix(domain, ".") && hasDotSuffix(d, host)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// text returns a database value as a string.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// integer returns the first database value that is an integer, or 0.
+// Multiple values can be given to support columns that were renamed.
+func integer(values ...interface{}) int64 {
+	for _, v := range values {
+		if i, ok := v.(int64); ok {
+			return i
+		}
+	}
+	return 0
+}
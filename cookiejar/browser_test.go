@@ -0,0 +1,93 @@
+package cookiejar
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"testing"
+	"time"
+)
+
+func TestLoadChrome(t *testing.T) {
+	// the v11 cookie of locked.net can't be decrypted without the keyring password
+	jar, _ := New(nil)
+	err := LoadChrome(jar, "testdata/chrome", nil)
+	if decryptErr, ok := err.(*DecryptError); !ok || decryptErr.Skipped != 1 {
+		t.Fatalf(tests.MismatchFormat, "error", "1 skipped cookie", err)
+	}
+
+	now := time.Now()
+	for u, expected := range map[string]string{
+		"https://www.example.com/": "sid=abc123 pref=dark",
+		"http://www.example.com/":  "pref=dark",
+		"https://www.other.org/":   "tracker=42",
+		"https://www.locked.net/":  "",
+	} {
+		if s := cookieString(jar, u, now); s != expected {
+			t.Errorf(tests.MismatchFormat, u, expected, s)
+		}
+	}
+
+	sid := jar.entries["example.com"]["example.com;/;sid"]
+	if sid.HostOnly || !sid.HttpOnly || !sid.Secure || sid.Persistent || sid.SameSite != "Lax" {
+		t.Fatalf("unexpected session cookie %+v", sid)
+	}
+	pref := jar.entries["example.com"]["www.example.com;/;pref"]
+	if !pref.HostOnly || !pref.Persistent || pref.Expires.Year() != 2100 {
+		t.Fatalf("unexpected persistent cookie %+v", pref)
+	}
+
+	// filter by domain
+	jar, _ = New(nil)
+	if err := LoadChrome(jar, "testdata/chrome/Cookies", &BrowserOptions{Domains: []string{"www.other.org"}}); err != nil {
+		t.Fatal(err)
+	}
+	if all := jar.All(); len(all) != 1 || all[0].Name != "tracker" {
+		t.Fatalf("unexpected cookies %+v", all)
+	}
+
+	// v11 cookies can't be decrypted without the keyring password
+	if _, err := decryptChromeValue([]byte("v11abcdefghijklmnop"), "", false); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLoadFirefox(t *testing.T) {
+	jar, _ := New(nil)
+	if err := LoadFirefox(jar, "testdata/firefox", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for u, expected := range map[string]string{
+		"https://www.example.com/": "fsid=xyz fpref=light",
+		"http://sub.example.com/":  "",
+		"http://other.org/":        "ft=1",
+	} {
+		if s := cookieString(jar, u, now); s != expected {
+			t.Errorf(tests.MismatchFormat, u, expected, s)
+		}
+	}
+
+	fsid := jar.entries["example.com"]["example.com;/;fsid"]
+	if fsid.HostOnly || !fsid.HttpOnly || fsid.SameSite != "Strict" || fsid.Expires.Year() != 2100 {
+		t.Fatalf("unexpected cookie %+v", fsid)
+	}
+	// the expiry of this cookie is stored in milliseconds
+	if fpref := jar.entries["example.com"]["www.example.com;/;fpref"]; fpref.Expires.Year() != 2100 {
+		t.Fatalf("unexpected cookie %+v", fpref)
+	}
+
+	jar, _ = New(nil)
+	if err := LoadFirefox(jar, "testdata/firefox/cookies.sqlite", &BrowserOptions{Domains: []string{"example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(jar.All()); n != 2 {
+		t.Fatalf(tests.MismatchFormat, "number of cookies", 2, n)
+	}
+}
+
+func TestPBKDF2SHA1(t *testing.T) {
+	// test vector from RFC 6070
+	if key := pbkdf2SHA1([]byte("password"), []byte("salt"), 2, 20); string(key) != "\xea\x6c\x01\x4d\xc7\x2d\x6f\x8c\xcd\x1e\xd9\x2a\xce\x1d\x41\xf0\xd8\xde\x89\x57" {
+		t.Fatalf(tests.MismatchFormat, "key", "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957", key)
+	}
+}
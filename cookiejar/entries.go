@@ -0,0 +1,103 @@
+package cookiejar
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// All returns all cookies in the Jar that haven't expired, sorted by domain, path and name.
+func (j *Jar) All() []Entry {
+	now := time.Now()
+
+	j.mu.Lock()
+	var entries []Entry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if !e.expired(now) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	j.mu.Unlock()
+
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Domain != entries[b].Domain {
+			return entries[a].Domain < entries[b].Domain
+		}
+		if entries[a].Path != entries[b].Path {
+			return entries[a].Path < entries[b].Path
+		}
+		return entries[a].Name < entries[b].Name
+	})
+
+	return entries
+}
+
+// Delete removes the cookie with given domain, path and name.
+// It reports whether the cookie was found.
+func (j *Jar) Delete(domain string, path string, name string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	e := Entry{Domain: domain, Path: path, Name: name}
+	key := jarKey(domain, j.psList)
+
+	j.mu.Lock()
+
+	old, ok := j.entries[key][e.id()]
+	if !ok {
+		j.mu.Unlock()
+		return false
+	}
+
+	delete(j.entries[key], e.id())
+	if len(j.entries[key]) == 0 {
+		delete(j.entries, key)
+	}
+
+	changes := []change{{old, true}}
+	j.mu.Unlock()
+	j.commit(changes)
+	j.notify(changes)
+
+	return true
+}
+
+// Clear removes all cookies.
+func (j *Jar) Clear() {
+	j.mu.Lock()
+
+	var changes []change
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			changes = append(changes, change{e, true})
+		}
+	}
+	j.entries = make(map[string]map[string]Entry)
+
+	j.mu.Unlock()
+	j.commit(changes)
+	j.notify(changes)
+}
+
+// Clone returns a copy of the Jar with the same public suffix list.
+// The copy is only kept in memory and has no OnChange hook, so that it can be modified independently.
+func (j *Jar) Clone() *Jar {
+	clone := &Jar{
+		psList:  j.psList,
+		entries: make(map[string]map[string]Entry),
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for key, submap := range j.entries {
+		m := make(map[string]Entry, len(submap))
+		for id, e := range submap {
+			m[id] = e
+		}
+		clone.entries[key] = m
+	}
+	clone.nextSeqNum = j.nextSeqNum
+
+	return clone
+}
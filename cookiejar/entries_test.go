@@ -0,0 +1,85 @@
+package cookiejar
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJar_Entries(t *testing.T) {
+	var added, removed []string
+	jar, _ := New(&Options{
+		PublicSuffixList: testPSL{},
+		OnChange: func(entry Entry, r bool) {
+			if r {
+				removed = append(removed, entry.Name)
+			} else {
+				added = append(added, entry.Name)
+			}
+		},
+	})
+
+	u := mustParseURL("https://www.example.co.uk/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "b", Value: "1"},
+		{Name: "a", Value: "2", Domain: "example.co.uk"},
+		// rejected by the public suffix list
+		{Name: "c", Value: "3", Domain: "co.uk"},
+	})
+
+	all := jar.All()
+	if len(all) != 2 || all[0].Name != "a" || all[0].Domain != "example.co.uk" || all[0].HostOnly || all[1].Name != "b" || !all[1].HostOnly {
+		t.Fatalf("unexpected entries %+v", all)
+	}
+
+	clone := jar.Clone()
+
+	if !jar.Delete(".example.co.uk", "/", "a") {
+		t.Fatal("expected cookie to be deleted")
+	}
+	if jar.Delete("example.co.uk", "/", "a") {
+		t.Fatal("expected cookie to be deleted only once")
+	}
+	if s := cookieString(jar, u.String(), time.Now()); s != "b=1" {
+		t.Fatalf(tests.MismatchFormat, "cookies", "b=1", s)
+	}
+
+	jar.Clear()
+	if n := len(jar.All()); n != 0 {
+		t.Fatalf(tests.MismatchFormat, "number of cookies", 0, n)
+	}
+
+	// the clone is independent
+	if s := cookieString(clone, u.String(), time.Now()); s != "b=1 a=2" {
+		t.Fatalf(tests.MismatchFormat, "cloned cookies", "b=1 a=2", s)
+	}
+
+	if s := len(added); s != 2 {
+		t.Fatalf(tests.MismatchFormat, "added cookies", 2, s)
+	}
+	if s := len(removed); s != 2 {
+		t.Fatalf(tests.MismatchFormat, "removed cookies", 2, s)
+	}
+}
+
+func TestJar_LoadPublicSuffix(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.json")
+	data := `{"version": 1, "cookies": [
+		{"name": "a", "value": "1", "domain": "co.uk", "path": "/"},
+		{"name": "b", "value": "2", "domain": "example.co.uk", "path": "/"}
+	]}`
+	if err := os.WriteFile(filename, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := New(&Options{Filename: filename, PublicSuffixList: testPSL{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all := jar.All(); len(all) != 1 || all[0].Name != "b" {
+		t.Fatalf("unexpected entries %+v", all)
+	}
+}
@@ -0,0 +1,596 @@
+// Package cookiejar implements an RFC 6265 compliant http.CookieJar that can be persisted to a file.
+package cookiejar
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sleeyax/gotcha/publicsuffix"
+	"golang.org/x/net/idna"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	IllegalDomainError   = errors.New("cookiejar: illegal cookie domain attribute")
+	MalformedDomainError = errors.New("cookiejar: malformed cookie domain attribute")
+)
+
+// endOfTime is the time when session (non-persistent) cookies expire.
+var endOfTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// PublicSuffixList provides the public suffix of a domain.
+// For example, the public suffix of "foo.example.co.uk" is "co.uk".
+//
+// Implementations must be safe for concurrent use.
+type PublicSuffixList interface {
+	// PublicSuffix returns the public suffix of domain.
+	PublicSuffix(domain string) string
+
+	// String returns a description of the source of this public suffix list.
+	String() string
+}
+
+// Options are the options for creating a new Jar.
+type Options struct {
+	// PublicSuffixList determines whether a server can set a cookie for a domain.
+	//
+	// Defaults to the list embedded in the publicsuffix package.
+	PublicSuffixList PublicSuffixList
+
+	// Don't enforce any public suffix list when PublicSuffixList is nil.
+	// This is not secure: it means that the server of foo.co.uk can set a cookie for bar.co.uk.
+	DisablePublicSuffixList bool
+
+	// Filename of the JSON file the cookies are stored in.
+	// The cookies are loaded from it by New, if it exists, and it's rewritten after every change.
+	// Session cookies are stored as well, so that sessions survive a restart.
+	//
+	// Cookies are only kept in memory when empty.
+	Filename string
+
+	// Wait this long after a change before rewriting Filename, so that a burst of changes results in a single write.
+	// Call Save to write pending changes right away, e.g. before exiting.
+	//
+	// Filename is rewritten right after every change when zero.
+	SaveDelay time.Duration
+
+	// OnSaveError is called when Filename can't be rewritten after a change.
+	// Such errors are ignored when it's nil, call Save to handle them instead.
+	OnSaveError func(err error)
+
+	// OnChange is called after a cookie was added, updated or removed, e.g. because it expired.
+	// It's called without holding any locks, so it may use the Jar.
+	//
+	// It's not called by Load.
+	OnChange func(entry Entry, removed bool)
+}
+
+// Jar implements http.CookieJar.
+//
+// A Jar is safe for concurrent use, so it can be shared by multiple clients.
+type Jar struct {
+	psList      PublicSuffixList
+	filename    string
+	onChange    func(Entry, bool)
+	saveDelay   time.Duration
+	onSaveError func(error)
+
+	// fileMu serializes writes to the file, which happen without holding mu.
+	fileMu sync.Mutex
+
+	// timerMu locks timer, which is the pending delayed write, if any.
+	timerMu sync.Mutex
+	timer   *time.Timer
+
+	// mu locks the remaining fields.
+	mu sync.Mutex
+
+	// entries is a set of entries, keyed by their eTLD+1 and subkeyed by their domain;path;name.
+	entries map[string]map[string]Entry
+
+	// nextSeqNum is the next sequence number assigned to a new cookie.
+	nextSeqNum uint64
+}
+
+// New returns a new cookie jar.
+// A nil Options is equivalent to a zero Options, which enforces the embedded public suffix list.
+//
+// When Options.Filename is set and the file exists, the cookies are loaded from it.
+func New(options *Options) (*Jar, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	jar := &Jar{
+		psList:      options.PublicSuffixList,
+		filename:    options.Filename,
+		onChange:    options.OnChange,
+		saveDelay:   options.SaveDelay,
+		onSaveError: options.OnSaveError,
+		entries:     make(map[string]map[string]Entry),
+	}
+	if jar.psList == nil && !options.DisablePublicSuffixList {
+		jar.psList = publicsuffix.Default()
+	}
+
+	if jar.filename != "" {
+		if err := jar.Load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return jar, nil
+}
+
+// Entry is a cookie as stored in the Jar.
+type Entry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Domain of the cookie, without a leading dot.
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+
+	// Either Strict, Lax, None or empty.
+	SameSite string `json:"sameSite,omitempty"`
+
+	Secure   bool `json:"secure,omitempty"`
+	HttpOnly bool `json:"httpOnly,omitempty"`
+
+	// Whether the cookie has an expiry date, i.e. it's not a session cookie.
+	Persistent bool `json:"persistent,omitempty"`
+
+	// Whether the cookie is only sent to Domain itself, not to its subdomains.
+	HostOnly bool `json:"hostOnly,omitempty"`
+
+	// Time at which the cookie expires, only used when Persistent is true.
+	Expires time.Time `json:"expires,omitempty"`
+
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"lastAccess"`
+
+	// seqNum makes the order of cookies with equal path length and creation time deterministic.
+	seqNum uint64
+}
+
+// id returns the domain;path;name triple of e as an id.
+func (e *Entry) id() string {
+	return fmt.Sprintf("%s;%s;%s", e.Domain, e.Path, e.Name)
+}
+
+// expired reports whether e has expired at time now.
+func (e *Entry) expired(now time.Time) bool {
+	return e.Persistent && !e.Expires.After(now)
+}
+
+// shouldSend determines whether e's cookie qualifies to be included in a request to host/path.
+func (e *Entry) shouldSend(https bool, host string, path string) bool {
+	return e.domainMatch(host) && e.pathMatch(path) && (https || !e.Secure)
+}
+
+// domainMatch checks whether e's Domain allows sending e back to host.
+// Cookies with an IP address as Domain are always treated as host cookies.
+func (e *Entry) domainMatch(host string) bool {
+	if e.Domain == host {
+		return true
+	}
+	return !e.HostOnly && hasDotSuffix(host, e.Domain)
+}
+
+// pathMatch implements "path-match" according to RFC 6265 section 5.1.4.
+func (e *Entry) pathMatch(requestPath string) bool {
+	if requestPath == e.Path {
+		return true
+	}
+	if strings.HasPrefix(requestPath, e.Path) {
+		if e.Path[len(e.Path)-1] == '/' {
+			// "/any/" matches "/any/path"
+			return true
+		} else if requestPath[len(e.Path)] == '/' {
+			// "/any" matches "/any/path"
+			return true
+		}
+	}
+	return false
+}
+
+// Cookies implements the Cookies method of the http.CookieJar interface.
+//
+// It returns an empty slice if the URL's scheme is not HTTP or HTTPS.
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return j.cookies(u, time.Now())
+}
+
+// cookies is like Cookies but takes the current time as a parameter.
+func (j *Jar) cookies(u *url.URL, now time.Time) (cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return cookies
+	}
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return cookies
+	}
+	key := jarKey(host, j.psList)
+
+	https := u.Scheme == "https"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	j.mu.Lock()
+
+	submap := j.entries[key]
+	if submap == nil {
+		j.mu.Unlock()
+		return cookies
+	}
+
+	var selected []Entry
+	var changes []change
+	for id, e := range submap {
+		if e.expired(now) {
+			delete(submap, id)
+			changes = append(changes, change{e, true})
+			continue
+		}
+		if !e.shouldSend(https, host, path) {
+			continue
+		}
+		e.LastAccess = now
+		submap[id] = e
+		selected = append(selected, e)
+	}
+	if len(submap) == 0 {
+		delete(j.entries, key)
+	}
+
+	j.mu.Unlock()
+	j.commit(changes)
+	j.notify(changes)
+
+	// sort according to RFC 6265 section 5.4 point 2: by longest path and then by earliest creation time
+	sort.Slice(selected, func(i, k int) bool {
+		a, b := selected[i], selected[k]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) > len(b.Path)
+		}
+		if !a.Creation.Equal(b.Creation) {
+			return a.Creation.Before(b.Creation)
+		}
+		return a.seqNum < b.seqNum
+	})
+	for _, e := range selected {
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+
+	return cookies
+}
+
+// SetCookies implements the SetCookies method of the http.CookieJar interface.
+//
+// It does nothing if the URL's scheme is not HTTP or HTTPS.
+// When the Jar is backed by a file, the file is rewritten if any cookie changed.
+// Errors are passed to Options.OnSaveError.
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.setCookies(u, cookies, time.Now())
+}
+
+// setCookies is like SetCookies but takes the current time as a parameter.
+func (j *Jar) setCookies(u *url.URL, cookies []*http.Cookie, now time.Time) {
+	if len(cookies) == 0 {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return
+	}
+	key := jarKey(host, j.psList)
+	defPath := defaultPath(u.Path)
+
+	j.mu.Lock()
+
+	submap := j.entries[key]
+
+	var changes []change
+	for _, cookie := range cookies {
+		e, remove, err := j.newEntry(cookie, now, defPath, host)
+		if err != nil {
+			continue
+		}
+		id := e.id()
+		if remove {
+			if old, ok := submap[id]; ok {
+				delete(submap, id)
+				changes = append(changes, change{old, true})
+			}
+			continue
+		}
+		if submap == nil {
+			submap = make(map[string]Entry)
+		}
+
+		if old, ok := submap[id]; ok {
+			e.Creation = old.Creation
+			e.seqNum = old.seqNum
+		} else {
+			e.Creation = now
+			e.seqNum = j.nextSeqNum
+			j.nextSeqNum++
+		}
+		e.LastAccess = now
+		submap[id] = e
+		changes = append(changes, change{e, false})
+	}
+
+	if len(submap) == 0 {
+		delete(j.entries, key)
+	} else {
+		j.entries[key] = submap
+	}
+
+	j.mu.Unlock()
+	j.commit(changes)
+	j.notify(changes)
+}
+
+// change is a modification of a cookie in the Jar.
+type change struct {
+	entry   Entry
+	removed bool
+}
+
+// commit rewrites the file of the Jar if any cookie changed, right away or after the SaveDelay.
+// The caller must not hold j.mu.
+func (j *Jar) commit(changes []change) {
+	if len(changes) == 0 || j.filename == "" {
+		return
+	}
+
+	if j.saveDelay <= 0 {
+		j.autoSave()
+		return
+	}
+
+	j.timerMu.Lock()
+	if j.timer == nil {
+		j.timer = time.AfterFunc(j.saveDelay, j.autoSave)
+	}
+	j.timerMu.Unlock()
+}
+
+// autoSave rewrites the file of the Jar after a change, passing errors to the OnSaveError hook.
+func (j *Jar) autoSave() {
+	if err := j.save(); err != nil && j.onSaveError != nil {
+		j.onSaveError(err)
+	}
+}
+
+// notify calls the OnChange hook for every change.
+// The caller must not hold j.mu.
+func (j *Jar) notify(changes []change) {
+	if j.onChange == nil {
+		return
+	}
+	for _, c := range changes {
+		j.onChange(c.entry, c.removed)
+	}
+}
+
+// newEntry creates an Entry from cookie c.
+// now is the current time, defPath and host are the default-path and the canonical host name of the URL c was received from.
+//
+// remove records whether the jar should delete this cookie, as it has already expired.
+// In this case, e may be incomplete, but e.id is valid.
+func (j *Jar) newEntry(c *http.Cookie, now time.Time, defPath string, host string) (e Entry, remove bool, err error) {
+	e.Name = c.Name
+
+	if c.Path == "" || c.Path[0] != '/' {
+		e.Path = defPath
+	} else {
+		e.Path = c.Path
+	}
+
+	e.Domain, e.HostOnly, err = j.domainAndType(host, c.Domain)
+	if err != nil {
+		return e, false, err
+	}
+
+	// MaxAge takes precedence over Expires
+	if c.MaxAge < 0 {
+		return e, true, nil
+	} else if c.MaxAge > 0 {
+		e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		e.Persistent = true
+	} else if c.Expires.IsZero() {
+		e.Expires = endOfTime
+	} else {
+		if !c.Expires.After(now) {
+			return e, true, nil
+		}
+		e.Expires = c.Expires
+		e.Persistent = true
+	}
+
+	e.Value = c.Value
+	e.Secure = c.Secure
+	e.HttpOnly = c.HttpOnly
+
+	switch c.SameSite {
+	case http.SameSiteStrictMode:
+		e.SameSite = "Strict"
+	case http.SameSiteLaxMode:
+		e.SameSite = "Lax"
+	case http.SameSiteNoneMode:
+		e.SameSite = "None"
+	}
+
+	return e, false, nil
+}
+
+// domainAndType determines the cookie's domain and hostOnly attribute.
+func (j *Jar) domainAndType(host string, domain string) (string, bool, error) {
+	if domain == "" {
+		// no domain attribute indicates a host cookie
+		return host, true, nil
+	}
+
+	if isIP(host) {
+		// cookies with an IP address as domain are allowed, but only treated as host cookies
+		if host != strings.TrimPrefix(domain, ".") {
+			return "", false, IllegalDomainError
+		}
+		return host, true, nil
+	}
+
+	// RFC 6265 section 5.2.3: strip the leading dot
+	domain = strings.TrimPrefix(domain, ".")
+
+	if len(domain) == 0 || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return "", false, MalformedDomainError
+	}
+	if !isASCII(domain) {
+		// internationalized domains are compared to the punycode encoded host
+		var err error
+		if domain, err = idna.Lookup.ToASCII(domain); err != nil {
+			return "", false, MalformedDomainError
+		}
+	}
+	domain = strings.ToLower(domain)
+
+	// RFC 6265 section 5.3 point 5: reject cookies for public suffixes
+	if j.isPublicSuffix(domain) {
+		if host == domain {
+			// the one exception in which a cookie with a domain attribute is a host cookie
+			return host, true, nil
+		}
+		return "", false, IllegalDomainError
+	}
+
+	// the domain must domain-match host
+	if host != domain && !hasDotSuffix(host, domain) {
+		return "", false, IllegalDomainError
+	}
+
+	return domain, false, nil
+}
+
+// isPublicSuffix reports whether domain is a public suffix, such as co.uk.
+func (j *Jar) isPublicSuffix(domain string) bool {
+	if j.psList == nil {
+		return false
+	}
+	ps := j.psList.PublicSuffix(domain)
+	return ps != "" && !hasDotSuffix(domain, ps)
+}
+
+// canonicalHost strips the port from host if present and returns the canonicalized host name.
+// Internationalized host names are punycode encoded.
+func canonicalHost(host string) (string, error) {
+	if hasPort(host) {
+		var err error
+		if host, _, err = net.SplitHostPort(host); err != nil {
+			return "", err
+		}
+	}
+
+	// strip the trailing dot of fully qualified domain names
+	host = strings.TrimSuffix(host, ".")
+
+	if !isASCII(host) {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return "", fmt.Errorf("cookiejar: invalid host %q: %w", host, err)
+		}
+		host = ascii
+	}
+
+	return strings.ToLower(host), nil
+}
+
+// hasPort reports whether host contains a port number.
+// host may be a host name, an IPv4 or an IPv6 address.
+func hasPort(host string) bool {
+	colons := strings.Count(host, ":")
+	if colons == 0 {
+		return false
+	}
+	if colons == 1 {
+		return true
+	}
+	return host[0] == '[' && strings.Contains(host, "]:")
+}
+
+// jarKey returns the key to use for a jar, which is the eTLD+1 of host.
+func jarKey(host string, psl PublicSuffixList) string {
+	if isIP(host) {
+		return host
+	}
+
+	var i int
+	if psl == nil {
+		i = strings.LastIndex(host, ".")
+		if i <= 0 {
+			return host
+		}
+	} else {
+		suffix := psl.PublicSuffix(host)
+		if suffix == host {
+			return host
+		}
+		i = len(host) - len(suffix)
+		if i <= 0 || host[i-1] != '.' {
+			// the public suffix list is broken, storing cookies under host is a safe stopgap
+			return host
+		}
+	}
+	prevDot := strings.LastIndex(host[:i-1], ".")
+	return host[prevDot+1:]
+}
+
+// isIP reports whether host is an IP address.
+func isIP(host string) bool {
+	if strings.ContainsAny(host, ":%") {
+		// host names can't contain these characters, so treat it as (IPv6) address to be safe
+		return true
+	}
+	return net.ParseIP(host) != nil
+}
+
+// isASCII reports whether s only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultPath returns the directory part of a URL's path according to RFC 6265 section 5.1.4.
+func defaultPath(path string) string {
+	if len(path) == 0 || path[0] != '/' {
+		// empty or malformed path
+		return "/"
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		// the path has the form "/abc"
+		return "/"
+	}
+	return path[:i]
+}
+
+// hasDotSuffix reports whether s ends in "."+suffix.
+func hasDotSuffix(s string, suffix string) bool {
+	return len(s) > len(suffix) && s[len(s)-len(suffix)-1] == '.' && s[len(s)-len(suffix):] == suffix
+}
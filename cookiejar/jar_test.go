@@ -0,0 +1,264 @@
+package cookiejar
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPSL treats the last label and co.uk as public suffixes.
+type testPSL struct{}
+
+func (testPSL) String() string {
+	return "testPSL"
+}
+
+func (testPSL) PublicSuffix(domain string) string {
+	if domain == "co.uk" || strings.HasSuffix(domain, ".co.uk") {
+		return "co.uk"
+	}
+	return domain[strings.LastIndex(domain, ".")+1:]
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// cookieString returns the cookies that would be sent to u as a string.
+func cookieString(jar *Jar, u string, now time.Time) string {
+	var s []string
+	for _, c := range jar.cookies(mustParseURL(u), now) {
+		s = append(s, c.Name+"="+c.Value)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestJar(t *testing.T) {
+	now := time.Now()
+	jar, _ := New(&Options{PublicSuffixList: testPSL{}})
+
+	jar.setCookies(mustParseURL("https://www.example.co.uk/foo/bar"), []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.co.uk"},
+		{Name: "root", Value: "3", Path: "/"},
+		{Name: "secure", Value: "4", Path: "/", Secure: true},
+		{Name: "expired", Value: "5", Expires: now.Add(-time.Hour)},
+		{Name: "short", Value: "6", Path: "/", MaxAge: 60},
+		{Name: "suffix", Value: "7", Domain: "co.uk"},
+		{Name: "other", Value: "8", Domain: "other.co.uk"},
+	}, now)
+
+	testCases := []struct {
+		url      string
+		now      time.Time
+		expected string
+	}{
+		{"https://www.example.co.uk/foo/baz", now, "host=1 domain=2 root=3 secure=4 short=6"},
+		{"http://www.example.co.uk/foo/baz", now, "host=1 domain=2 root=3 short=6"},
+		{"https://www.example.co.uk/", now, "root=3 secure=4 short=6"},
+		{"https://sub.example.co.uk/foo", now, "domain=2"},
+		{"https://www.example.co.uk/foo/baz", now.Add(time.Hour), "host=1 domain=2 root=3 secure=4"},
+		{"https://other.co.uk/", now, ""},
+		{"ftp://www.example.co.uk/foo/baz", now, ""},
+	}
+
+	for _, tc := range testCases {
+		if s := cookieString(jar, tc.url, tc.now); s != tc.expected {
+			t.Errorf(tests.MismatchFormat, tc.url, tc.expected, s)
+		}
+	}
+
+	// a negative MaxAge deletes the cookie
+	jar.setCookies(mustParseURL("https://www.example.co.uk/foo/bar"), []*http.Cookie{{Name: "host", MaxAge: -1}}, now)
+	if s := cookieString(jar, "https://www.example.co.uk/foo/", now); s != "domain=2 root=3 secure=4" {
+		t.Errorf(tests.MismatchFormat, "cookies after deletion", "domain=2 root=3 secure=4", s)
+	}
+}
+
+func TestJar_IDN(t *testing.T) {
+	now := time.Now()
+	jar, _ := New(nil)
+
+	jar.setCookies(mustParseURL("https://www.bücher.de/"), []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: "bücher.de"},
+	}, now)
+
+	// unicode and punycode encoded hosts share their cookies
+	for _, u := range []string{"https://www.bücher.de/", "https://www.xn--bcher-kva.de/", "https://WWW.BÜCHER.DE/"} {
+		if s := cookieString(jar, u, now); s != "host=1 domain=2" {
+			t.Errorf(tests.MismatchFormat, u, "host=1 domain=2", s)
+		}
+	}
+	if s := cookieString(jar, "https://shop.xn--bcher-kva.de/", now); s != "domain=2" {
+		t.Errorf(tests.MismatchFormat, "cookies of a subdomain", "domain=2", s)
+	}
+	if all := jar.All(); len(all) != 2 || all[0].Domain != "www.xn--bcher-kva.de" || all[1].Domain != "xn--bcher-kva.de" {
+		t.Fatalf("unexpected cookies %+v", all)
+	}
+}
+
+func TestJar_DefaultPublicSuffixList(t *testing.T) {
+	u := mustParseURL("https://www.example.co.uk/")
+	cookies := []*http.Cookie{{Name: "suffix", Value: "1", Domain: "co.uk"}}
+
+	// the embedded list is enforced by default
+	jar, _ := New(nil)
+	jar.SetCookies(u, cookies)
+	if s := cookieString(jar, "https://other.co.uk/", time.Now()); s != "" {
+		t.Fatalf(tests.MismatchFormat, "cookies of another domain", "", s)
+	}
+
+	jar, _ = New(&Options{DisablePublicSuffixList: true})
+	jar.SetCookies(u, cookies)
+	if s := cookieString(jar, "https://other.co.uk/", time.Now()); s != "suffix=1" {
+		t.Fatalf(tests.MismatchFormat, "cookies of another domain", "suffix=1", s)
+	}
+}
+
+func TestJar_File(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.json")
+	u := mustParseURL("https://example.com/")
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	jar, err := New(&Options{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "1", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+		{Name: "persistent", Value: "2", Expires: expires, Secure: true},
+	})
+
+	// a new jar should pick up the stored cookies
+	jar, err = New(&Options{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cookieString(jar, u.String(), time.Now()); s != "session=1 persistent=2" {
+		t.Fatalf(tests.MismatchFormat, "cookies", "session=1 persistent=2", s)
+	}
+
+	entries := jar.entries["example.com"]
+	session := entries["example.com;/;session"]
+	if !session.HostOnly || !session.HttpOnly || session.SameSite != "Lax" || session.Persistent {
+		t.Fatalf("unexpected session cookie %+v", session)
+	}
+	persistent := entries["example.com;/;persistent"]
+	if !persistent.Secure || !persistent.Persistent || !persistent.Expires.Equal(expires) {
+		t.Fatalf("unexpected persistent cookie %+v", persistent)
+	}
+
+	// expired cookies are removed from the file
+	jar.SetCookies(u, []*http.Cookie{{Name: "persistent", MaxAge: -1}})
+	if err = jar.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cookieString(jar, u.String(), time.Now()); s != "session=1" {
+		t.Fatalf(tests.MismatchFormat, "cookies", "session=1", s)
+	}
+}
+
+func TestJar_FileExpiry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.json")
+	u := mustParseURL("https://example.com/")
+	now := time.Now()
+
+	jar, _ := New(&Options{Filename: filename})
+	jar.setCookies(u, []*http.Cookie{{Name: "short", Value: "1", MaxAge: 60}, {Name: "long", Value: "2", MaxAge: 3600}}, now)
+
+	// the cookie that expired when the cookies were requested is removed from the file as well
+	cookieString(jar, u.String(), now.Add(time.Hour/2))
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "short") || !strings.Contains(string(data), "long") {
+		t.Fatalf("expected only the long cookie to be stored, but got %s", data)
+	}
+}
+
+func TestJar_SaveDelay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.json")
+	u := mustParseURL("https://example.com/")
+
+	jar, _ := New(&Options{Filename: filename, SaveDelay: 50 * time.Millisecond})
+	jar.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	jar.SetCookies(u, []*http.Cookie{{Name: "b", Value: "2"}})
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be written after the delay, but got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stored, err := New(&Options{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cookieString(stored, u.String(), time.Now()); s != "a=1 b=2" {
+		t.Fatalf(tests.MismatchFormat, "stored cookies", "a=1 b=2", s)
+	}
+
+	// Save writes pending changes right away
+	jar.SetCookies(u, []*http.Cookie{{Name: "c", Value: "3"}})
+	if err = jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err = stored.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cookieString(stored, u.String(), time.Now()); s != "a=1 b=2 c=3" {
+		t.Fatalf(tests.MismatchFormat, "stored cookies", "a=1 b=2 c=3", s)
+	}
+}
+
+func TestJar_SaveError(t *testing.T) {
+	var errs []error
+	jar, err := New(&Options{
+		Filename:    filepath.Join(t.TempDir(), "missing", "cookies.json"),
+		OnSaveError: func(err error) { errs = append(errs, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jar.SetCookies(mustParseURL("https://example.com/"), []*http.Cookie{{Name: "a", Value: "1"}})
+	if len(errs) != 1 {
+		t.Fatalf(tests.MismatchFormat, "save errors", 1, len(errs))
+	}
+	if err = jar.Save(); err == nil {
+		t.Fatal("expected Save to fail")
+	}
+}
+
+func TestJar_Concurrency(t *testing.T) {
+	jar, _ := New(&Options{Filename: filepath.Join(t.TempDir(), "cookies.json")})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := mustParseURL("https://example.com/")
+			jar.SetCookies(u, []*http.Cookie{{Name: "c" + string(rune('0'+i)), Value: "v"}})
+			jar.Cookies(u)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := jar.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(jar.Cookies(mustParseURL("https://example.com/"))); n != 10 {
+		t.Fatalf(tests.MismatchFormat, "number of cookies", 10, n)
+	}
+}
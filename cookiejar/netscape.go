@@ -0,0 +1,145 @@
+package cookiejar
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix of cookies that are marked HttpOnly in the Netscape format, as written by curl.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// LoadNetscape loads cookies in the Netscape cookies.txt format, as used by curl and browser extensions, into jar.
+// jar can be any http.CookieJar, e.g. the Options.CookieJar of a client.
+//
+// Cookies with an expiry date of 0 are loaded as session cookies, expired cookies are skipped.
+func LoadNetscape(jar http.CookieJar, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	now := time.Now()
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		var httpOnly bool
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = line[len(httpOnlyPrefix):]
+		} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		// some exporters omit the value of empty cookies
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("cookiejar: line %d: expected 7 fields, got %d", n, len(fields))
+		}
+
+		domain, includeSubdomains, path, secure, name, value := fields[0], fields[1] == "TRUE", fields[2], fields[3] == "TRUE", fields[5], fields[6]
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookiejar: line %d: invalid expiry: %w", n, err)
+		}
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if expires != 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if !cookie.Expires.After(now) {
+				continue
+			}
+		}
+
+		setCookie(jar, domain, !includeSubdomains, cookie)
+	}
+
+	return scanner.Err()
+}
+
+// setCookie adds cookie to jar as if it was received from domain.
+// A leading dot in domain is ignored.
+func setCookie(jar http.CookieJar, domain string, hostOnly bool, cookie *http.Cookie) {
+	host := strings.TrimPrefix(domain, ".")
+	if !hostOnly {
+		cookie.Domain = host
+	}
+
+	scheme := "http"
+	if cookie.Secure {
+		scheme = "https"
+	}
+
+	path := cookie.Path
+	if path == "" {
+		path = "/"
+	}
+
+	jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: path}, []*http.Cookie{cookie})
+}
+
+// LoadNetscapeFile loads the cookies of a Netscape cookies.txt file into jar.
+func LoadNetscapeFile(jar http.CookieJar, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return LoadNetscape(jar, f)
+}
+
+// WriteNetscape writes all cookies of jar to w in the Netscape cookies.txt format.
+// Session cookies are written with an expiry date of 0.
+func WriteNetscape(w io.Writer, jar *Jar) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n\n")
+
+	for _, e := range jar.All() {
+		domain, includeSubdomains := e.Domain, "FALSE"
+		if !e.HostOnly {
+			domain, includeSubdomains = "."+e.Domain, "TRUE"
+		}
+		if e.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+
+		var expires int64
+		if e.Persistent {
+			expires = e.Expires.Unix()
+		}
+
+		secure := "FALSE"
+		if e.Secure {
+			secure = "TRUE"
+		}
+
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, includeSubdomains, e.Path, secure, expires, e.Name, e.Value)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// SaveNetscapeFile writes all cookies of jar to a Netscape cookies.txt file, replacing it atomically.
+func SaveNetscapeFile(filename string, jar *Jar) error {
+	var buf bytes.Buffer
+	if err := WriteNetscape(&buf, jar); err != nil {
+		return err
+	}
+	return writeFile(filename, buf.Bytes())
+}
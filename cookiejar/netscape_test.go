@@ -0,0 +1,86 @@
+package cookiejar
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetscape(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+
+	input := fmt.Sprintf(`# Netscape HTTP Cookie File
+# https://curl.se/docs/http-cookies.html
+
+.example.com	TRUE	/	FALSE	%[1]d	domain	1
+#HttpOnly_www.example.com	FALSE	/	TRUE	0	session	2
+www.example.com	FALSE	/foo	FALSE	%[1]d	path	3
+www.example.com	FALSE	/	FALSE	1	expired	4
+www.example.com	FALSE	/	FALSE	%[1]d	empty
+`, expires)
+
+	jar, _ := New(nil)
+	if err := LoadNetscape(jar, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for u, expected := range map[string]string{
+		"https://www.example.com/foo": "path=3 domain=1 session=2 empty=",
+		"http://www.example.com/":     "domain=1 empty=",
+		"http://sub.example.com/":     "domain=1",
+	} {
+		if s := cookieString(jar, u, now); s != expected {
+			t.Errorf(tests.MismatchFormat, u, expected, s)
+		}
+	}
+
+	session := jar.entries["example.com"]["www.example.com;/;session"]
+	if !session.HttpOnly || !session.HostOnly || session.Persistent {
+		t.Fatalf("unexpected session cookie %+v", session)
+	}
+
+	// any http.CookieJar can be loaded
+	std, _ := cookiejar.New(nil)
+	if err := LoadNetscape(std, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(std.Cookies(mustParseURL("https://www.example.com/foo"))); n != 4 {
+		t.Fatalf(tests.MismatchFormat, "number of cookies", 4, n)
+	}
+
+	// writing and loading the cookies again should result in the same file
+	var buf bytes.Buffer
+	if err := WriteNetscape(&buf, jar); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("# Netscape HTTP Cookie File\n\n"+
+		".example.com\tTRUE\t/\tFALSE\t%[1]d\tdomain\t1\n"+
+		"www.example.com\tFALSE\t/\tFALSE\t%[1]d\tempty\t\n"+
+		"#HttpOnly_www.example.com\tFALSE\t/\tTRUE\t0\tsession\t2\n"+
+		"www.example.com\tFALSE\t/foo\tFALSE\t%[1]d\tpath\t3\n", expires)
+	if s := buf.String(); s != expected {
+		t.Fatalf(tests.MismatchFormat, "cookies.txt", expected, s)
+	}
+
+	jar, _ = New(nil)
+	if err := LoadNetscape(jar, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	WriteNetscape(&buf, jar)
+	if s := buf.String(); s != expected {
+		t.Fatalf(tests.MismatchFormat, "cookies.txt", expected, s)
+	}
+}
+
+func TestNetscape_Malformed(t *testing.T) {
+	jar, _ := New(nil)
+	if err := LoadNetscape(jar, strings.NewReader("example.com\tFALSE\t/\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,149 @@
+package cookiejar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version of the file format.
+const fileVersion = 1
+
+// file is the JSON representation of a Jar.
+type file struct {
+	Version int     `json:"version"`
+	Cookies []Entry `json:"cookies"`
+}
+
+// Load replaces the cookies in the Jar with those stored in its file.
+// Expired cookies are skipped. A missing file results in an empty Jar.
+func (j *Jar) Load() error {
+	if j.filename == "" {
+		return errors.New("cookiejar: jar is not backed by a file")
+	}
+
+	data, err := os.ReadFile(j.filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var f file
+	if len(data) != 0 {
+		if err = json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("cookiejar: failed to parse %s: %w", j.filename, err)
+		}
+		if f.Version != fileVersion {
+			return fmt.Errorf("cookiejar: unsupported file version %d", f.Version)
+		}
+	}
+
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = make(map[string]map[string]Entry)
+	for _, e := range f.Cookies {
+		e.Domain = strings.ToLower(e.Domain)
+		// the file might have been edited, so enforce the public suffix list
+		if e.expired(now) || (!e.HostOnly && !isIP(e.Domain) && j.isPublicSuffix(e.Domain)) {
+			continue
+		}
+		if !e.Persistent {
+			e.Expires = endOfTime
+		}
+		e.seqNum = j.nextSeqNum
+		j.nextSeqNum++
+
+		key := jarKey(e.Domain, j.psList)
+		if j.entries[key] == nil {
+			j.entries[key] = make(map[string]Entry)
+		}
+		j.entries[key][e.id()] = e
+	}
+
+	return nil
+}
+
+// Save writes all cookies to the file of the Jar, including changes that are pending because of Options.SaveDelay.
+//
+// The file is replaced atomically, so concurrent readers never observe a partially written file.
+func (j *Jar) Save() error {
+	if j.filename == "" {
+		return errors.New("cookiejar: jar is not backed by a file")
+	}
+	return j.save()
+}
+
+// save writes all cookies that haven't expired to the file of the Jar and cancels the pending delayed write.
+// The cookies are copied while holding j.mu, the file is written without holding it.
+func (j *Jar) save() error {
+	j.fileMu.Lock()
+	defer j.fileMu.Unlock()
+
+	j.timerMu.Lock()
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	j.timerMu.Unlock()
+
+	now := time.Now()
+	f := file{Version: fileVersion, Cookies: []Entry{}}
+
+	j.mu.Lock()
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if !e.expired(now) {
+				f.Cookies = append(f.Cookies, e)
+			}
+		}
+	}
+	j.mu.Unlock()
+
+	// store cookies in creation order, which Load relies on to restore the order of cookies created at the same time
+	sort.Slice(f.Cookies, func(a, b int) bool {
+		if !f.Cookies[a].Creation.Equal(f.Cookies[b].Creation) {
+			return f.Cookies[a].Creation.Before(f.Cookies[b].Creation)
+		}
+		return f.Cookies[a].seqNum < f.Cookies[b].seqNum
+	})
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(j.filename, data)
+}
+
+// writeFile atomically replaces the file with given name by writing to a temporary file first.
+// The file is only readable by its owner, because cookies are credentials.
+func writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	// clean up when anything goes wrong, this is a no-op after a successful rename
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
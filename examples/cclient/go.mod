@@ -11,4 +11,5 @@ require (
 replace (
 	github.com/sleeyax/gotcha => ../..
 	github.com/sleeyax/gotcha/adapters/cclient => ../../adapters/cclient
+	github.com/sleeyax/gotcha/fingerprint => ../../fingerprint
 )
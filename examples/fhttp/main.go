@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/sleeyax/gotcha"
 	"github.com/sleeyax/gotcha/adapters/fhttp"
-	http "github.com/useflyent/fhttp"
 	"log"
 )
 
@@ -17,11 +16,11 @@ func main() {
 	}
 	res, _ := client.Get("https://httpbin.org/anything", &gotcha.Options{
 		Headers: map[string][]string{
-			"user-agent":        {"gotcha By Sleeyax (https://github.com/sleeyax/gotcha)"},
-			"foo":               {"bar"},
-			"abc":               {"def", "123"},
-			http.HeaderOrderKey: {"abc", "user-agent", "foo"},
+			"user-agent": {"gotcha By Sleeyax (https://github.com/sleeyax/gotcha)"},
+			"foo":        {"bar"},
+			"abc":        {"def", "123"},
 		},
+		HeaderOrder: []string{"abc", "user-agent", "foo"},
 	})
 	fmt.Println(res.Text())
 }
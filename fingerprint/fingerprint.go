@@ -0,0 +1,402 @@
+// Package fingerprint converts TLS fingerprints such as JA3 and JA4 into utls ClientHelloSpecs,
+// so the ClientHello of a client can be reproduced from a fingerprint captured from real traffic.
+//
+// Fingerprints don't contain the contents of every extension (e.g. ALPN protocols or signature algorithms).
+// These are taken from Options, which default to the values Chrome sends.
+package fingerprint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidFingerprintError     = errors.New("invalid fingerprint")
+	UnsupportedCipherSuiteError = errors.New("unsupported cipher suite")
+	UnsupportedExtensionError   = errors.New("unsupported extension")
+	UnsupportedCurveError       = errors.New("unsupported curve")
+)
+
+// Extension identifiers that utls doesn't declare.
+const (
+	extensionEncryptThenMac          uint16 = 22
+	extensionDelegatedCredentials    uint16 = 34
+	extensionPostHandshakeAuth       uint16 = 49
+	extensionSignatureAlgorithmsCert uint16 = 50
+	extensionApplicationSettings     uint16 = 17513
+)
+
+// Options specify the contents of extensions that aren't part of a fingerprint.
+type Options struct {
+	// Protocols of the application_layer_protocol_negotiation extension (16).
+	// Defaults to h2 and http/1.1.
+	ALPN []string
+
+	// Protocols of the application_settings extension (17513).
+	// Defaults to h2.
+	ApplicationSettings []string
+
+	// Signature algorithms of the signature_algorithms extension (13), unless the fingerprint specifies them.
+	// Also used for the signature_algorithms_cert extension (50).
+	// Defaults to the algorithms Chrome supports.
+	SignatureAlgorithms []tls.SignatureScheme
+
+	// Signature algorithms of the delegated_credentials extension (34).
+	// Defaults to the algorithms Firefox supports.
+	DelegatedCredentials []tls.SignatureScheme
+
+	// Versions of the supported_versions extension (43).
+	// Defaults to TLS 1.3 and TLS 1.2.
+	SupportedVersions []uint16
+
+	// Curves to send a key share for in the key_share extension (51).
+	// Defaults to the first supported curve of the fingerprint.
+	KeyShareCurves []tls.CurveID
+
+	// Algorithms of the compress_certificate extension (27).
+	// Defaults to brotli.
+	CertCompressionAlgorithms []tls.CertCompressionAlgo
+
+	// Limit of the record_size_limit extension (28).
+	// Defaults to 16385.
+	RecordSizeLimit uint16
+
+	// Add GREASE values (RFC 8701) the way Chrome does: to the cipher suites, curves, key shares, supported versions
+	// and as the first and last extension before padding.
+	// Fingerprints usually exclude GREASE values, so they still match.
+	GREASE bool
+}
+
+// Parse builds the ClientHelloSpec of a JA3 fingerprint string or a raw JA4 fingerprint, see ParseJA3 and ParseJA4.
+// options may be nil.
+func Parse(fingerprint string, options *Options) (*tls.ClientHelloSpec, error) {
+	if strings.Contains(fingerprint, "_") {
+		return ParseJA4(fingerprint, options)
+	}
+	return ParseJA3(fingerprint, options)
+}
+
+// spec is the parsed content of a fingerprint.
+type spec struct {
+	version             uint16
+	ciphers             []uint16
+	extensions          []uint16
+	curves              []tls.CurveID
+	points              []uint8
+	signatureAlgorithms []tls.SignatureScheme
+}
+
+// supportedCipherSuites are the cipher suites utls can either negotiate or is known to advertise when parroting browsers.
+var supportedCipherSuites = map[uint16]bool{
+	tls.TLS_AES_128_GCM_SHA256:                             true,
+	tls.TLS_AES_256_GCM_SHA384:                             true,
+	tls.TLS_CHACHA20_POLY1305_SHA256:                       true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:               true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256:            true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:            true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:               true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384:            true,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:             true,
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:                   true,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:                true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:                 true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:              true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:              true,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:                 true,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:              true,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305:               true,
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:                     true,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:                      true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:                       true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:                    true,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:                    true,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:                       true,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:                    true,
+	tls.TLS_RSA_WITH_RC4_128_SHA:                           true,
+	tls.TLS_FALLBACK_SCSV:                                  true,
+	tls.OLD_TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:    true,
+	tls.OLD_TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:  true,
+	tls.DISABLED_TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384:   true,
+	tls.DISABLED_TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384:     true,
+	tls.DISABLED_TLS_RSA_WITH_AES_256_CBC_SHA256:           true,
+	tls.FAKE_OLD_TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256: true,
+	tls.FAKE_TLS_DHE_RSA_WITH_AES_128_GCM_SHA256:           true,
+	tls.FAKE_TLS_DHE_RSA_WITH_AES_128_CBC_SHA:              true,
+	tls.FAKE_TLS_DHE_RSA_WITH_AES_256_CBC_SHA:              true,
+	tls.FAKE_TLS_DHE_RSA_WITH_AES_256_GCM_SHA384:           true,
+	tls.FAKE_TLS_RSA_WITH_RC4_128_MD5:                      true,
+	tls.FAKE_TLS_EMPTY_RENEGOTIATION_INFO_SCSV:             true,
+}
+
+// keyShareCurves are the curves utls can generate a key share for.
+var keyShareCurves = map[tls.CurveID]bool{
+	tls.X25519:    true,
+	tls.CurveP256: true,
+	tls.CurveP384: true,
+	tls.CurveP521: true,
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// build builds the ClientHelloSpec of s.
+func (s *spec) build(options *Options) (*tls.ClientHelloSpec, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	var ciphers []uint16
+	if options.GREASE {
+		ciphers = append(ciphers, tls.GREASE_PLACEHOLDER)
+	}
+	for _, c := range s.ciphers {
+		if isGREASE(c) {
+			c = tls.GREASE_PLACEHOLDER
+		} else if !supportedCipherSuites[c] {
+			return nil, fmt.Errorf("%w: %d (0x%04x)", UnsupportedCipherSuiteError, c, c)
+		}
+		ciphers = append(ciphers, c)
+	}
+
+	var curves []tls.CurveID
+	if options.GREASE {
+		curves = append(curves, tls.CurveID(tls.GREASE_PLACEHOLDER))
+	}
+	for _, c := range s.curves {
+		if isGREASE(uint16(c)) {
+			c = tls.CurveID(tls.GREASE_PLACEHOLDER)
+		}
+		curves = append(curves, c)
+	}
+
+	signatureAlgorithms := s.signatureAlgorithms
+	if len(signatureAlgorithms) == 0 {
+		signatureAlgorithms = options.SignatureAlgorithms
+	}
+	if len(signatureAlgorithms) == 0 {
+		signatureAlgorithms = []tls.SignatureScheme{
+			tls.ECDSAWithP256AndSHA256,
+			tls.PSSWithSHA256,
+			tls.PKCS1WithSHA256,
+			tls.ECDSAWithP384AndSHA384,
+			tls.PSSWithSHA384,
+			tls.PKCS1WithSHA384,
+			tls.PSSWithSHA512,
+			tls.PKCS1WithSHA512,
+		}
+	}
+
+	result := &tls.ClientHelloSpec{
+		CipherSuites:       ciphers,
+		CompressionMethods: []uint8{0},
+		TLSVersMin:         tls.VersionTLS10,
+		TLSVersMax:         s.version,
+	}
+
+	if options.GREASE {
+		result.Extensions = append(result.Extensions, &tls.UtlsGREASEExtension{})
+	}
+
+	for _, id := range s.extensions {
+		extension, err := s.extension(id, curves, signatureAlgorithms, options)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := extension.(*tls.SupportedVersionsExtension); ok {
+			// utls derives the versions from the extension
+			result.TLSVersMin, result.TLSVersMax = 0, 0
+		}
+		result.Extensions = append(result.Extensions, extension)
+	}
+
+	// the second GREASE extension comes last, but before padding
+	if options.GREASE {
+		grease := &tls.UtlsGREASEExtension{Body: []byte{0}}
+		last := len(result.Extensions) - 1
+		if _, ok := result.Extensions[last].(*tls.UtlsPaddingExtension); ok {
+			result.Extensions = append(result.Extensions[:last], grease, result.Extensions[last])
+		} else {
+			result.Extensions = append(result.Extensions, grease)
+		}
+	}
+
+	return result, nil
+}
+
+// extension returns the extension with given id.
+func (s *spec) extension(id uint16, curves []tls.CurveID, signatureAlgorithms []tls.SignatureScheme, options *Options) (tls.TLSExtension, error) {
+	if isGREASE(id) {
+		return &tls.UtlsGREASEExtension{}, nil
+	}
+
+	switch id {
+	case 0:
+		return &tls.SNIExtension{}, nil
+	case 5:
+		return &tls.StatusRequestExtension{}, nil
+	case 10:
+		return &tls.SupportedCurvesExtension{Curves: curves}, nil
+	case 11:
+		points := s.points
+		if len(points) == 0 {
+			points = []uint8{0}
+		}
+		return &tls.SupportedPointsExtension{SupportedPoints: points}, nil
+	case 13:
+		return &tls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: signatureAlgorithms}, nil
+	case 16:
+		alpn := options.ALPN
+		if len(alpn) == 0 {
+			alpn = []string{"h2", "http/1.1"}
+		}
+		return &tls.ALPNExtension{AlpnProtocols: alpn}, nil
+	case 18:
+		return &tls.SCTExtension{}, nil
+	case 21:
+		return &tls.UtlsPaddingExtension{GetPaddingLen: tls.BoringPaddingStyle}, nil
+	case extensionEncryptThenMac, extensionPostHandshakeAuth:
+		return &tls.GenericExtension{Id: id}, nil
+	case 23:
+		return &tls.UtlsExtendedMasterSecretExtension{}, nil
+	case 27:
+		algorithms := options.CertCompressionAlgorithms
+		if len(algorithms) == 0 {
+			algorithms = []tls.CertCompressionAlgo{tls.CertCompressionBrotli}
+		}
+		return &tls.FakeCertCompressionAlgsExtension{Methods: algorithms}, nil
+	case 28:
+		limit := options.RecordSizeLimit
+		if limit == 0 {
+			limit = 0x4001
+		}
+		return &tls.FakeRecordSizeLimitExtension{Limit: limit}, nil
+	case extensionDelegatedCredentials:
+		algorithms := options.DelegatedCredentials
+		if len(algorithms) == 0 {
+			algorithms = []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.ECDSAWithP384AndSHA384, tls.ECDSAWithP521AndSHA512, tls.ECDSAWithSHA1}
+		}
+		return &tls.GenericExtension{Id: id, Data: signatureSchemes(algorithms)}, nil
+	case 35:
+		return &tls.SessionTicketExtension{}, nil
+	case 43:
+		versions := options.SupportedVersions
+		if len(versions) == 0 {
+			versions = []uint16{tls.VersionTLS13, tls.VersionTLS12}
+		}
+		if options.GREASE {
+			versions = append([]uint16{tls.GREASE_PLACEHOLDER}, versions...)
+		}
+		return &tls.SupportedVersionsExtension{Versions: versions}, nil
+	case 45:
+		return &tls.PSKKeyExchangeModesExtension{Modes: []uint8{tls.PskModeDHE}}, nil
+	case extensionSignatureAlgorithmsCert:
+		return &tls.GenericExtension{Id: id, Data: signatureSchemes(signatureAlgorithms)}, nil
+	case 51:
+		keyShareCurves, err := s.keyShareCurves(curves, options)
+		if err != nil {
+			return nil, err
+		}
+		var keyShares []tls.KeyShare
+		if options.GREASE {
+			keyShares = append(keyShares, tls.KeyShare{Group: tls.CurveID(tls.GREASE_PLACEHOLDER), Data: []byte{0}})
+		}
+		for _, curve := range keyShareCurves {
+			keyShares = append(keyShares, tls.KeyShare{Group: curve})
+		}
+		return &tls.KeyShareExtension{KeyShares: keyShares}, nil
+	case 13172:
+		return &tls.NPNExtension{}, nil
+	case extensionApplicationSettings:
+		protocols := options.ApplicationSettings
+		if len(protocols) == 0 {
+			protocols = []string{"h2"}
+		}
+		var data []byte
+		for _, p := range protocols {
+			data = append(data, byte(len(p)))
+			data = append(data, p...)
+		}
+		return &tls.GenericExtension{Id: id, Data: append([]byte{byte(len(data) >> 8), byte(len(data))}, data...)}, nil
+	case 30032:
+		return &tls.FakeChannelIDExtension{}, nil
+	case 65281:
+		return &tls.RenegotiationInfoExtension{Renegotiation: tls.RenegotiateOnceAsClient}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %d", UnsupportedExtensionError, id)
+}
+
+// keyShareCurves returns the curves to send a key share for.
+func (s *spec) keyShareCurves(curves []tls.CurveID, options *Options) ([]tls.CurveID, error) {
+	if len(options.KeyShareCurves) != 0 {
+		for _, curve := range options.KeyShareCurves {
+			if !keyShareCurves[curve] {
+				return nil, fmt.Errorf("%w: %d", UnsupportedCurveError, curve)
+			}
+		}
+		return options.KeyShareCurves, nil
+	}
+
+	for _, curve := range curves {
+		if keyShareCurves[curve] {
+			return []tls.CurveID{curve}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no key share can be generated for any of the curves %v", UnsupportedCurveError, curves)
+}
+
+// signatureSchemes encodes a list of signature schemes.
+func signatureSchemes(schemes []tls.SignatureScheme) []byte {
+	data := []byte{byte(len(schemes) * 2 >> 8), byte(len(schemes) * 2)}
+	for _, s := range schemes {
+		data = append(data, byte(s>>8), byte(s))
+	}
+	return data
+}
+
+// TLSClient returns a function that performs the TLS handshake with a ClientHello built by newSpec.
+// It can be used as the TLSClient of a gotcha.RawAdapter.
+//
+// A new spec is needed for every connection, because utls modifies its extensions.
+// config may be nil, its ServerName defaults to the serverName passed to the returned function.
+func TLSClient(newSpec func() (*tls.ClientHelloSpec, error), config *tls.Config) func(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error) {
+	return func(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error) {
+		spec, err := newSpec()
+		if err != nil {
+			return nil, err
+		}
+
+		var c *tls.Config
+		if config != nil {
+			c = config.Clone()
+		} else {
+			c = &tls.Config{}
+		}
+		if c.ServerName == "" {
+			c.ServerName = serverName
+		}
+
+		uconn := tls.UClient(conn, c, tls.HelloCustom)
+		if err = uconn.ApplyPreset(spec); err != nil {
+			return nil, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+		if err = uconn.Handshake(); err != nil {
+			return nil, err
+		}
+
+		return uconn, nil
+	}
+}
@@ -0,0 +1,147 @@
+package fingerprint
+
+import (
+	"context"
+	stdtls "crypto/tls"
+	"errors"
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const chrome83JA3 = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-21,29-23-24,0"
+
+const chrome83JA4 = "t13d1515h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,ff01_0403,0804,0401,0503,0805,0501,0806,0601"
+
+// handshake performs a TLS handshake with spec against a local server and returns the ClientHello it received.
+func handshake(t *testing.T, newSpec func() (*tls.ClientHelloSpec, error)) *stdtls.ClientHelloInfo {
+	hellos := make(chan *stdtls.ClientHelloInfo, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &stdtls.Config{
+		GetConfigForClient: func(hello *stdtls.ClientHelloInfo) (*stdtls.Config, error) {
+			hellos <- hello
+			return nil, nil
+		},
+	}
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tc, err := TLSClient(newSpec, &tls.Config{InsecureSkipVerify: true})(context.Background(), conn, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Close()
+
+	return <-hellos
+}
+
+func TestParseJA3(t *testing.T) {
+	spec, err := ParseJA3(chrome83JA3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ja3 := JA3(spec); ja3 != chrome83JA3 {
+		t.Fatalf("expected JA3 '%s', but got '%s' instead", chrome83JA3, ja3)
+	}
+
+	hello := handshake(t, func() (*tls.ClientHelloSpec, error) {
+		return ParseJA3(chrome83JA3, nil)
+	})
+	if expected := []uint16{4865, 4866, 4867, 49195, 49199, 49196, 49200, 52393, 52392, 49171, 49172, 156, 157, 47, 53}; !reflect.DeepEqual(hello.CipherSuites, expected) {
+		t.Fatalf("expected cipher suites %v, but got %v instead", expected, hello.CipherSuites)
+	}
+	if expected := []stdtls.CurveID{29, 23, 24}; !reflect.DeepEqual(hello.SupportedCurves, expected) {
+		t.Fatalf("expected curves %v, but got %v instead", expected, hello.SupportedCurves)
+	}
+	if hello.ServerName != "example.com" {
+		t.Fatalf("expected server name 'example.com', but got '%s' instead", hello.ServerName)
+	}
+}
+
+func TestParseJA3_GREASE(t *testing.T) {
+	spec, err := ParseJA3(chrome83JA3, &Options{GREASE: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GREASE values are ignored by JA3
+	if ja3 := JA3(spec); ja3 != chrome83JA3 {
+		t.Fatalf("expected JA3 '%s', but got '%s' instead", chrome83JA3, ja3)
+	}
+
+	n := len(spec.Extensions)
+	if _, ok := spec.Extensions[0].(*tls.UtlsGREASEExtension); !ok {
+		t.Fatalf("expected the first extension to be GREASE, got %T", spec.Extensions[0])
+	}
+	if _, ok := spec.Extensions[n-2].(*tls.UtlsGREASEExtension); !ok {
+		t.Fatalf("expected the extension before padding to be GREASE, got %T", spec.Extensions[n-2])
+	}
+
+	handshake(t, func() (*tls.ClientHelloSpec, error) {
+		return ParseJA3(chrome83JA3, &Options{GREASE: true})
+	})
+}
+
+func TestParseJA3_Invalid(t *testing.T) {
+	for ja3, expected := range map[string]error{
+		"771,4865,0,29":              InvalidFingerprintError,
+		"771,abc,0,29,0":             InvalidFingerprintError,
+		"771,4660,0-10,29,0":         UnsupportedCipherSuiteError,
+		"771,4865,0-41,29,0":         UnsupportedExtensionError,
+		"771,4865,0-10-51,25497,0":   UnsupportedCurveError,
+		"771,4865-4866,0-10-11,29,0": nil,
+		"771,4865,0-10-11,29-23-24,": nil,
+	} {
+		if _, err := ParseJA3(ja3, nil); !errors.Is(err, expected) {
+			t.Errorf("%s: expected error '%v', but got '%v' instead", ja3, expected, err)
+		}
+	}
+}
+
+func TestParseJA4(t *testing.T) {
+	spec, err := ParseJA4(chrome83JA4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(spec.CipherSuites); n != 15 {
+		t.Fatalf("expected 15 cipher suites, but got %d instead", n)
+	}
+	if _, ok := spec.Extensions[0].(*tls.SNIExtension); !ok {
+		t.Fatalf("expected the first extension to be SNI, got %T", spec.Extensions[0])
+	}
+	alpn, ok := spec.Extensions[len(spec.Extensions)-1].(*tls.ALPNExtension)
+	if !ok || fmt.Sprint(alpn.AlpnProtocols) != "[h2 http/1.1]" {
+		t.Fatalf("unexpected last extension %#v", spec.Extensions[len(spec.Extensions)-1])
+	}
+
+	handshake(t, func() (*tls.ClientHelloSpec, error) {
+		return ParseJA4(chrome83JA4, nil)
+	})
+
+	if _, err = ParseJA4("t13d1516h2_8daaf6152771_e5627efa2ab1", nil); !errors.Is(err, InvalidFingerprintError) {
+		t.Fatalf("expected error '%v', but got '%v' instead", InvalidFingerprintError, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, fp := range []string{chrome83JA3, chrome83JA4} {
+		if _, err := Parse(fp, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
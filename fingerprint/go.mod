@@ -0,0 +1,8 @@
+module github.com/sleeyax/gotcha/fingerprint
+
+go 1.16
+
+require (
+	github.com/refraction-networking/utls v0.0.0-20210713165636-0b2885c8c0d4
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+)
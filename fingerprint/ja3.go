@@ -0,0 +1,154 @@
+package fingerprint
+
+import (
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"strconv"
+	"strings"
+)
+
+// ParseJA3 builds the ClientHelloSpec of a JA3 fingerprint string (not its MD5 hash), e.g.
+// 771,4865-4866-4867-49195,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-21,29-23-24,0.
+//
+// The fields are the TLS version, cipher suites, extensions, elliptic curves and elliptic curve point formats, in that order.
+// options may be nil.
+func ParseJA3(ja3 string, options *Options) (*tls.ClientHelloSpec, error) {
+	fields := strings.Split(strings.TrimSpace(ja3), ",")
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: JA3 must have 5 fields, got %d", InvalidFingerprintError, len(fields))
+	}
+
+	version, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid TLS version %q", InvalidFingerprintError, fields[0])
+	}
+
+	s := &spec{version: uint16(version)}
+
+	if s.ciphers, err = parseJA3List(fields[1], 16); err != nil {
+		return nil, err
+	}
+	if s.extensions, err = parseJA3List(fields[2], 16); err != nil {
+		return nil, err
+	}
+
+	curves, err := parseJA3List(fields[3], 16)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range curves {
+		s.curves = append(s.curves, tls.CurveID(c))
+	}
+
+	points, err := parseJA3List(fields[4], 8)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range points {
+		s.points = append(s.points, uint8(p))
+	}
+
+	return s.build(options)
+}
+
+// parseJA3List parses a dash separated list of decimal numbers of given bit size.
+func parseJA3List(field string, bitSize int) ([]uint16, error) {
+	if field == "" {
+		return nil, nil
+	}
+
+	var values []uint16
+	for _, v := range strings.Split(field, "-") {
+		n, err := strconv.ParseUint(v, 10, bitSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid value %q", InvalidFingerprintError, v)
+		}
+		values = append(values, uint16(n))
+	}
+
+	return values, nil
+}
+
+// JA3 returns the JA3 fingerprint string of spec, ignoring GREASE values.
+// Extensions of unknown types are skipped.
+func JA3(spec *tls.ClientHelloSpec) string {
+	version := spec.TLSVersMax
+	var curves, points []string
+	var extensions []string
+
+	for _, e := range spec.Extensions {
+		var id uint16
+		switch e := e.(type) {
+		case *tls.SNIExtension:
+			id = 0
+		case *tls.StatusRequestExtension:
+			id = 5
+		case *tls.SupportedCurvesExtension:
+			id = 10
+			for _, c := range e.Curves {
+				if !isGREASE(uint16(c)) {
+					curves = append(curves, strconv.Itoa(int(c)))
+				}
+			}
+		case *tls.SupportedPointsExtension:
+			id = 11
+			for _, p := range e.SupportedPoints {
+				points = append(points, strconv.Itoa(int(p)))
+			}
+		case *tls.SignatureAlgorithmsExtension:
+			id = 13
+		case *tls.ALPNExtension:
+			id = 16
+		case *tls.SCTExtension:
+			id = 18
+		case *tls.UtlsPaddingExtension:
+			id = 21
+		case *tls.UtlsExtendedMasterSecretExtension:
+			id = 23
+		case *tls.FakeCertCompressionAlgsExtension:
+			id = 27
+		case *tls.FakeRecordSizeLimitExtension:
+			id = 28
+		case *tls.SessionTicketExtension:
+			id = 35
+		case *tls.SupportedVersionsExtension:
+			id = 43
+			// the ClientHello itself advertises TLS 1.2 when TLS 1.3 is supported
+			version = tls.VersionTLS12
+		case *tls.CookieExtension:
+			id = 44
+		case *tls.PSKKeyExchangeModesExtension:
+			id = 45
+		case *tls.KeyShareExtension:
+			id = 51
+		case *tls.NPNExtension:
+			id = 13172
+		case *tls.FakeChannelIDExtension:
+			id = 30032
+		case *tls.RenegotiationInfoExtension:
+			id = 65281
+		case *tls.GenericExtension:
+			id = e.Id
+		default:
+			continue
+		}
+		if !isGREASE(id) {
+			extensions = append(extensions, strconv.Itoa(int(id)))
+		}
+	}
+
+	var ciphers []string
+	for _, c := range spec.CipherSuites {
+		if !isGREASE(c) {
+			ciphers = append(ciphers, strconv.Itoa(int(c)))
+		}
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(version)),
+		strings.Join(ciphers, "-"),
+		strings.Join(extensions, "-"),
+		strings.Join(curves, "-"),
+		strings.Join(points, "-"),
+	}, ",")
+}
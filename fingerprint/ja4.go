@@ -0,0 +1,137 @@
+package fingerprint
+
+import (
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"strconv"
+	"strings"
+)
+
+// ja4Versions maps the TLS version of a JA4 fingerprint to its protocol version.
+var ja4Versions = map[string]uint16{
+	"13": tls.VersionTLS13,
+	"12": tls.VersionTLS12,
+	"11": tls.VersionTLS11,
+	"10": tls.VersionTLS10,
+}
+
+// ParseJA4 builds the ClientHelloSpec of a raw JA4 fingerprint (JA4_r or JA4_ro), e.g.
+// t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601.
+//
+// The hashed form of JA4 can't be converted, because the hashes can't be reversed.
+// Cipher suites and extensions are sent in the order of the fingerprint, which is sorted unless it's a JA4_ro fingerprint.
+// JA4 doesn't include the elliptic curves, so the supported_groups extension always contains X25519, P-256 and P-384.
+// options may be nil.
+func ParseJA4(ja4 string, options *Options) (*tls.ClientHelloSpec, error) {
+	sections := strings.Split(strings.TrimSpace(ja4), "_")
+	if len(sections) < 3 || len(sections) > 4 || len(sections[0]) != 10 {
+		return nil, fmt.Errorf("%w: JA4 must have 3 or 4 sections", InvalidFingerprintError)
+	}
+
+	a := sections[0]
+	if a[0] != 't' {
+		return nil, fmt.Errorf("%w: only JA4 fingerprints of TCP connections are supported", InvalidFingerprintError)
+	}
+	version, ok := ja4Versions[a[1:3]]
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid TLS version %q", InvalidFingerprintError, a[1:3])
+	}
+	cipherCount, err1 := strconv.Atoi(a[4:6])
+	extensionCount, err2 := strconv.Atoi(a[6:8])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("%w: invalid counts %q", InvalidFingerprintError, a[4:8])
+	}
+
+	// hashed sections are 12 hex characters without separators
+	if len(sections[1]) == 12 && cipherCount > 1 && !strings.Contains(sections[1], ",") {
+		return nil, fmt.Errorf("%w: hashed JA4 fingerprints can't be converted, use JA4_r instead", InvalidFingerprintError)
+	}
+
+	s := &spec{
+		// the legacy version of the ClientHello, TLS 1.3 is negotiated with the supported_versions extension
+		version: tls.VersionTLS12,
+		curves:  []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	}
+	if version < tls.VersionTLS12 {
+		s.version = version
+	}
+
+	if s.ciphers, err1 = parseJA4List(sections[1]); err1 != nil {
+		return nil, err1
+	}
+	if s.extensions, err1 = parseJA4List(sections[2]); err1 != nil {
+		return nil, err1
+	}
+	if len(sections) == 4 {
+		algorithms, err := parseJA4List(sections[3])
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range algorithms {
+			s.signatureAlgorithms = append(s.signatureAlgorithms, tls.SignatureScheme(a))
+		}
+	}
+
+	// JA4_r excludes SNI and ALPN from the extensions, but counts them
+	sni, alpn := a[3] == 'd', a[8:10] != "00"
+	if !containsUint16(s.extensions, 0) && sni {
+		s.extensions = append([]uint16{0}, s.extensions...)
+	}
+	if !containsUint16(s.extensions, 16) && alpn {
+		s.extensions = append(s.extensions, 16)
+	}
+
+	if len(s.ciphers) != cipherCount || len(s.extensions) != extensionCount {
+		return nil, fmt.Errorf("%w: expected %d cipher suites and %d extensions, got %d and %d", InvalidFingerprintError, cipherCount, extensionCount, len(s.ciphers), len(s.extensions))
+	}
+
+	if alpn && (options == nil || len(options.ALPN) == 0) {
+		o := Options{}
+		if options != nil {
+			o = *options
+		}
+		o.ALPN = ja4ALPN(a[8:10])
+		options = &o
+	}
+
+	return s.build(options)
+}
+
+// ja4ALPN returns the ALPN protocols that match the first and last character of the first protocol in a JA4 fingerprint.
+func ja4ALPN(alpn string) []string {
+	switch alpn {
+	case "h2":
+		return []string{"h2", "http/1.1"}
+	case "h1":
+		return []string{"http/1.1"}
+	default:
+		return []string{alpn}
+	}
+}
+
+// parseJA4List parses a comma separated list of hexadecimal numbers.
+func parseJA4List(section string) ([]uint16, error) {
+	if section == "" {
+		return nil, nil
+	}
+
+	var values []uint16
+	for _, v := range strings.Split(section, ",") {
+		n, err := strconv.ParseUint(v, 16, 16)
+		if err != nil || len(v) != 4 {
+			return nil, fmt.Errorf("%w: invalid value %q", InvalidFingerprintError, v)
+		}
+		values = append(values, uint16(n))
+	}
+
+	return values, nil
+}
+
+func containsUint16(values []uint16, v uint16) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/Sleeyax/urlValues v1.0.0
 	github.com/imdario/mergo v0.3.13
+	golang.org/x/net v0.0.0-20210610132358-84b48f89b13b
 )
@@ -0,0 +1,62 @@
+package gotcha
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HeaderOrderKey is a magic key in Options.Headers that specifies the order in which headers should be sent.
+// It's the same key the fhttp package uses. The magic key itself is never sent.
+//
+// Prefer Options.HeaderOrder, which takes precedence.
+const HeaderOrderKey = "Header-Order:"
+
+// PHeaderOrderKey is a magic key in Options.Headers that specifies the order in which HTTP/2 pseudo headers should be sent.
+//
+// Prefer Options.PseudoHeaderOrder, which takes precedence.
+const PHeaderOrderKey = "PHeader-Order:"
+
+// isMagicHeaderKey reports whether key is one of the magic keys that should never be sent.
+func isMagicHeaderKey(key string) bool {
+	return key == HeaderOrderKey || key == PHeaderOrderKey
+}
+
+// orderHeaderKeys returns the keys of h in the order they should be written, excluding the magic keys.
+// Keys listed in order come first (in that order), all other keys follow in lexicographic order.
+func orderHeaderKeys(h http.Header, order []string) []string {
+	keys := make([]string, 0, len(h))
+	seen := make(map[string]bool, len(h))
+
+	for _, o := range order {
+		for key := range h {
+			if !seen[key] && !isMagicHeaderKey(key) && strings.EqualFold(key, o) {
+				keys = append(keys, key)
+				seen[key] = true
+			}
+		}
+	}
+
+	var rest []string
+	for key := range h {
+		if !seen[key] && !isMagicHeaderKey(key) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(keys, rest...)
+}
+
+// headerKey returns the key of h that matches name case-insensitively, or an empty string if there's none.
+func headerKey(h http.Header, name string) string {
+	if _, ok := h[name]; ok {
+		return name
+	}
+	for key := range h {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package gotcha
+
+import (
+	"context"
+	"github.com/sleeyax/gotcha/internal/utils"
+	"io"
+	"net/http"
+	"time"
+)
+
+// hedgeResult is the outcome of a single hedged attempt.
+type hedgeResult struct {
+	res   *Response
+	err   error
+	index int
+}
+
+// cancelOnClose cancels the context of the winning attempt once its body has been closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// shouldHedge reports whether the request described by o can be hedged.
+func shouldHedge(o *Options) bool {
+	h := o.Hedge
+	if h == nil || h.Limit <= 0 || o.Body != nil {
+		return false
+	}
+
+	methods := h.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	return utils.StringArrayContains(methods, o.Method)
+}
+
+// hedge sends the request to the Adapter and sends another attempt each time HedgeOptions.Delay passes without a response,
+// until HedgeOptions.Limit is reached.
+// The first successful Response is returned, all other attempts are canceled and their responses are closed.
+func (c *Client) hedge(o *Options) (*Response, error) {
+	parent := o.RequestContext
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	attempts := o.Hedge.Limit + 1
+	results := make(chan hedgeResult, attempts)
+	cancels := make([]context.CancelFunc, 0, attempts)
+
+	attempt := func() {
+		ctx, cancel := context.WithCancel(parent)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+
+		ao := *o
+		ao.Headers = o.Headers.Clone()
+		ao.RequestContext = ctx
+
+		go func() {
+			res, err := ao.Adapter.DoRequest(&ao)
+			results <- hedgeResult{res, err, index}
+		}()
+	}
+
+	timer := time.NewTimer(o.Hedge.Delay)
+	defer timer.Stop()
+
+	attempt()
+	pending := 1
+
+	for {
+		select {
+		case r := <-results:
+			pending--
+
+			if r.err != nil {
+				cancels[r.index]()
+				if pending == 0 && len(cancels) == attempts {
+					return nil, r.err
+				}
+				if pending == 0 {
+					attempt()
+					pending++
+				}
+				continue
+			}
+
+			// cancel the losers and release whatever they still manage to return
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel()
+				}
+			}
+			go func(n int) {
+				for ; n > 0; n-- {
+					if l := <-results; l.err == nil && l.res.Body != nil {
+						l.res.Body.Close()
+					}
+				}
+			}(pending)
+
+			// the winner's context must outlive this function, so it's only canceled once its body is closed
+			if r.res.Body != nil {
+				r.res.Body = &cancelOnClose{r.res.Body, cancels[r.index]}
+			} else {
+				cancels[r.index]()
+			}
+
+			return r.res, nil
+		case <-timer.C:
+			if len(cancels) < attempts {
+				attempt()
+				pending++
+				timer.Reset(o.Hedge.Delay)
+			}
+		}
+	}
+}
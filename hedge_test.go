@@ -0,0 +1,91 @@
+package gotcha
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoRequest_Hedge(t *testing.T) {
+	var requests int32
+	canceled := make(chan struct{}, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt hangs until it's canceled
+		if atomic.AddInt32(&requests, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+				canceled <- struct{}{}
+			case <-time.After(time.Second * 5):
+			}
+			return
+		}
+		w.Write([]byte("hedged"))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(&Options{
+		Hedge: &HedgeOptions{
+			Delay: time.Millisecond * 50,
+			Limit: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	body, err := res.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "hedged" {
+		t.Fatalf(tests.MismatchFormat, "body", "hedged", body)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("first attempt wasn't canceled")
+	}
+
+	if r := atomic.LoadInt32(&requests); r != 2 {
+		t.Fatalf(tests.MismatchFormat, "request count", 2, r)
+	}
+}
+
+func TestClient_DoRequest_Hedge_Fast(t *testing.T) {
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(&Options{Hedge: NewDefaultHedgeOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Close()
+
+	// give a (wrongfully) hedged attempt the chance to arrive
+	time.Sleep(NewDefaultHedgeOptions().Delay * 2)
+
+	if r := atomic.LoadInt32(&requests); r != 1 {
+		t.Fatalf(tests.MismatchFormat, "request count", 1, r)
+	}
+}
@@ -17,7 +17,7 @@ type Hooks struct {
 	Init []InitHook
 
 	// Called with normalized Options.
-	// Gotcha will make no further changes to the Options before it is sent to the Adapter.
+	// Gotcha will make no further changes to the Options before it is sent to the Adapter, apart from applying Options.Auth.
 	//
 	// Note that changing Options.Json or Options.Form has no effect on the request,
 	// you should change Options.Body instead and (if needed) update the Options.headers accordingly.
@@ -0,0 +1,786 @@
+package gotcha
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP message signature algorithms (RFC 9421, section 3.3).
+const (
+	HTTPSigHMACSHA256      = "hmac-sha256"
+	HTTPSigEd25519         = "ed25519"
+	HTTPSigECDSAP256SHA256 = "ecdsa-p256-sha256"
+	HTTPSigRSAPSSSHA512    = "rsa-pss-sha512"
+)
+
+// Content-Digest algorithms (RFC 9530).
+const (
+	ContentDigestSHA256 = "sha-256"
+	ContentDigestSHA512 = "sha-512"
+)
+
+var InvalidHTTPSignatureError = errors.New("invalid http signature")
+
+// Components covered by an HTTPSigner when none are specified.
+var defaultHTTPSigComponents = []string{"@method", "@target-uri"}
+
+// HTTPSigner signs requests using HTTP Message Signatures (RFC 9421).
+//
+// The Signature-Input and Signature headers are set right before the request is sent,
+// so every retry and redirect to the same host is signed again.
+type HTTPSigner struct {
+	// Identifier of the key, sent as the keyid parameter.
+	KeyID string
+
+	// Key to sign with: a []byte shared secret (hmac-sha256), ed25519.PrivateKey (ed25519),
+	// *ecdsa.PrivateKey on curve P-256 (ecdsa-p256-sha256) or *rsa.PrivateKey (rsa-pss-sha512).
+	Key crypto.PrivateKey
+
+	// Signature algorithm.
+	// When empty, it's derived from the Key and the alg parameter is omitted.
+	Algorithm string
+
+	// Label of the signature.
+	//
+	// Defaults to 'sig1'.
+	Label string
+
+	// Components to sign, in order.
+	// These are derived components such as @method, @target-uri, @authority, @scheme, @path, @query and @request-target,
+	// or names of request headers. Signing fails when a header is missing.
+	//
+	// Defaults to @method and @target-uri.
+	Components []string
+
+	// Algorithm of the Content-Digest header to add, either ContentDigestSHA256 or ContentDigestSHA512.
+	// The Body is read into memory to compute it, and the content-digest component is always signed.
+	Digest string
+
+	// Application specific tag, sent as the tag parameter.
+	Tag string
+
+	// How long the signature is valid, sent as the expires parameter when not zero.
+	Expires time.Duration
+
+	// Only used for testing.
+	now func() time.Time
+}
+
+func (s *HTTPSigner) Authenticate(options *Options) error {
+	components := s.Components
+	if len(components) == 0 {
+		components = defaultHTTPSigComponents
+	}
+
+	if s.Digest != "" {
+		body, err := options.ReplayableBody()
+		if err != nil {
+			return err
+		}
+		digest, err := ContentDigest(s.Digest, body)
+		if err != nil {
+			return err
+		}
+		options.Headers.Set("Content-Digest", digest)
+
+		if !containsFold(components, "content-digest") {
+			components = append(append([]string{}, components...), "content-digest")
+		}
+	}
+
+	algorithm := s.Algorithm
+	if algorithm == "" {
+		algorithm = httpSigAlgorithm(s.Key)
+	}
+
+	created := time.Now()
+	if s.now != nil {
+		created = s.now()
+	}
+
+	sig := &httpSignature{created: created.Unix(), keyID: s.KeyID, alg: s.Algorithm, tag: s.Tag}
+	if s.Expires != 0 {
+		sig.expires = created.Add(s.Expires).Unix()
+	}
+	for _, name := range components {
+		sig.components = append(sig.components, httpSigComponent{name: strings.ToLower(name)})
+	}
+
+	base, err := sig.base(&httpSigMessage{method: options.Method, url: options.FullUrl, header: options.Headers})
+	if err != nil {
+		return err
+	}
+
+	signature, err := httpSigSign(algorithm, s.Key, []byte(base))
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	options.Headers.Set("Signature-Input", label+"="+sig.params())
+	options.Headers.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+
+	return nil
+}
+
+// HTTPSignatureVerifier verifies HTTP Message Signatures (RFC 9421) of responses.
+type HTTPSignatureVerifier struct {
+	// Key to verify with: a []byte shared secret (hmac-sha256), ed25519.PublicKey (ed25519),
+	// *ecdsa.PublicKey on curve P-256 (ecdsa-p256-sha256) or *rsa.PublicKey (rsa-pss-sha512).
+	Key crypto.PublicKey
+
+	// Expected signature algorithm.
+	// When empty, it's derived from the Key. A signature with a different alg parameter is rejected.
+	Algorithm string
+
+	// Expected keyid parameter, if any.
+	KeyID string
+
+	// Label of the signature to verify.
+	// When empty, the first signature is verified.
+	Label string
+
+	// Components that must be covered by the signature, e.g. @status or content-digest.
+	// When content-digest is covered, the Content-Digest header is verified against the Body as well.
+	Required []string
+
+	// Maximum age of the signature based on its created parameter, unlimited when zero.
+	MaxAge time.Duration
+
+	// Only used for testing.
+	now func() time.Time
+}
+
+// Verify verifies the signature of given response.
+// Components with the req parameter are derived from the request that was sent.
+//
+// The response Body is read into memory when the content-digest component is covered.
+func (v *HTTPSignatureVerifier) Verify(response *Response) error {
+	inputs, err := parseHTTPSigDictionary(response.Header.Get("Signature-Input"))
+	if err != nil {
+		return err
+	}
+	signatures, err := parseHTTPSigDictionary(response.Header.Get("Signature"))
+	if err != nil {
+		return err
+	}
+
+	label := v.Label
+	if label == "" {
+		if len(inputs) == 0 {
+			return fmt.Errorf("%w: missing Signature-Input header", InvalidHTTPSignatureError)
+		}
+		label = inputs[0].key
+	}
+
+	input, ok := findHTTPSigMember(inputs, label)
+	if !ok {
+		return fmt.Errorf("%w: no signature input with label '%s'", InvalidHTTPSignatureError, label)
+	}
+	encoded, ok := findHTTPSigMember(signatures, label)
+	if !ok || !strings.HasPrefix(encoded, ":") || !strings.HasSuffix(encoded, ":") || len(encoded) < 2 {
+		return fmt.Errorf("%w: no signature with label '%s'", InvalidHTTPSignatureError, label)
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded[1 : len(encoded)-1])
+	if err != nil {
+		return fmt.Errorf("%w: %v", InvalidHTTPSignatureError, err)
+	}
+
+	sig, err := parseHTTPSignature(input)
+	if err != nil {
+		return err
+	}
+
+	algorithm := v.Algorithm
+	if algorithm == "" {
+		algorithm = httpSigAlgorithm(v.Key)
+	}
+	if sig.alg != "" && sig.alg != algorithm {
+		return fmt.Errorf("%w: unexpected algorithm '%s'", InvalidHTTPSignatureError, sig.alg)
+	}
+	if v.KeyID != "" && sig.keyID != v.KeyID {
+		return fmt.Errorf("%w: unexpected key id '%s'", InvalidHTTPSignatureError, sig.keyID)
+	}
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	if sig.expires != 0 && now.Unix() > sig.expires {
+		return fmt.Errorf("%w: signature expired", InvalidHTTPSignatureError)
+	}
+	if v.MaxAge != 0 && now.Sub(time.Unix(sig.created, 0)) > v.MaxAge {
+		return fmt.Errorf("%w: signature is too old", InvalidHTTPSignatureError)
+	}
+
+	var covered []string
+	for _, c := range sig.components {
+		if !c.req {
+			covered = append(covered, c.name)
+		}
+	}
+	for _, name := range v.Required {
+		if !containsFold(covered, name) {
+			return fmt.Errorf("%w: component '%s' is not covered", InvalidHTTPSignatureError, name)
+		}
+	}
+
+	msg := &httpSigMessage{header: response.Header, status: response.StatusCode}
+	if req := response.Request; req != nil {
+		msg.request = &httpSigMessage{method: req.Method, url: req.URL, header: req.Header}
+	}
+
+	base, err := sig.base(msg)
+	if err != nil {
+		return err
+	}
+	if err = httpSigVerify(algorithm, v.Key, []byte(base), signature); err != nil {
+		return err
+	}
+
+	if containsFold(covered, "content-digest") {
+		return verifyContentDigest(response)
+	}
+
+	return nil
+}
+
+// ContentDigest returns the value of the Content-Digest header (RFC 9530) of given body.
+func ContentDigest(algorithm string, body []byte) (string, error) {
+	var sum []byte
+	switch algorithm {
+	case ContentDigestSHA256:
+		s := sha256.Sum256(body)
+		sum = s[:]
+	case ContentDigestSHA512:
+		s := sha512.Sum512(body)
+		sum = s[:]
+	default:
+		return "", UnsupportedDigestAlgorithmError
+	}
+	return algorithm + "=:" + base64.StdEncoding.EncodeToString(sum) + ":", nil
+}
+
+// verifyContentDigest checks the supported digests in the Content-Digest header against the response Body.
+func verifyContentDigest(response *Response) error {
+	digests, err := parseHTTPSigDictionary(response.Header.Get("Content-Digest"))
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if response.Body != nil {
+		if body, err = io.ReadAll(response.Body); err != nil {
+			return err
+		}
+		response.Body.Close()
+		response.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	var verified bool
+	for _, digest := range digests {
+		expected, err := ContentDigest(digest.key, body)
+		if err != nil {
+			continue
+		}
+		if expected != digest.key+"="+digest.value {
+			return fmt.Errorf("%w: content digest mismatch", InvalidHTTPSignatureError)
+		}
+		verified = true
+	}
+	if !verified {
+		return fmt.Errorf("%w: no supported content digest", InvalidHTTPSignatureError)
+	}
+
+	return nil
+}
+
+// httpSigComponent is a component identifier.
+type httpSigComponent struct {
+	name string
+	// Derive the component from the request instead of the response.
+	req bool
+}
+
+func (c httpSigComponent) String() string {
+	if c.req {
+		return strconv.Quote(c.name) + ";req"
+	}
+	return strconv.Quote(c.name)
+}
+
+// httpSignature holds the covered components and parameters of a signature.
+type httpSignature struct {
+	components []httpSigComponent
+	created    int64
+	expires    int64
+	nonce      string
+	keyID      string
+	alg        string
+	tag        string
+
+	// Serialized parameters as received in the Signature-Input header, empty when signing.
+	// The signature base must contain them unchanged, including their order and unknown parameters.
+	input string
+}
+
+// params returns the serialized signature parameters, as used in the Signature-Input header.
+func (s *httpSignature) params() string {
+	var sb strings.Builder
+
+	sb.WriteByte('(')
+	for i, c := range s.components {
+		if i != 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(c.String())
+	}
+	sb.WriteByte(')')
+
+	if s.created != 0 {
+		sb.WriteString(";created=" + strconv.FormatInt(s.created, 10))
+	}
+	if s.expires != 0 {
+		sb.WriteString(";expires=" + strconv.FormatInt(s.expires, 10))
+	}
+	if s.nonce != "" {
+		sb.WriteString(";nonce=" + strconv.Quote(s.nonce))
+	}
+	if s.keyID != "" {
+		sb.WriteString(";keyid=" + strconv.Quote(s.keyID))
+	}
+	if s.alg != "" {
+		sb.WriteString(";alg=" + strconv.Quote(s.alg))
+	}
+	if s.tag != "" {
+		sb.WriteString(";tag=" + strconv.Quote(s.tag))
+	}
+
+	return sb.String()
+}
+
+// base returns the signature base of given message.
+func (s *httpSignature) base(msg *httpSigMessage) (string, error) {
+	var sb strings.Builder
+
+	for _, c := range s.components {
+		value, err := msg.value(c)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(c.String())
+		sb.WriteString(": ")
+		sb.WriteString(value)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(`"@signature-params": `)
+	if s.input != "" {
+		sb.WriteString(s.input)
+	} else {
+		sb.WriteString(s.params())
+	}
+
+	return sb.String(), nil
+}
+
+// httpSigMessage is a request or response that components are derived from.
+type httpSigMessage struct {
+	method string
+	url    *url.URL
+	header http.Header
+	// Status code of a response, zero for requests.
+	status int
+	// Request of a response.
+	request *httpSigMessage
+}
+
+// value returns the value of given component.
+func (m *httpSigMessage) value(c httpSigComponent) (string, error) {
+	if c.req {
+		if m.request == nil {
+			return "", fmt.Errorf("%w: component %s requires the request", InvalidHTTPSignatureError, c)
+		}
+		return m.request.value(httpSigComponent{name: c.name})
+	}
+
+	if strings.HasPrefix(c.name, "@") {
+		if c.name == "@status" {
+			if m.status == 0 {
+				return "", fmt.Errorf("%w: @status is only available for responses", InvalidHTTPSignatureError)
+			}
+			return strconv.Itoa(m.status), nil
+		}
+
+		if m.url == nil {
+			return "", fmt.Errorf("%w: component %s is only available for requests", InvalidHTTPSignatureError, c)
+		}
+
+		switch c.name {
+		case "@method":
+			return m.method, nil
+		case "@target-uri":
+			u := *m.url
+			u.User = nil
+			u.Fragment = ""
+			return u.String(), nil
+		case "@authority":
+			return httpSigAuthority(m.url), nil
+		case "@scheme":
+			return strings.ToLower(m.url.Scheme), nil
+		case "@path":
+			if p := m.url.EscapedPath(); p != "" {
+				return p, nil
+			}
+			return "/", nil
+		case "@query":
+			return "?" + m.url.RawQuery, nil
+		case "@request-target":
+			return m.url.RequestURI(), nil
+		}
+
+		return "", fmt.Errorf("%w: unsupported component %s", InvalidHTTPSignatureError, c)
+	}
+
+	// header keys aren't necessarily canonical, e.g. when they're specified in Options
+	var values []string
+	for key, vs := range m.header {
+		if strings.EqualFold(key, c.name) {
+			for _, v := range vs {
+				values = append(values, strings.TrimSpace(v))
+			}
+		}
+	}
+	if values == nil {
+		return "", fmt.Errorf("%w: header %s is missing", InvalidHTTPSignatureError, c)
+	}
+
+	return strings.Join(values, ", "), nil
+}
+
+// httpSigAuthority returns the lowercase host of u, without the default port of its scheme.
+func httpSigAuthority(u *url.URL) string {
+	host := strings.ToLower(u.Host)
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme == "http" && strings.HasSuffix(host, ":80")) || (scheme == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndexByte(host, ':')]
+	}
+	return host
+}
+
+// httpSigAlgorithm returns the algorithm that matches the type of given key.
+func httpSigAlgorithm(key interface{}) string {
+	switch key.(type) {
+	case []byte:
+		return HTTPSigHMACSHA256
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return HTTPSigEd25519
+	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+		return HTTPSigECDSAP256SHA256
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return HTTPSigRSAPSSSHA512
+	default:
+		return ""
+	}
+}
+
+// httpSigSign signs data with given algorithm and key.
+func httpSigSign(algorithm string, key crypto.PrivateKey, data []byte) ([]byte, error) {
+	switch algorithm {
+	case HTTPSigHMACSHA256:
+		if k, ok := key.([]byte); ok {
+			h := hmac.New(sha256.New, k)
+			h.Write(data)
+			return h.Sum(nil), nil
+		}
+	case HTTPSigEd25519:
+		if k, ok := key.(ed25519.PrivateKey); ok {
+			return ed25519.Sign(k, data), nil
+		}
+	case HTTPSigECDSAP256SHA256:
+		if k, ok := key.(*ecdsa.PrivateKey); ok && k.Curve == elliptic.P256() {
+			digest := sha256.Sum256(data)
+			r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			// the signature is the concatenation of r and s, both 32 bytes
+			signature := make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+			return signature, nil
+		}
+	case HTTPSigRSAPSSSHA512:
+		if k, ok := key.(*rsa.PrivateKey); ok {
+			digest := sha512.Sum512(data)
+			return rsa.SignPSS(rand.Reader, k, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+		}
+	default:
+		return nil, fmt.Errorf("httpsig: unsupported algorithm '%s'", algorithm)
+	}
+
+	return nil, fmt.Errorf("httpsig: invalid key of type %T for algorithm '%s'", key, algorithm)
+}
+
+// httpSigVerify verifies the signature of data with given algorithm and key.
+func httpSigVerify(algorithm string, key crypto.PublicKey, data []byte, signature []byte) error {
+	var valid bool
+
+	switch algorithm {
+	case HTTPSigHMACSHA256:
+		k, ok := key.([]byte)
+		if !ok {
+			break
+		}
+		h := hmac.New(sha256.New, k)
+		h.Write(data)
+		valid = hmac.Equal(h.Sum(nil), signature)
+	case HTTPSigEd25519:
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		valid = ed25519.Verify(k, data, signature)
+	case HTTPSigECDSAP256SHA256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != elliptic.P256() {
+			break
+		}
+		if len(signature) == 64 {
+			digest := sha256.Sum256(data)
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			valid = ecdsa.Verify(k, digest[:], r, s)
+		}
+	case HTTPSigRSAPSSSHA512:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		digest := sha512.Sum512(data)
+		valid = rsa.VerifyPSS(k, crypto.SHA512, digest[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	default:
+		return fmt.Errorf("httpsig: unsupported algorithm '%s'", algorithm)
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: signature mismatch", InvalidHTTPSignatureError)
+	}
+
+	return nil
+}
+
+// httpSigMember is a member of a structured field dictionary (RFC 8941), with its value unparsed.
+type httpSigMember struct {
+	key   string
+	value string
+}
+
+func findHTTPSigMember(members []httpSigMember, key string) (string, bool) {
+	for _, m := range members {
+		if m.key == key {
+			return m.value, true
+		}
+	}
+	return "", false
+}
+
+// parseHTTPSigDictionary splits a structured field dictionary into its members.
+// Commas within strings, byte sequences and inner lists are not treated as separators.
+func parseHTTPSigDictionary(s string) ([]httpSigMember, error) {
+	var members []httpSigMember
+
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return members, nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("%w: malformed dictionary", InvalidHTTPSignatureError)
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var quoted, binary bool
+		var depth int
+		i := 0
+	loop:
+		for ; i < len(s); i++ {
+			switch c := s[i]; {
+			case quoted:
+				if c == '\\' {
+					i++
+				} else if c == '"' {
+					quoted = false
+				}
+			case c == '"':
+				quoted = true
+			case c == ':':
+				binary = !binary
+			case binary:
+			case c == '(':
+				depth++
+			case c == ')':
+				depth--
+			case c == ',' && depth == 0:
+				break loop
+			}
+		}
+
+		members = append(members, httpSigMember{key: key, value: strings.TrimSpace(s[:i])})
+
+		if i < len(s) {
+			i++
+		}
+		s = s[i:]
+	}
+}
+
+// parseHTTPSignature parses a Signature-Input member into its components and parameters.
+// Unknown parameters are ignored, but remain part of the signature base.
+func parseHTTPSignature(s string) (*httpSignature, error) {
+	malformed := fmt.Errorf("%w: malformed signature input", InvalidHTTPSignatureError)
+
+	if !strings.HasPrefix(s, "(") {
+		return nil, malformed
+	}
+
+	sig := &httpSignature{input: s}
+	s = s[1:]
+
+	// covered components
+	for {
+		s = strings.TrimLeft(s, " ")
+		if strings.HasPrefix(s, ")") {
+			s = s[1:]
+			break
+		}
+		name, rest, ok := parseHTTPSigString(s)
+		if !ok {
+			return nil, malformed
+		}
+		c := httpSigComponent{name: name}
+		s = rest
+		for strings.HasPrefix(s, ";") {
+			end := strings.IndexAny(s[1:], "; )")
+			if end == -1 {
+				return nil, malformed
+			}
+			param := s[1 : end+1]
+			if param != "req" {
+				return nil, fmt.Errorf("%w: unsupported component parameter '%s'", InvalidHTTPSignatureError, param)
+			}
+			c.req = true
+			s = s[end+1:]
+		}
+		sig.components = append(sig.components, c)
+	}
+
+	// signature parameters
+	for strings.HasPrefix(s, ";") {
+		s = s[1:]
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			return nil, malformed
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			v, rest, ok := parseHTTPSigString(s)
+			if !ok {
+				return nil, malformed
+			}
+			value, s = v, rest
+		} else {
+			end := strings.IndexByte(s, ';')
+			if end == -1 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+
+		var err error
+		switch key {
+		case "created":
+			sig.created, err = strconv.ParseInt(value, 10, 64)
+		case "expires":
+			sig.expires, err = strconv.ParseInt(value, 10, 64)
+		case "nonce":
+			sig.nonce = value
+		case "keyid":
+			sig.keyID = value
+		case "alg":
+			sig.alg = value
+		case "tag":
+			sig.tag = value
+		}
+		if err != nil {
+			return nil, malformed
+		}
+	}
+
+	if s != "" {
+		return nil, malformed
+	}
+
+	return sig, nil
+}
+
+// parseHTTPSigString parses the structured field string at the start of s and returns the remainder.
+func parseHTTPSigString(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, false
+	}
+
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 == len(s) {
+				return "", s, false
+			}
+			i++
+			sb.WriteByte(s[i])
+		case '"':
+			return sb.String(), s[i+1:], true
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+
+	return "", s, false
+}
+
+// containsFold reports whether values contains s, ignoring case.
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package gotcha
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	urlPkg "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentDigest(t *testing.T) {
+	// examples from RFC 9530, appendix B
+	for algorithm, expected := range map[string]string{
+		ContentDigestSHA256: "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:",
+		ContentDigestSHA512: "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:",
+	} {
+		digest, err := ContentDigest(algorithm, []byte(`{"hello": "world"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != expected {
+			t.Errorf(tests.MismatchFormat, algorithm, expected, digest)
+		}
+	}
+}
+
+func TestHTTPSigner_RFC9421(t *testing.T) {
+	// test vectors from RFC 9421, appendix B.2
+	hmacKey, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	ed25519Seed, _ := base64.StdEncoding.DecodeString("n4Ni+HpISpVObnQMW0wOhCKROaIKqKtW/2ZYb2p9KcU=")
+
+	testCases := []struct {
+		name      string
+		signer    *HTTPSigner
+		input     string
+		signature string
+	}{
+		{
+			name: "hmac-sha256",
+			signer: &HTTPSigner{
+				KeyID:      "test-shared-secret",
+				Key:        hmacKey,
+				Label:      "sig-b25",
+				Components: []string{"date", "@authority", "content-type"},
+			},
+			input:     `sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+			signature: "sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:",
+		},
+		{
+			name: "ed25519",
+			signer: &HTTPSigner{
+				KeyID:      "test-key-ed25519",
+				Key:        ed25519.NewKeyFromSeed(ed25519Seed),
+				Label:      "sig-b26",
+				Components: []string{"date", "@method", "@path", "@authority", "content-type", "content-length"},
+			},
+			input:     `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`,
+			signature: "sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:",
+		},
+	}
+
+	u, _ := urlPkg.Parse("https://example.com/foo?param=Value&Pet=dog")
+
+	for _, tc := range testCases {
+		tc.signer.now = func() time.Time {
+			return time.Unix(1618884473, 0)
+		}
+
+		o := &Options{Method: http.MethodPost, FullUrl: u, Headers: http.Header{
+			"Date":           {"Tue, 20 Apr 2021 02:07:55 GMT"},
+			"Content-Type":   {"application/json"},
+			"Content-Digest": {"sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"},
+			"Content-Length": {"18"},
+		}}
+
+		if err := tc.signer.Authenticate(o); err != nil {
+			t.Fatal(err)
+		}
+
+		if input := o.Headers.Get("Signature-Input"); input != tc.input {
+			t.Errorf(tests.MismatchFormat, tc.name+" signature input", tc.input, input)
+		}
+		if signature := o.Headers.Get("Signature"); signature != tc.signature {
+			t.Errorf(tests.MismatchFormat, tc.name+" signature", tc.signature, signature)
+		}
+	}
+}
+
+func TestHTTPSignatureVerifier(t *testing.T) {
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := map[string][2]interface{}{
+		HTTPSigHMACSHA256:      {[]byte("secret"), []byte("secret")},
+		HTTPSigEd25519:         {ed25519Key, ed25519Key.Public()},
+		HTTPSigECDSAP256SHA256: {ecdsaKey, &ecdsaKey.PublicKey},
+		HTTPSigRSAPSSSHA512:    {rsaKey, &rsaKey.PublicKey},
+	}
+
+	for algorithm, key := range keys {
+		// the server verifies the request signature and signs its response
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			r.URL.Scheme, r.URL.Host = "http", r.Host
+			req := &httpSigMessage{method: r.Method, url: r.URL, header: r.Header}
+
+			input, _ := parseHTTPSigDictionary(r.Header.Get("Signature-Input"))
+			signature, _ := parseHTTPSigDictionary(r.Header.Get("Signature"))
+			sig, err := parseHTTPSignature(input[0].value)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			base, err := sig.base(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			raw, _ := base64.StdEncoding.DecodeString(strings.Trim(signature[0].value, ":"))
+			if err = httpSigVerify(algorithm, key[1], []byte(base), raw); err != nil {
+				t.Errorf("%s: request: %v", algorithm, err)
+			}
+			if digest, _ := ContentDigest(ContentDigestSHA256, body); r.Header.Get("Content-Digest") != digest {
+				t.Errorf(tests.MismatchFormat, algorithm+" content digest", digest, r.Header.Get("Content-Digest"))
+			}
+
+			res := &httpSigMessage{header: w.Header(), status: http.StatusOK, request: req}
+			digest, _ := ContentDigest(ContentDigestSHA512, body)
+			w.Header().Set("Content-Digest", digest)
+			sig = &httpSignature{
+				components: []httpSigComponent{{name: "@status"}, {name: "content-digest"}, {name: "@method", req: true}},
+				created:    time.Now().Unix(),
+				keyID:      "server",
+			}
+			base, _ = sig.base(res)
+			raw, _ = httpSigSign(algorithm, key[0], []byte(base))
+			w.Header().Set("Signature-Input", "res="+sig.params())
+			w.Header().Set("Signature", "res=:"+base64.StdEncoding.EncodeToString(raw)+":")
+			w.Write(body)
+		}))
+
+		res, err := Post(ts.URL+"/path?a=b", &Options{
+			Body: io.NopCloser(strings.NewReader("hello world")),
+			Auth: &HTTPSigner{
+				KeyID:      "client",
+				Key:        key[0],
+				Components: []string{"@method", "@authority", "@path", "@query"},
+				Digest:     ContentDigestSHA256,
+			},
+		})
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifier := &HTTPSignatureVerifier{Key: key[1], KeyID: "server", Required: []string{"@status", "content-digest"}, MaxAge: time.Minute}
+		if err = verifier.Verify(res); err != nil {
+			t.Fatalf("%s: response: %v", algorithm, err)
+		}
+		if body := mustText(t, res); body != "hello world" {
+			t.Fatalf(tests.MismatchFormat, algorithm+" body", "hello world", body)
+		}
+
+		// tampering with a covered component invalidates the signature
+		res.StatusCode = http.StatusCreated
+		if err = verifier.Verify(res); err == nil {
+			t.Fatalf("%s: expected tampered response to be rejected", algorithm)
+		}
+	}
+}
+
+func TestHTTPSignatureVerifier_ParamOrder(t *testing.T) {
+	key := []byte("secret")
+	res := &Response{Response: &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/plain"}}}}
+
+	// parameters in a different order than HTTPSigner writes them, with an unknown one
+	input := `("@status" "content-type");tag="app";alg="hmac-sha256";keyid="server";foo=bar;expires=4102444800;created=1618884473`
+	base := "\"@status\": 200\n\"content-type\": text/plain\n\"@signature-params\": " + input
+	raw, err := httpSigSign(HTTPSigHMACSHA256, key, []byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Header.Set("Signature-Input", "sig="+input)
+	res.Header.Set("Signature", "sig=:"+base64.StdEncoding.EncodeToString(raw)+":")
+
+	verifier := &HTTPSignatureVerifier{Key: key, KeyID: "server", Required: []string{"@status", "content-type"}}
+	if err = verifier.Verify(res); err != nil {
+		t.Fatal(err)
+	}
+
+	// changing any parameter invalidates the signature
+	res.Header.Set("Signature-Input", "sig="+strings.Replace(input, "app", "other", 1))
+	if err = verifier.Verify(res); err == nil {
+		t.Fatal("expected tampered parameters to be rejected")
+	}
+}
@@ -0,0 +1,322 @@
+package echo
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var InvalidClientHelloError = errors.New("invalid client hello")
+
+const (
+	recordTypeHandshake      = 22
+	handshakeTypeClientHello = 1
+
+	extensionServerName          = 0
+	extensionSupportedGroups     = 10
+	extensionECPointFormats      = 11
+	extensionSignatureAlgorithms = 13
+	extensionALPN                = 16
+	extensionSupportedVersions   = 43
+)
+
+// ja4Versions maps TLS versions to their JA4 representation.
+var ja4Versions = map[uint16]string{
+	0x0304: "13",
+	0x0303: "12",
+	0x0302: "11",
+	0x0301: "10",
+	0x0300: "s3",
+}
+
+// clientHello contains the fields of a TLS ClientHello that make up its fingerprints.
+type clientHello struct {
+	version             uint16
+	ciphers             []uint16
+	extensions          []uint16
+	curves              []uint16
+	points              []uint8
+	signatureAlgorithms []uint16
+	supportedVersions   []uint16
+	alpn                []string
+	serverName          string
+}
+
+// readClientHello reads the TLS records that contain the ClientHello from r.
+// It returns the raw records, so they can be replayed to the TLS server, together with the parsed ClientHello.
+func readClientHello(r io.Reader) ([]byte, *clientHello, error) {
+	var raw, msg []byte
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, nil, err
+		}
+		if header[0] != recordTypeHandshake {
+			return nil, nil, fmt.Errorf("%w: unexpected record type %d", InvalidClientHelloError, header[0])
+		}
+		fragment := make([]byte, int(header[3])<<8|int(header[4]))
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, nil, err
+		}
+		raw = append(append(raw, header...), fragment...)
+		msg = append(msg, fragment...)
+
+		if len(msg) < 4 {
+			continue
+		}
+		if msg[0] != handshakeTypeClientHello {
+			return nil, nil, fmt.Errorf("%w: unexpected handshake type %d", InvalidClientHelloError, msg[0])
+		}
+		if n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]); len(msg) >= 4+n {
+			hello, err := parseClientHello(msg[4 : 4+n])
+			return raw, hello, err
+		}
+	}
+}
+
+// parseClientHello parses the body of a ClientHello handshake message.
+func parseClientHello(b []byte) (*clientHello, error) {
+	hello := &clientHello{}
+
+	r := &reader{b: b}
+	hello.version = r.uint16()
+	r.bytes(32)             // random
+	r.bytes(int(r.uint8())) // session ID
+	ciphers := r.vector16()
+	for !ciphers.empty() {
+		hello.ciphers = append(hello.ciphers, ciphers.uint16())
+	}
+	r.bytes(int(r.uint8())) // compression methods
+
+	extensions := r.vector16()
+	for !extensions.empty() {
+		typ := extensions.uint16()
+		// malformed extension contents are ignored
+		data := &reader{b: extensions.bytes(int(extensions.uint16()))}
+		hello.extensions = append(hello.extensions, typ)
+
+		switch typ {
+		case extensionServerName:
+			names := data.vector16()
+			for !names.empty() {
+				nameType, name := names.uint8(), names.vector16()
+				if nameType == 0 {
+					hello.serverName = string(name.b)
+				}
+			}
+		case extensionSupportedGroups:
+			curves := data.vector16()
+			for !curves.empty() {
+				hello.curves = append(hello.curves, curves.uint16())
+			}
+		case extensionECPointFormats:
+			hello.points = append([]uint8{}, data.vector8().b...)
+		case extensionSignatureAlgorithms:
+			algorithms := data.vector16()
+			for !algorithms.empty() {
+				hello.signatureAlgorithms = append(hello.signatureAlgorithms, algorithms.uint16())
+			}
+		case extensionALPN:
+			protocols := data.vector16()
+			for !protocols.empty() {
+				hello.alpn = append(hello.alpn, string(protocols.vector8().b))
+			}
+		case extensionSupportedVersions:
+			versions := data.vector8()
+			for !versions.empty() {
+				hello.supportedVersions = append(hello.supportedVersions, versions.uint16())
+			}
+		}
+	}
+
+	if r.err {
+		return nil, InvalidClientHelloError
+	}
+
+	return hello, nil
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello, see https://github.com/salesforce/ja3.
+func (h *clientHello) JA3() string {
+	points := make([]uint16, len(h.points))
+	for i, p := range h.points {
+		points[i] = uint16(p)
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinUint16(withoutGREASE(h.ciphers), "-", "%d"),
+		joinUint16(withoutGREASE(h.extensions), "-", "%d"),
+		joinUint16(withoutGREASE(h.curves), "-", "%d"),
+		joinUint16(points, "-", "%d"),
+	}, ",")
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello and its raw form JA4_r, see https://github.com/FoxIO-LLC/ja4.
+func (h *clientHello) JA4() (string, string) {
+	version := h.version
+	for _, v := range withoutGREASE(h.supportedVersions) {
+		if v > version {
+			version = v
+		}
+	}
+	v, ok := ja4Versions[version]
+	if !ok {
+		v = "00"
+	}
+
+	sni := "i"
+	if containsUint16(h.extensions, extensionServerName) {
+		sni = "d"
+	}
+
+	alpn := "00"
+	if len(h.alpn) != 0 && h.alpn[0] != "" {
+		first := h.alpn[0]
+		if isAlphanumeric(first[0]) && isAlphanumeric(first[len(first)-1]) {
+			alpn = first[:1] + first[len(first)-1:]
+		} else {
+			x := hex.EncodeToString([]byte(first))
+			alpn = x[:1] + x[len(x)-1:]
+		}
+	}
+
+	ciphers := withoutGREASE(h.ciphers)
+	extensions := withoutGREASE(h.extensions)
+	a := fmt.Sprintf("t%s%s%02d%02d%s", v, sni, minInt(len(ciphers), 99), minInt(len(extensions), 99), alpn)
+
+	var sorted []uint16
+	for _, e := range extensions {
+		if e != extensionServerName && e != extensionALPN {
+			sorted = append(sorted, e)
+		}
+	}
+	b := joinUint16(sortUint16(ciphers), ",", "%04x")
+	c := joinUint16(sortUint16(sorted), ",", "%04x")
+	if len(h.signatureAlgorithms) != 0 {
+		c += "_" + joinUint16(h.signatureAlgorithms, ",", "%04x")
+	}
+
+	return a + "_" + ja4Hash(b) + "_" + ja4Hash(c), a + "_" + b + "_" + c
+}
+
+// ja4Hash returns the truncated SHA256 hash of a JA4 section.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func md5Hash(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// isGREASE reports whether v is a GREASE value, see RFC 8701.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	var filtered []uint16
+	for _, v := range values {
+		if !isGREASE(v) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+func sortUint16(values []uint16) []uint16 {
+	sorted := append([]uint16{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+func joinUint16(values []uint16, sep string, format string) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf(format, v)
+	}
+	return strings.Join(s, sep)
+}
+
+func containsUint16(values []uint16, v uint16) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// reader reads big endian values and length prefixed vectors from b.
+// Reading past the end of b sets err and consumes the rest of b.
+type reader struct {
+	b   []byte
+	err bool
+	// reader this reader was read from, which shares its errors
+	parent *reader
+}
+
+func (r *reader) empty() bool {
+	return len(r.b) == 0
+}
+
+func (r *reader) fail() {
+	for ; r != nil; r = r.parent {
+		r.err = true
+		r.b = nil
+	}
+}
+
+func (r *reader) bytes(n int) []byte {
+	if len(r.b) < n {
+		r.fail()
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return 0
+}
+
+func (r *reader) vector8() *reader {
+	return &reader{b: r.bytes(int(r.uint8())), parent: r}
+}
+
+func (r *reader) vector16() *reader {
+	return &reader{b: r.bytes(int(r.uint16())), parent: r}
+}
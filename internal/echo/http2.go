@@ -0,0 +1,311 @@
+package echo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var InvalidHTTP2PrefaceError = errors.New("invalid http2 preface")
+
+const (
+	http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameSettings     = 0x4
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// staticPseudoHeaders maps the indices of the HPACK static table to the pseudo headers they contain.
+// See https://httpwg.org/specs/rfc7541.html#static.table.definition.
+var staticPseudoHeaders = map[uint64]string{
+	1: ":authority",
+	2: ":method",
+	3: ":method",
+	4: ":path",
+	5: ":path",
+	6: ":scheme",
+	7: ":scheme",
+}
+
+// akamaiPseudoHeaders maps pseudo headers to their abbreviation in an Akamai fingerprint.
+var akamaiPseudoHeaders = map[string]string{
+	":method":    "m",
+	":authority": "a",
+	":scheme":    "s",
+	":path":      "p",
+}
+
+// readHTTP2Request reads the client preface and the frames up to the end of the headers of the first request from r.
+// It returns the Akamai fingerprint of the frames and the stream ID of the request.
+func readHTTP2Request(r io.Reader) (string, uint32, error) {
+	preface := make([]byte, len(http2ClientPreface))
+	if _, err := io.ReadFull(r, preface); err != nil {
+		return "", 0, err
+	}
+	if string(preface) != http2ClientPreface {
+		return "", 0, InvalidHTTP2PrefaceError
+	}
+
+	var settings, priorities, pseudoHeaders []string
+	var windowUpdate uint32
+	var gotSettings, gotWindowUpdate bool
+
+	for {
+		typ, flags, streamID, payload, err := readFrame(r)
+		if err != nil {
+			return "", 0, err
+		}
+
+		switch typ {
+		case frameSettings:
+			if gotSettings || flags&flagAck != 0 {
+				continue
+			}
+			gotSettings = true
+			for i := 0; i+6 <= len(payload); i += 6 {
+				id := uint16(payload[i])<<8 | uint16(payload[i+1])
+				settings = append(settings, fmt.Sprintf("%d:%d", id, uint32At(payload[i+2:])))
+			}
+		case frameWindowUpdate:
+			if streamID == 0 && !gotWindowUpdate && len(payload) == 4 {
+				gotWindowUpdate = true
+				windowUpdate = uint32At(payload) & (1<<31 - 1)
+			}
+		case framePriority:
+			if len(payload) == 5 {
+				priorities = append(priorities, akamaiPriority(streamID, payload))
+			}
+		case frameHeaders:
+			if flags&flagPadded != 0 && len(payload) != 0 {
+				padding := int(payload[0])
+				if padding >= len(payload) {
+					return "", 0, InvalidHTTP2PrefaceError
+				}
+				payload = payload[1 : len(payload)-padding]
+			}
+			if flags&flagPriority != 0 {
+				if len(payload) < 5 {
+					return "", 0, InvalidHTTP2PrefaceError
+				}
+				payload = payload[5:]
+			}
+			pseudoHeaders = decodePseudoHeaders(payload)
+
+			// the pseudo headers come first, so continuations can be skipped
+			for flags&flagEndHeaders == 0 {
+				if typ, flags, _, _, err = readFrame(r); err != nil {
+					return "", 0, err
+				}
+				if typ != frameContinuation {
+					return "", 0, InvalidHTTP2PrefaceError
+				}
+			}
+
+			if len(priorities) == 0 {
+				priorities = []string{"0"}
+			}
+			for i, header := range pseudoHeaders {
+				pseudoHeaders[i] = akamaiPseudoHeaders[header]
+			}
+
+			return strings.Join([]string{
+				strings.Join(settings, ";"),
+				strconv.FormatUint(uint64(windowUpdate), 10),
+				strings.Join(priorities, ","),
+				strings.Join(pseudoHeaders, ","),
+			}, "|"), streamID, nil
+		}
+	}
+}
+
+// readHTTP2Stream reads frames from rw up to the end of the headers of the next request and returns its stream ID.
+// SETTINGS and PING frames are acknowledged, other frames such as the DATA of request bodies are ignored.
+// It returns io.EOF when the client sends a GOAWAY frame.
+func readHTTP2Stream(rw io.ReadWriter) (uint32, error) {
+	for {
+		typ, flags, streamID, payload, err := readFrame(rw)
+		if err != nil {
+			return 0, err
+		}
+
+		switch typ {
+		case frameSettings:
+			if flags&flagAck == 0 {
+				if _, err = rw.Write(appendFrame(nil, frameSettings, flagAck, 0, nil)); err != nil {
+					return 0, err
+				}
+			}
+		case framePing:
+			if flags&flagAck == 0 {
+				if _, err = rw.Write(appendFrame(nil, framePing, flagAck, 0, payload)); err != nil {
+					return 0, err
+				}
+			}
+		case frameGoAway:
+			return 0, io.EOF
+		case frameHeaders:
+			for flags&flagEndHeaders == 0 {
+				if typ, flags, _, _, err = readFrame(rw); err != nil {
+					return 0, err
+				}
+				if typ != frameContinuation {
+					return 0, InvalidHTTP2PrefaceError
+				}
+			}
+			return streamID, nil
+		}
+	}
+}
+
+// writeHTTP2Settings writes the server preface, which consists of empty settings, and acknowledges the settings of the client.
+func writeHTTP2Settings(w io.Writer) error {
+	var b []byte
+	b = appendFrame(b, frameSettings, 0, 0, nil)
+	b = appendFrame(b, frameSettings, flagAck, 0, nil)
+
+	_, err := w.Write(b)
+	return err
+}
+
+// writeHTTP2Response writes a response with body to the stream streamID.
+func writeHTTP2Response(w io.Writer, streamID uint32, contentType string, body []byte) error {
+	// :status 200, followed by content-type and content-length literals without indexing
+	block := []byte{0x88}
+	block = appendLiteral(block, 31, contentType)
+	block = appendLiteral(block, 28, strconv.Itoa(len(body)))
+
+	var b []byte
+	b = appendFrame(b, frameHeaders, flagEndHeaders, streamID, block)
+	b = appendFrame(b, frameData, flagEndStream, streamID, body)
+
+	_, err := w.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) (typ uint8, flags uint8, streamID uint32, payload []byte, err error) {
+	header := make([]byte, 9)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	payload = make([]byte, int(header[0])<<16|int(header[1])<<8|int(header[2]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	return header[3], header[4], uint32At(header[5:]) & (1<<31 - 1), payload, nil
+}
+
+func appendFrame(b []byte, typ uint8, flags uint8, streamID uint32, payload []byte) []byte {
+	n := len(payload)
+	b = append(b, byte(n>>16), byte(n>>8), byte(n), typ, flags, byte(streamID>>24), byte(streamID>>16), byte(streamID>>8), byte(streamID))
+	return append(b, payload...)
+}
+
+// appendLiteral appends a header field with the name of the static table entry index and value,
+// as a literal without indexing and without Huffman encoding.
+func appendLiteral(b []byte, index uint64, value string) []byte {
+	b = appendInt(b, 0x00, 4, index)
+	b = appendInt(b, 0x00, 7, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendInt appends the HPACK integer v with an n-bit prefix, see https://httpwg.org/specs/rfc7541.html#integer.representation.
+func appendInt(b []byte, first byte, n uint, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(b, first|byte(v))
+	}
+	b = append(b, first|byte(max))
+	for v -= max; v >= 128; v >>= 7 {
+		b = append(b, byte(v&0x7f|0x80))
+	}
+	return append(b, byte(v))
+}
+
+// decodePseudoHeaders returns the names of the pseudo headers at the start of an HPACK header block.
+// Only pseudo headers with a name from the static table are recognized,
+// which is how clients encode them since the dynamic table is empty for the first request of a connection.
+func decodePseudoHeaders(block []byte) []string {
+	var headers []string
+	for len(block) != 0 {
+		var index uint64
+		var literal, ok bool
+		switch b := block[0]; {
+		case b&0x80 != 0: // indexed header field
+			index, block, ok = decodeInt(block, 7)
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			index, block, ok = decodeInt(block, 6)
+			literal = true
+		case b&0xe0 == 0x20: // dynamic table size update
+			_, block, ok = decodeInt(block, 5)
+			if !ok {
+				return headers
+			}
+			continue
+		default: // literal without indexing or never indexed
+			index, block, ok = decodeInt(block, 4)
+			literal = true
+		}
+
+		name, pseudo := staticPseudoHeaders[index]
+		if !ok || !pseudo {
+			return headers
+		}
+		headers = append(headers, name)
+
+		if literal {
+			var n uint64
+			if n, block, ok = decodeInt(block, 7); !ok || n > uint64(len(block)) {
+				return headers
+			}
+			block = block[n:]
+		}
+	}
+	return headers
+}
+
+// decodeInt decodes an HPACK integer with an n-bit prefix from the start of b and returns the rest of b.
+func decodeInt(b []byte, n uint) (uint64, []byte, bool) {
+	if len(b) == 0 {
+		return 0, b, false
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(b[0]) & max
+	b = b[1:]
+	if v < max {
+		return v, b, true
+	}
+	for shift := uint(0); len(b) != 0 && shift < 63; shift += 7 {
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, b, true
+		}
+	}
+	return 0, b, false
+}
+
+// akamaiPriority formats a PRIORITY frame as stream ID:exclusive:stream dependency:weight.
+func akamaiPriority(streamID uint32, payload []byte) string {
+	dep := uint32At(payload)
+	exclusive := dep >> 31
+	return fmt.Sprintf("%d:%d:%d:%d", streamID, exclusive, dep&(1<<31-1), int(payload[4])+1)
+}
+
+func uint32At(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
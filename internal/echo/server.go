@@ -0,0 +1,355 @@
+// Package echo provides a local server that responds with the TLS and HTTP/2 fingerprints of its clients,
+// so adapters can be tested without depending on external fingerprinting services.
+package echo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fingerprint is the JSON response of the server.
+type Fingerprint struct {
+	// Protocol of the request, either HTTP/1.1 or HTTP/2.0.
+	Protocol string `json:"protocol"`
+
+	// Server name the client sent in the ClientHello.
+	ServerName string `json:"server_name,omitempty"`
+
+	// Protocols the client offered with ALPN.
+	ALPN []string `json:"alpn,omitempty"`
+
+	// JA3 fingerprint of the ClientHello and its MD5 hash.
+	JA3     string `json:"ja3,omitempty"`
+	JA3Hash string `json:"ja3_hash,omitempty"`
+
+	// JA4 fingerprint of the ClientHello and its raw form JA4_r.
+	JA4  string `json:"ja4,omitempty"`
+	JA4R string `json:"ja4_r,omitempty"`
+
+	// Akamai fingerprint of HTTP/2 connections and its MD5 hash.
+	Akamai     string `json:"akamai,omitempty"`
+	AkamaiHash string `json:"akamai_hash,omitempty"`
+
+	// Header lines of HTTP/1.1 requests, in the order and casing they were received.
+	Headers []string `json:"headers,omitempty"`
+}
+
+// Server responds to every request with the Fingerprint of the client.
+//
+// It accepts both TLS and plain text connections on the same port.
+// TLS connections negotiate HTTP/2 when the client offers it.
+// Connections are kept alive until the client closes them, or sends an HTTP/1.1 request with Connection: close.
+// Later requests on an HTTP/2 connection are answered with the fingerprint of its first request.
+type Server struct {
+	// Base URL of the server, e.g. https://127.0.0.1:1234.
+	URL string
+
+	// Self-signed certificate of the server, valid for localhost and 127.0.0.1.
+	Certificate *x509.Certificate
+
+	listener   net.Listener
+	tlsConfig  *tls.Config
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	conns      map[net.Conn]struct{}
+	closed     bool
+	handshakes int
+}
+
+// NewServer starts a Server on a random local port.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	cert, err := newCertificate()
+	if err != nil {
+		panic("echo: failed to create certificate: " + err.Error())
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("echo: failed to listen: " + err.Error())
+	}
+
+	s := &Server{
+		URL:         "https://" + ln.Addr().String(),
+		Certificate: cert.Leaf,
+		listener:    ln,
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}},
+		conns:       make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// CertificatePEM returns the PEM encoded certificate of the server.
+func (s *Server) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate.Raw})
+}
+
+// CertPool returns a pool that contains the certificate of the server, for clients that verify it.
+func (s *Server) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate)
+	return pool
+}
+
+// Handshakes returns the number of TLS handshakes the server completed.
+func (s *Server) Handshakes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handshakes
+}
+
+// Close shuts down the server and closes all connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+
+	if first[0] != recordTypeHandshake {
+		s.serveHTTP1(conn, br, &Fingerprint{})
+		return
+	}
+
+	raw, hello, err := readClientHello(br)
+	if err != nil {
+		return
+	}
+
+	f := &Fingerprint{
+		ServerName: hello.serverName,
+		ALPN:       hello.alpn,
+		JA3:        hello.JA3(),
+	}
+	f.JA3Hash = md5Hash(f.JA3)
+	f.JA4, f.JA4R = hello.JA4()
+
+	// replay the ClientHello to the TLS server
+	tlsConn := tls.Server(&replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(raw), br)}, s.tlsConfig)
+	if err = tlsConn.Handshake(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.handshakes++
+	s.mu.Unlock()
+
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		s.serveHTTP2(tlsConn, f)
+	} else {
+		s.serveHTTP1(tlsConn, bufio.NewReader(tlsConn), f)
+	}
+}
+
+func (s *Server) serveHTTP1(conn net.Conn, br *bufio.Reader, f *Fingerprint) {
+	f.Protocol = "HTTP/1.1"
+
+	for {
+		f.Headers = nil
+		keepAlive, err := readHTTP1Request(br, f)
+		if err != nil {
+			return
+		}
+
+		b, err := json.Marshal(f)
+		if err != nil {
+			return
+		}
+		header := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(b)) + "\r\n"
+		if !keepAlive {
+			header += "Connection: close\r\n"
+		}
+		if _, err = conn.Write(append([]byte(header+"\r\n"), b...)); err != nil {
+			return
+		}
+
+		if !keepAlive {
+			drain(conn)
+			return
+		}
+	}
+}
+
+// readHTTP1Request reads a request from br and appends its header lines to f.Headers.
+// It reports whether the client wants to keep the connection alive.
+func readHTTP1Request(br *bufio.Reader, f *Fingerprint) (bool, error) {
+	if _, err := br.ReadString('\n'); err != nil {
+		return false, err
+	}
+
+	var contentLength int64
+	var chunked bool
+	keepAlive := true
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return false, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		f.Headers = append(f.Headers, line)
+
+		if i := strings.IndexByte(line, ':'); i != -1 {
+			key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+			switch {
+			case strings.EqualFold(key, "Content-Length"):
+				contentLength, _ = strconv.ParseInt(value, 10, 64)
+			case strings.EqualFold(key, "Transfer-Encoding"):
+				chunked = strings.EqualFold(value, "chunked")
+			case strings.EqualFold(key, "Connection"):
+				keepAlive = !strings.EqualFold(value, "close")
+			}
+		}
+	}
+
+	var body io.Reader = io.LimitReader(br, contentLength)
+	if chunked {
+		body = httputil.NewChunkedReader(br)
+	}
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return false, err
+	}
+
+	return keepAlive, nil
+}
+
+func (s *Server) serveHTTP2(conn net.Conn, f *Fingerprint) {
+	f.Protocol = "HTTP/2.0"
+
+	akamai, streamID, err := readHTTP2Request(conn)
+	if err != nil {
+		return
+	}
+	f.Akamai, f.AkamaiHash = akamai, md5Hash(akamai)
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	if err = writeHTTP2Settings(conn); err != nil {
+		return
+	}
+
+	for {
+		if err = writeHTTP2Response(conn, streamID, "application/json", b); err != nil {
+			return
+		}
+		if streamID, err = readHTTP2Stream(conn); err != nil {
+			return
+		}
+	}
+}
+
+// drain reads from conn until the client closes it,
+// so the response isn't lost because the connection is reset when it's closed with unread data.
+func drain(conn net.Conn) {
+	io.Copy(io.Discard, conn)
+}
+
+// replayConn is a net.Conn that reads from r.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// newCertificate creates a self-signed certificate for localhost.
+func newCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"gotcha"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
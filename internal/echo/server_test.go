@@ -0,0 +1,220 @@
+package echo
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testClientHello is a ClientHello with GREASE values, SNI and ALPN.
+var testClientHello = func() []byte {
+	vector16 := func(b ...byte) []byte {
+		return append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+	extension := func(typ uint16, data ...byte) []byte {
+		return append([]byte{byte(typ >> 8), byte(typ)}, vector16(data...)...)
+	}
+
+	var extensions []byte
+	extensions = append(extensions, extension(0x1a1a)...)
+	extensions = append(extensions, extension(0, vector16(append([]byte{0}, vector16([]byte("example.com")...)...)...)...)...)
+	extensions = append(extensions, extension(10, vector16(0x2a, 0x2a, 0x00, 0x1d, 0x00, 0x17)...)...)
+	extensions = append(extensions, extension(11, 1, 0)...)
+	extensions = append(extensions, extension(13, vector16(0x04, 0x03, 0x08, 0x04)...)...)
+	extensions = append(extensions, extension(16, vector16(append([]byte{2, 'h', '2', 8}, "http/1.1"...)...)...)...)
+	extensions = append(extensions, extension(43, 6, 0x3a, 0x3a, 0x03, 0x04, 0x03, 0x03)...)
+
+	b := []byte{0x03, 0x03}
+	b = append(b, make([]byte, 32)...)
+	b = append(b, 0)
+	b = append(b, vector16(0x0a, 0x0a, 0x13, 0x01, 0xc0, 0x2b)...)
+	b = append(b, 1, 0)
+	return append(b, vector16(extensions...)...)
+}()
+
+func TestReadClientHello(t *testing.T) {
+	msg := append([]byte{handshakeTypeClientHello, 0, byte(len(testClientHello) >> 8), byte(len(testClientHello))}, testClientHello...)
+
+	// the message is split over two records
+	var raw []byte
+	for _, fragment := range [][]byte{msg[:10], msg[10:]} {
+		raw = append(raw, recordTypeHandshake, 0x03, 0x01, byte(len(fragment)>>8), byte(len(fragment)))
+		raw = append(raw, fragment...)
+	}
+
+	read, hello, err := readClientHello(bytes.NewReader(append(raw, "rest"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, raw) {
+		t.Fatalf("expected the raw records to be returned")
+	}
+
+	if hello.serverName != "example.com" || !reflect.DeepEqual(hello.alpn, []string{"h2", "http/1.1"}) {
+		t.Fatalf("unexpected server name %q or ALPN %v", hello.serverName, hello.alpn)
+	}
+
+	expected := "771,4865-49195,0-10-11-13-16-43,29-23,0"
+	if ja3 := hello.JA3(); ja3 != expected {
+		t.Fatalf(tests.MismatchFormat, "JA3", expected, ja3)
+	}
+
+	ja4, ja4r := hello.JA4()
+	if expected = "t13d0206h2_777cda164f4b_fb71836bce29"; ja4 != expected {
+		t.Fatalf(tests.MismatchFormat, "JA4", expected, ja4)
+	}
+	if expected = "t13d0206h2_1301,c02b_000a,000b,000d,002b_0403,0804"; ja4r != expected {
+		t.Fatalf(tests.MismatchFormat, "JA4_r", expected, ja4r)
+	}
+
+	if _, _, err = readClientHello(bytes.NewReader([]byte{23, 3, 3, 0, 0})); err == nil {
+		t.Fatal("expected an error for a non-handshake record")
+	}
+	if _, err = parseClientHello(testClientHello[:40]); err == nil {
+		t.Fatal("expected an error for a truncated ClientHello")
+	}
+}
+
+func TestReadHTTP2Request(t *testing.T) {
+	var b []byte
+	b = append(b, http2ClientPreface...)
+	b = appendFrame(b, frameSettings, 0, 0, []byte{0, 1, 0, 1, 0, 0, 0, 3, 0, 0, 3, 0xe8, 0, 4, 0, 0x60, 0, 0})
+	b = appendFrame(b, frameWindowUpdate, 0, 0, []byte{0, 0xef, 0, 1})
+	b = appendFrame(b, framePriority, 0, 3, []byte{0, 0, 0, 0, 200})
+	b = appendFrame(b, framePriority, 0, 5, []byte{0x80, 0, 0, 3, 100})
+	b = appendFrame(b, frameSettings, flagAck, 0, nil)
+	// :method GET, :authority example.com (literal with incremental indexing), :scheme https, :path /, accept */* (literal)
+	block := append([]byte{0x82, 0x41, 11}, "example.com"...)
+	block = append(block, 0x87, 0x84, 0x0f, 0x04, 3, '*', '/', '*')
+	b = appendFrame(b, frameHeaders, flagEndHeaders|flagEndStream|flagPriority, 1, append([]byte{0x80, 0, 0, 0, 255}, block...))
+
+	akamai, streamID, err := readHTTP2Request(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1:65536;3:1000;4:6291456|15663105|3:0:0:201,5:1:3:101|m,a,s,p"; akamai != expected {
+		t.Fatalf(tests.MismatchFormat, "Akamai fingerprint", expected, akamai)
+	}
+	if md5Hash(akamai) != "16d796edfce818c7f14c3671ff4b9413" {
+		t.Fatalf("unexpected hash %s", md5Hash(akamai))
+	}
+	if streamID != 1 {
+		t.Fatalf(tests.MismatchFormat, "stream ID", 1, streamID)
+	}
+
+	if _, _, err = readHTTP2Request(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err == nil {
+		t.Fatal("expected an error for an HTTP/1.1 request")
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	for _, test := range []struct {
+		name     string
+		url      string
+		http2    bool
+		protocol string
+	}{
+		{"HTTP/1.1", s.URL, false, "HTTP/1.1"},
+		{"HTTP/2", s.URL, true, "HTTP/2.0"},
+		{"plain text", strings.Replace(s.URL, "https", "http", 1), false, "HTTP/1.1"},
+	} {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: s.CertPool()}, ForceAttemptHTTP2: test.http2}
+		if !test.http2 {
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		req, _ := http.NewRequest(http.MethodPost, test.url, strings.NewReader("body"))
+		req.Header.Set("X-Test", "gotcha")
+
+		res, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var f Fingerprint
+		err = json.NewDecoder(res.Body).Decode(&f)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if f.Protocol != test.protocol || res.Proto != test.protocol {
+			t.Fatalf("%s: unexpected protocol %s (%s)", test.name, f.Protocol, res.Proto)
+		}
+
+		if test.name == "plain text" {
+			if f.JA3 != "" {
+				t.Fatalf("%s: unexpected JA3 %s", test.name, f.JA3)
+			}
+		} else if f.JA3Hash != md5Hash(f.JA3) || !strings.HasPrefix(f.JA4, "t13i") || !strings.HasPrefix(f.JA4R, f.JA4[:10]) {
+			t.Fatalf("%s: unexpected TLS fingerprints %+v", test.name, f)
+		}
+
+		if test.http2 {
+			// Go sends :authority first
+			if !strings.HasSuffix(f.Akamai, "|a,m,p,s") || f.AkamaiHash != md5Hash(f.Akamai) {
+				t.Fatalf("%s: unexpected Akamai fingerprint %s", test.name, f.Akamai)
+			}
+		} else if !containsString(f.Headers, "X-Test: gotcha") || !containsString(f.Headers, "Content-Length: 4") {
+			t.Fatalf("%s: unexpected headers %v", test.name, f.Headers)
+		}
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServer_KeepAlive(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	for i, http2 := range []bool{false, true} {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: s.CertPool()}, ForceAttemptHTTP2: http2}
+		if !http2 {
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		client := &http.Client{Transport: transport}
+
+		for j := 0; j < 3; j++ {
+			res, err := client.Post(s.URL, "text/plain", strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		transport.CloseIdleConnections()
+
+		if expected := i + 1; s.Handshakes() != expected {
+			t.Fatalf(tests.MismatchFormat, "handshakes", expected, s.Handshakes())
+		}
+	}
+
+	// the connection is closed when the client asks for it
+	req, _ := http.NewRequest(http.MethodGet, strings.Replace(s.URL, "https", "http", 1), nil)
+	req.Close = true
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if !res.Close {
+		t.Fatal("expected the server to close the connection")
+	}
+}
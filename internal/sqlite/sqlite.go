@@ -0,0 +1,503 @@
+// Package sqlite implements a minimal, read-only reader of SQLite 3 database files.
+//
+// It only supports what's needed to read browser cookie databases: scanning all rows of a (rowid) table.
+// Changes in a write-ahead log that have been committed are taken into account.
+// See https://www.sqlite.org/fileformat.html for the file format.
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const headerMagic = "SQLite format 3\x00"
+
+var (
+	InvalidDatabaseError = errors.New("sqlite: file is not a database")
+	CorruptDatabaseError = errors.New("sqlite: database disk image is malformed")
+)
+
+// DB is a database that's read into memory.
+type DB struct {
+	data     []byte
+	pageSize int
+	// usable size of each page, without the reserved space at the end
+	usable int
+	// pages that were changed in the write-ahead log
+	wal map[uint32][]byte
+}
+
+// Row maps column names to their values, which are either nil, int64, float64, string or []byte.
+type Row map[string]interface{}
+
+// Open reads the database with given filename.
+// When a write-ahead log exists (filename-wal), its committed changes are read as well.
+func Open(filename string) (*DB, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	wal, err := os.ReadFile(filename + "-wal")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(wal) != 0 {
+		db.readWAL(wal)
+	}
+
+	return db, nil
+}
+
+// Parse parses a database file.
+func Parse(data []byte) (*DB, error) {
+	if len(data) < 100 || string(data[:16]) != headerMagic {
+		return nil, InvalidDatabaseError
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, CorruptDatabaseError
+	}
+
+	// the usable size of a page can't be smaller than 480 bytes
+	usable := pageSize - int(data[20])
+	if usable < 480 {
+		return nil, CorruptDatabaseError
+	}
+
+	if encoding := binary.BigEndian.Uint32(data[56:60]); encoding > 1 {
+		return nil, fmt.Errorf("sqlite: unsupported text encoding %d", encoding)
+	}
+
+	return &DB{
+		data:     data,
+		pageSize: pageSize,
+		usable:   usable,
+	}, nil
+}
+
+// readWAL reads the pages of all committed transactions in a write-ahead log.
+func (db *DB) readWAL(wal []byte) {
+	if len(wal) < 32 {
+		return
+	}
+	if magic := binary.BigEndian.Uint32(wal[0:4]); magic != 0x377f0682 && magic != 0x377f0683 {
+		return
+	}
+	if int(binary.BigEndian.Uint32(wal[8:12])) != db.pageSize {
+		return
+	}
+	salt := wal[16:24]
+
+	db.wal = make(map[uint32][]byte)
+	pending := make(map[uint32][]byte)
+
+	for offset := 32; offset+24+db.pageSize <= len(wal); offset += 24 + db.pageSize {
+		frame := wal[offset : offset+24]
+		// frames of a previous generation of the log are no longer valid
+		if string(frame[8:16]) != string(salt) {
+			break
+		}
+
+		pending[binary.BigEndian.Uint32(frame[0:4])] = wal[offset+24 : offset+24+db.pageSize]
+
+		// a commit frame contains the size of the database after the transaction
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			for number, page := range pending {
+				db.wal[number] = page
+			}
+			pending = make(map[uint32][]byte)
+		}
+	}
+}
+
+// page returns the page with given (1-based) number.
+func (db *DB) page(number uint32) ([]byte, error) {
+	if page, ok := db.wal[number]; ok {
+		return page, nil
+	}
+
+	start := int(number-1) * db.pageSize
+	if number == 0 || start+db.pageSize > len(db.data) {
+		return nil, CorruptDatabaseError
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// Rows returns all rows of given table.
+func (db *DB) Rows(table string) ([]Row, error) {
+	// the schema table is stored in the b-tree of the first page
+	var schema []Row
+	err := db.scan(1, func(rowid int64, values []interface{}) error {
+		if len(values) < 5 {
+			return CorruptDatabaseError
+		}
+		schema = append(schema, Row{"type": values[0], "name": values[1], "rootpage": values[3], "sql": values[4]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range schema {
+		if s["type"] != "table" || !strings.EqualFold(fmt.Sprint(s["name"]), table) {
+			continue
+		}
+
+		sql, _ := s["sql"].(string)
+		columns, rowidColumn, err := parseColumns(sql)
+		if err != nil {
+			return nil, err
+		}
+		root, ok := s["rootpage"].(int64)
+		if !ok {
+			return nil, CorruptDatabaseError
+		}
+
+		var rows []Row
+		err = db.scan(uint32(root), func(rowid int64, values []interface{}) error {
+			row := make(Row, len(columns))
+			for i, c := range columns {
+				// columns that were added later are missing from older records
+				var value interface{}
+				if i < len(values) {
+					value = values[i]
+				}
+				// whole numbers in REAL columns may be stored as integers
+				if v, ok := value.(int64); ok && c.real {
+					value = float64(v)
+				}
+				row[c.name] = value
+			}
+			// an INTEGER PRIMARY KEY column is an alias for the rowid, which is stored as NULL
+			if rowidColumn != "" {
+				row[rowidColumn] = rowid
+			}
+			rows = append(rows, row)
+			return nil
+		})
+
+		return rows, err
+	}
+
+	return nil, fmt.Errorf("sqlite: no such table: %s", table)
+}
+
+// scan calls fn for each record in the table b-tree with given root page.
+func (db *DB) scan(number uint32, fn func(rowid int64, values []interface{}) error) error {
+	return db.scanPage(number, fn, map[uint32]bool{})
+}
+
+func (db *DB) scanPage(number uint32, fn func(rowid int64, values []interface{}) error, visited map[uint32]bool) error {
+	// a page referenced twice means a cycle or shared subtree in a corrupt database
+	if visited[number] {
+		return CorruptDatabaseError
+	}
+	visited[number] = true
+
+	page, err := db.page(number)
+	if err != nil {
+		return err
+	}
+
+	// the first page starts with the database header
+	header := page
+	if number == 1 {
+		header = page[100:]
+	}
+	if len(header) < 8 {
+		return CorruptDatabaseError
+	}
+
+	pageType := header[0]
+	cells := int(binary.BigEndian.Uint16(header[3:5]))
+
+	var pointers []byte
+	switch pageType {
+	case 0x05:
+		// interior table page
+		if len(header) < 12+cells*2 {
+			return CorruptDatabaseError
+		}
+		pointers = header[12 : 12+cells*2]
+	case 0x0d:
+		// leaf table page
+		if len(header) < 8+cells*2 {
+			return CorruptDatabaseError
+		}
+		pointers = header[8 : 8+cells*2]
+	case 0x02, 0x0a:
+		return errors.New("sqlite: tables without rowid are not supported")
+	default:
+		return CorruptDatabaseError
+	}
+
+	for i := 0; i < cells; i++ {
+		offset := int(binary.BigEndian.Uint16(pointers[i*2:]))
+		if offset+4 > len(page) {
+			return CorruptDatabaseError
+		}
+		cell := page[offset:]
+
+		if pageType == 0x05 {
+			if err = db.scanPage(binary.BigEndian.Uint32(cell[0:4]), fn, visited); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size, n := readVarint(cell)
+		if n == 0 {
+			return CorruptDatabaseError
+		}
+		cell = cell[n:]
+		rowid, n := readVarint(cell)
+		if n == 0 {
+			return CorruptDatabaseError
+		}
+		cell = cell[n:]
+
+		// a payload can't be larger than the database itself
+		if size > uint64(len(db.data)+len(db.wal)*db.pageSize) {
+			return CorruptDatabaseError
+		}
+		payload, err := db.payload(cell, int(size))
+		if err != nil {
+			return err
+		}
+		values, err := parseRecord(payload)
+		if err != nil {
+			return err
+		}
+		if err = fn(int64(rowid), values); err != nil {
+			return err
+		}
+	}
+
+	if pageType == 0x05 {
+		return db.scanPage(binary.BigEndian.Uint32(header[8:12]), fn, visited)
+	}
+
+	return nil
+}
+
+// payload returns the payload of a table leaf cell with given size, following overflow pages when needed.
+func (db *DB) payload(cell []byte, size int) ([]byte, error) {
+	maxLocal := db.usable - 35
+	if size <= maxLocal {
+		if size > len(cell) {
+			return nil, CorruptDatabaseError
+		}
+		return cell[:size], nil
+	}
+
+	minLocal := (db.usable-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(db.usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	if local+4 > len(cell) {
+		return nil, CorruptDatabaseError
+	}
+
+	payload := make([]byte, 0, size)
+	payload = append(payload, cell[:local]...)
+
+	next := binary.BigEndian.Uint32(cell[local : local+4])
+	for len(payload) < size {
+		if next == 0 {
+			return nil, CorruptDatabaseError
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		n := size - len(payload)
+		if n > db.usable-4 {
+			n = db.usable - 4
+		}
+		payload = append(payload, page[4:4+n]...)
+		next = binary.BigEndian.Uint32(page[0:4])
+	}
+
+	return payload, nil
+}
+
+// parseRecord parses the values of a record.
+func parseRecord(record []byte) ([]interface{}, error) {
+	headerSize, n := readVarint(record)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(record)) {
+		return nil, CorruptDatabaseError
+	}
+
+	header := record[n:headerSize]
+	body := record[headerSize:]
+
+	var values []interface{}
+	for len(header) > 0 {
+		serialType, n := readVarint(header)
+		if n == 0 {
+			return nil, CorruptDatabaseError
+		}
+		header = header[n:]
+
+		var size uint64
+		switch {
+		case serialType >= 12:
+			size = (serialType - 12) / 2
+		case serialType >= 1 && serialType <= 4:
+			size = serialType
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		}
+		if size > uint64(len(body)) {
+			return nil, CorruptDatabaseError
+		}
+		data := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType <= 6:
+			// big-endian two's complement integer
+			var v int64
+			if size > 0 && data[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, b := range data {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType >= 12 && serialType%2 == 0:
+			values = append(values, append([]byte{}, data...))
+		case serialType >= 13:
+			values = append(values, string(data))
+		default:
+			return nil, CorruptDatabaseError
+		}
+	}
+
+	return values, nil
+}
+
+// readVarint reads a variable-length integer and returns it with the number of bytes read, which is 0 when b is too short.
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// column is a column of a table.
+type column struct {
+	name string
+	// whether the column has REAL affinity
+	real bool
+}
+
+// parseColumns returns the columns of a CREATE TABLE statement, and the name of the column that's an alias for the rowid, if any.
+func parseColumns(sql string) ([]column, string, error) {
+	start := strings.IndexByte(sql, '(')
+	end := strings.LastIndexByte(sql, ')')
+	if start == -1 || end < start {
+		return nil, "", fmt.Errorf("sqlite: unsupported table definition: %s", sql)
+	}
+
+	var columns []column
+	var rowidColumn string
+
+	for _, def := range splitDefinitions(sql[start+1 : end]) {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// skip table constraints
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+
+		name := strings.Trim(fields[0], "\"`[]'")
+		constraints := strings.ToUpper(strings.Join(fields[1:], " "))
+
+		// see https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+		var typeName string
+		if len(fields) > 1 {
+			typeName = strings.ToUpper(fields[1])
+		}
+		real := !strings.Contains(typeName, "INT") && !strings.Contains(typeName, "CHAR") && !strings.Contains(typeName, "CLOB") && !strings.Contains(typeName, "TEXT") &&
+			(strings.Contains(typeName, "REAL") || strings.Contains(typeName, "FLOA") || strings.Contains(typeName, "DOUB"))
+
+		columns = append(columns, column{name: name, real: real})
+
+		if strings.HasPrefix(constraints, "INTEGER PRIMARY KEY") {
+			rowidColumn = name
+		}
+	}
+
+	return columns, rowidColumn, nil
+}
+
+// splitDefinitions splits the column definitions and table constraints of a CREATE TABLE statement.
+func splitDefinitions(s string) []string {
+	var defs []string
+	var depth int
+	var quote byte
+	begin := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, s[begin:i])
+			begin = i + 1
+		}
+	}
+
+	return append(defs, s[begin:])
+}
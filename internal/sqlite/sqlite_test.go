@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDB_Rows(t *testing.T) {
+	db, err := Open("testdata/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Rows("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 301 {
+		t.Fatalf(tests.MismatchFormat, "number of rows", 301, len(rows))
+	}
+
+	for i, row := range rows[:300] {
+		n := int64(i + 1)
+		if row["id"] != n || row["name"] != "item"+strconv.FormatInt(n, 10) || row["amount"] != float64(n)/2 || row["count"] != -n*100000 || row["extra"] != nil {
+			t.Fatalf("unexpected row %d: %v", n, row)
+		}
+
+		// some values are stored on overflow pages
+		size := 3
+		if n%100 == 0 {
+			size = int(n) * 10
+		}
+		if value := row["value"].([]byte); !bytes.Equal(value, bytes.Repeat([]byte{byte(n % 256)}, size)) {
+			t.Fatalf(tests.MismatchFormat, "value of row "+strconv.FormatInt(n, 10), size, len(value))
+		}
+	}
+
+	last := rows[300]
+	if last["value"] != nil || last["count"] != int64(9007199254740993) || last["extra"] != "x" {
+		t.Fatalf("unexpected last row %v", last)
+	}
+
+	if _, err = db.Rows("missing"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDB_WAL(t *testing.T) {
+	db, err := Open("testdata/wal.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Rows("kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["k"] != "a" || rows[1]["k"] != "b" || rows[1]["v"] != "2" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	if _, err := Parse([]byte("not a database")); err != InvalidDatabaseError {
+		t.Fatalf(tests.MismatchFormat, "error", InvalidDatabaseError, err)
+	}
+}
+
+func TestParseRecord_Corrupt(t *testing.T) {
+	for _, record := range [][]byte{
+		{},
+		// header size smaller than its own varint
+		{0x00, 0x01},
+		// header size larger than the record
+		{0x05, 0x01},
+		// truncated serial type
+		{0x02, 0xff},
+		// serial type of a blob larger than any slice
+		{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		// integer without a value
+		{0x02, 0x01},
+		// reserved serial type
+		{0x02, 0x0a},
+	} {
+		if _, err := parseRecord(record); err != CorruptDatabaseError {
+			t.Fatalf(tests.MismatchFormat, "error of record "+fmt.Sprintf("%x", record), CorruptDatabaseError, err)
+		}
+	}
+}
+
+// TestDB_Cycle reads a database whose interior pages all point twice to the next page.
+func TestDB_Cycle(t *testing.T) {
+	db, err := Open("testdata/cycle.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Rows("cookies"); err != CorruptDatabaseError {
+		t.Fatalf(tests.MismatchFormat, "error", CorruptDatabaseError, err)
+	}
+}
+
+// TestDB_Corrupt reads randomly corrupted and truncated copies of a database, which must fail without panicking.
+func TestDB_Corrupt(t *testing.T) {
+	data, err := os.ReadFile("testdata/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(data []byte) {
+		if db, err := Parse(data); err == nil {
+			db.Rows("items")
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		corrupt := append([]byte{}, data...)
+		for j := 0; j < 1+r.Intn(16); j++ {
+			corrupt[100+r.Intn(len(corrupt)-100)] = byte(r.Intn(256))
+		}
+		read(corrupt)
+	}
+
+	for n := 0; n < len(data); n += 1 + r.Intn(512) {
+		read(data[:n])
+	}
+}
@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// SOCKSServer is a SOCKS5 and SOCKS4a proxy that supports the CONNECT command, for testing proxy support of adapters.
+type SOCKSServer struct {
+	// Address the server listens on.
+	Addr string
+
+	username string
+	password string
+	listener net.Listener
+
+	mu       sync.Mutex
+	requests []string
+}
+
+// NewSOCKSServer starts a SOCKSServer on a random local port.
+// SOCKS5 clients have to authenticate with username and password, unless both are empty.
+// SOCKS4a clients have to send username as their user ID, unless it's empty.
+func NewSOCKSServer(username string, password string) *SOCKSServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("tests: failed to listen: " + err.Error())
+	}
+
+	s := &SOCKSServer{Addr: ln.Addr().String(), username: username, password: password, listener: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// Requests returns the addresses clients requested to connect to, as they were sent.
+// Domain names are returned as is, so it's possible to tell whether the client resolved them.
+func (s *SOCKSServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// Close stops listening for new connections.
+func (s *SOCKSServer) Close() {
+	s.listener.Close()
+}
+
+func (s *SOCKSServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return
+	}
+
+	var addr string
+	var err error
+	// replies that the connection succeeded or failed
+	succeeded, failed := []byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}, []byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0}
+	if version[0] == 4 {
+		addr, err = s.handshake4(conn)
+		succeeded, failed = []byte{0, 0x5a, 0, 0, 0, 0, 0, 0}, []byte{0, 0x5b, 0, 0, 0, 0, 0, 0}
+	} else {
+		addr, err = s.handshake5(conn)
+	}
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, addr)
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write(failed)
+		return
+	}
+	defer upstream.Close()
+
+	if _, err = conn.Write(succeeded); err != nil {
+		return
+	}
+
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+}
+
+// handshake5 negotiates the SOCKS5 authentication method, authenticates the client and returns the address it requested.
+// The version has already been read.
+func (s *SOCKSServer) handshake5(conn net.Conn) (string, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	methods := make([]byte, b[0])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(0)
+	if s.username != "" || s.password != "" {
+		method = 2
+	}
+	if !containsByte(methods, method) {
+		conn.Write([]byte{5, 0xff})
+		return "", errors.New("no acceptable authentication method")
+	}
+	if _, err := conn.Write([]byte{5, method}); err != nil {
+		return "", err
+	}
+
+	if method == 2 {
+		username, password, err := readCredentials(conn)
+		if err != nil {
+			return "", err
+		}
+		if username != s.username || password != s.password {
+			conn.Write([]byte{1, 1})
+			return "", errors.New("invalid credentials")
+		}
+		if _, err = conn.Write([]byte{1, 0}); err != nil {
+			return "", err
+		}
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[1] != 1 {
+		// command not supported
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("unsupported command")
+	}
+
+	var host string
+	switch header[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if header[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return "", err
+		}
+		domain := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errors.New("unsupported address type")
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// handshake4 reads a SOCKS4a CONNECT request and returns the address it requested.
+// The version has already been read.
+func (s *SOCKSServer) handshake4(conn net.Conn) (string, error) {
+	b := make([]byte, 7)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	if b[0] != 1 {
+		conn.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("unsupported command")
+	}
+
+	br := bufio.NewReader(conn)
+	userID, err := readString(br)
+	if err != nil {
+		return "", err
+	}
+	if s.username != "" && userID != s.username {
+		// the user ID doesn't match the one reported by identd
+		conn.Write([]byte{0, 0x5d, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("invalid user ID")
+	}
+
+	host := net.IP(b[3:7]).String()
+	// an address of 0.0.0.x is followed by the domain name
+	if b[3] == 0 && b[4] == 0 && b[5] == 0 && b[6] != 0 {
+		if host, err = readString(br); err != nil {
+			return "", err
+		}
+	}
+	if br.Buffered() != 0 {
+		return "", errors.New("unexpected data after request")
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b[1:3])))), nil
+}
+
+// readString reads a null terminated string.
+func readString(br *bufio.Reader) (string, error) {
+	s, err := br.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return s[:len(s)-1], nil
+}
+
+// readCredentials reads a username/password authentication request, see RFC 1929.
+func readCredentials(r io.Reader) (string, string, error) {
+	var fields [2]string
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", "", err
+	}
+	for i := range fields {
+		if i == 1 {
+			if _, err := io.ReadFull(r, b[1:]); err != nil {
+				return "", "", err
+			}
+		}
+		field := make([]byte, b[1])
+		if _, err := io.ReadFull(r, field); err != nil {
+			return "", "", err
+		}
+		fields[i] = string(field)
+	}
+	return fields[0], fields[1], nil
+}
+
+func containsByte(b []byte, c byte) bool {
+	for _, v := range b {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
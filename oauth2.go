@@ -0,0 +1,316 @@
+package gotcha
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sleeyax/urlValues"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2Grant is an OAuth2 authorization grant (RFC 6749) that can be exchanged for an access token.
+type OAuth2Grant interface {
+	// Form returns the grant specific parameters of the token request.
+	// Parameters must be added with urlValues.Values.Add to preserve their order.
+	Form() urlValues.Values
+}
+
+// ClientCredentialsGrant is the OAuth2 client credentials grant.
+type ClientCredentialsGrant struct{}
+
+func (g *ClientCredentialsGrant) Form() urlValues.Values {
+	form := urlValues.Values{}
+	form.Add("grant_type", "client_credentials")
+	return form
+}
+
+// PasswordGrant is the OAuth2 resource owner password credentials grant.
+type PasswordGrant struct {
+	Username string
+	Password string
+}
+
+func (g *PasswordGrant) Form() urlValues.Values {
+	form := urlValues.Values{}
+	form.Add("grant_type", "password")
+	form.Add("username", g.Username)
+	form.Add("password", g.Password)
+	return form
+}
+
+// RefreshTokenGrant exchanges a refresh token for a new access token.
+type RefreshTokenGrant struct {
+	RefreshToken string
+}
+
+func (g *RefreshTokenGrant) Form() urlValues.Values {
+	form := urlValues.Values{}
+	form.Add("grant_type", "refresh_token")
+	form.Add("refresh_token", g.RefreshToken)
+	return form
+}
+
+// OAuth2Token is an access token issued by the authorization server.
+type OAuth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+
+	// Time at which the token expires, zero if it doesn't.
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// OAuth2Error is an error response of the token endpoint.
+type OAuth2Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	URI         string `json:"error_uri"`
+}
+
+func (e *OAuth2Error) Error() string {
+	msg := fmt.Sprintf("oauth2: token request failed with status code %d", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += " (" + e.Description + ")"
+	}
+	return msg
+}
+
+// OAuth2 authenticates requests with an OAuth2 bearer token.
+//
+// A token is acquired using Grant on the first request and is refreshed proactively when it's about to expire.
+// Once the server issued a refresh token, it's used for all subsequent refreshes,
+// until the server rejects it and a new token is acquired using Grant again.
+// When a request is rejected with 401 Unauthorized, the token is refreshed and the request is sent again.
+//
+// An OAuth2 is safe for concurrent use, so it can be shared by all requests of a Client.
+type OAuth2 struct {
+	// URL of the token endpoint.
+	TokenURL string
+
+	ClientID     string
+	ClientSecret string
+
+	// Scopes to request.
+	Scopes []string
+
+	// Grant used to acquire the first token.
+	Grant OAuth2Grant
+
+	// Send the client credentials as form parameters instead of using basic authentication.
+	CredentialsInBody bool
+
+	// Refresh the token this long before it expires.
+	//
+	// Defaults to 10 seconds.
+	ExpiryDelta time.Duration
+
+	// Client used to send token requests.
+	//
+	// Defaults to a Client with default Options.
+	Client *Client
+
+	mu    sync.Mutex
+	token *OAuth2Token
+	// token request in progress, shared by all requests that need a new token
+	pending *oauth2Call
+}
+
+// oauth2Call is a token request whose result is shared by all requests that wait for it.
+type oauth2Call struct {
+	done  chan struct{}
+	token *OAuth2Token
+	err   error
+}
+
+// NewOAuth2 creates a new OAuth2 that acquires tokens from given token endpoint using the client credentials grant.
+func NewOAuth2(tokenURL string, clientID string, clientSecret string, scopes ...string) *OAuth2 {
+	return &OAuth2{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		Grant:        &ClientCredentialsGrant{},
+	}
+}
+
+func (a *OAuth2) Authenticate(options *Options) error {
+	token, err := a.Token()
+	if err != nil {
+		return err
+	}
+	options.Headers.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
+
+func (a *OAuth2) Challenge(options *Options, response *Response) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// only invalidate the token if it's the one that was rejected, another request might have refreshed it already
+	if a.token != nil && options.Headers.Get("Authorization") == "Bearer "+a.token.AccessToken {
+		// tokens are shared, so never modify one in place
+		token := *a.token
+		token.AccessToken = ""
+		a.token = &token
+	}
+
+	return true, nil
+}
+
+// Token returns a valid token, acquiring or refreshing it first when needed.
+// The token endpoint is requested without holding the lock, concurrent callers wait for the same token request.
+func (a *OAuth2) Token() (*OAuth2Token, error) {
+	a.mu.Lock()
+	if a.valid() {
+		token := a.token
+		a.mu.Unlock()
+		return token, nil
+	}
+	if call := a.pending; call != nil {
+		a.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &oauth2Call{done: make(chan struct{})}
+	a.pending = call
+	previous := a.token
+	a.mu.Unlock()
+
+	call.token, call.err = a.acquire(previous)
+
+	a.mu.Lock()
+	if call.err == nil {
+		a.token = call.token
+	}
+	a.pending = nil
+	a.mu.Unlock()
+	close(call.done)
+
+	return call.token, call.err
+}
+
+// acquire requests a new token, using the refresh token of previous if there is one.
+// Grant is used instead when the server rejects the refresh token, e.g. because it expired or was revoked.
+func (a *OAuth2) acquire(previous *OAuth2Token) (*OAuth2Token, error) {
+	if previous != nil && previous.RefreshToken != "" {
+		token, err := a.requestToken(&RefreshTokenGrant{RefreshToken: previous.RefreshToken})
+		if err == nil {
+			// keep using the previous refresh token if the server didn't issue a new one
+			if token.RefreshToken == "" {
+				token.RefreshToken = previous.RefreshToken
+			}
+			return token, nil
+		}
+
+		var e *OAuth2Error
+		if !errors.As(err, &e) || a.Grant == nil {
+			return nil, err
+		}
+	}
+
+	return a.requestToken(a.Grant)
+}
+
+// SetToken sets the current token, e.g. one that was persisted earlier.
+func (a *OAuth2) SetToken(token *OAuth2Token) {
+	a.mu.Lock()
+	a.token = token
+	a.mu.Unlock()
+}
+
+// valid reports whether the current token can still be used.
+func (a *OAuth2) valid() bool {
+	if a.token == nil || a.token.AccessToken == "" {
+		return false
+	}
+	if a.token.Expiry.IsZero() {
+		return true
+	}
+
+	delta := a.ExpiryDelta
+	if delta == 0 {
+		delta = time.Second * 10
+	}
+
+	return time.Now().Add(delta).Before(a.token.Expiry)
+}
+
+// requestToken exchanges grant for a new token at the token endpoint.
+func (a *OAuth2) requestToken(grant OAuth2Grant) (*OAuth2Token, error) {
+	if grant == nil {
+		return nil, fmt.Errorf("oauth2: no grant to acquire a token with")
+	}
+
+	client := a.Client
+	if client == nil {
+		var err error
+		if client, err = NewClient(&Options{}); err != nil {
+			return nil, err
+		}
+	}
+
+	form := grant.Form()
+	if len(a.Scopes) != 0 {
+		form.Add("scope", strings.Join(a.Scopes, " "))
+	}
+
+	headers := http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+		"Accept":       {"application/json"},
+	}
+
+	if a.CredentialsInBody {
+		form.Add("client_id", a.ClientID)
+		if a.ClientSecret != "" {
+			form.Add("client_secret", a.ClientSecret)
+		}
+	} else if a.ClientID != "" {
+		credentials := url.QueryEscape(a.ClientID) + ":" + url.QueryEscape(a.ClientSecret)
+		headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	res, err := client.Post(a.TokenURL, &Options{Form: form, Headers: headers})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	body, err := res.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		e := &OAuth2Error{StatusCode: res.StatusCode}
+		json.Unmarshal(body, e)
+		return nil, e
+	}
+
+	var tr struct {
+		OAuth2Token
+		ExpiresIn json.Number `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2: server response is missing access_token")
+	}
+
+	token := tr.OAuth2Token
+	if expiresIn, err := tr.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,229 @@
+package gotcha
+
+import (
+	"fmt"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newOAuth2Server creates a token endpoint and a protected API.
+// The API rejects the first issued access token when rejectFirst is true.
+func newOAuth2Server(t *testing.T, expiresIn int, rejectFirst bool) (*httptest.Server, *int32, *sync.Map) {
+	var issued int32
+	grants := &sync.Map{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+			t.Errorf(tests.MismatchFormat, "content type", "application/x-www-form-urlencoded", ct)
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		r.ParseForm()
+		grants.Store(r.PostForm.Get("grant_type"), true)
+
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "bearer", "expires_in": %d, "refresh_token": "refresh%d"}`, n, expiresIn, n)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if rejectFirst && auth == "Bearer token1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(auth))
+	})
+
+	return httptest.NewServer(mux), &issued, grants
+}
+
+func TestOAuth2_Concurrency(t *testing.T) {
+	ts, issued, _ := newOAuth2Server(t, 3600, false)
+	defer ts.Close()
+
+	client, err := NewClient(&Options{
+		PrefixURL: ts.URL,
+		Auth:      NewOAuth2(ts.URL+"/token", "client", "secret", "read", "write"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	count := 20
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			res, err := client.Get("api", &Options{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if body, _ := res.Text(); body != "Bearer token1" {
+				t.Errorf(tests.MismatchFormat, "authorization", "Bearer token1", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(issued); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "issued tokens", 1, n)
+	}
+}
+
+func TestOAuth2_Refresh(t *testing.T) {
+	// tokens expire within the expiry delta, so each request should refresh the token proactively
+	ts, issued, grants := newOAuth2Server(t, 1, false)
+	defer ts.Close()
+
+	auth := &OAuth2{
+		TokenURL:     ts.URL + "/token",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Grant:        &PasswordGrant{Username: "foo", Password: "bar"},
+		ExpiryDelta:  time.Minute,
+	}
+
+	for i := 1; i <= 2; i++ {
+		res, err := Get(ts.URL+"/api", &Options{Auth: auth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, expected := mustText(t, res), fmt.Sprintf("Bearer token%d", i); body != expected {
+			t.Fatalf(tests.MismatchFormat, "authorization", expected, body)
+		}
+	}
+
+	if n := atomic.LoadInt32(issued); n != 2 {
+		t.Fatalf(tests.MismatchFormat, "issued tokens", 2, n)
+	}
+	for _, grant := range []string{"password", "refresh_token"} {
+		if _, ok := grants.Load(grant); !ok {
+			t.Fatalf("expected a token to be requested using the %s grant", grant)
+		}
+	}
+}
+
+func TestOAuth2_RefreshRejected(t *testing.T) {
+	var grants []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		grant := r.PostForm.Get("grant_type")
+		grants = append(grants, grant)
+
+		// refresh tokens are revoked as soon as they're issued
+		if grant == "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "token%d", "expires_in": 1, "refresh_token": "refresh"}`, len(grants))
+	}))
+	defer ts.Close()
+
+	auth := NewOAuth2(ts.URL, "client", "secret")
+	auth.ExpiryDelta = time.Minute
+
+	for i := 0; i < 2; i++ {
+		if _, err := auth.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rejected refresh token is replaced by a token acquired using the original grant
+	expected := []string{"client_credentials", "refresh_token", "client_credentials"}
+	if fmt.Sprint(grants) != fmt.Sprint(expected) {
+		t.Fatalf(tests.MismatchFormat, "grants", expected, grants)
+	}
+	if token, _ := auth.Token(); token.AccessToken != "token5" {
+		t.Fatalf(tests.MismatchFormat, "access token", "token5", token.AccessToken)
+	}
+}
+
+func TestOAuth2_Unlocked(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"access_token": "token"}`))
+	}))
+	defer ts.Close()
+
+	auth := NewOAuth2(ts.URL, "client", "secret")
+	done := make(chan error)
+	go func() {
+		_, err := auth.Token()
+		done <- err
+	}()
+
+	// the token request is in progress, but the lock isn't held during it
+	time.Sleep(20 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		auth.mu.Lock()
+		auth.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be released during the token request")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOAuth2_Unauthorized(t *testing.T) {
+	// the first token is rejected, so it should be refreshed once
+	ts, issued, _ := newOAuth2Server(t, 3600, true)
+	defer ts.Close()
+
+	res, err := Get(ts.URL+"/api", &Options{Auth: NewOAuth2(ts.URL+"/token", "client", "secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf(tests.MismatchFormat, "status code", http.StatusOK, res.StatusCode)
+	}
+	if body := mustText(t, res); body != "Bearer token2" {
+		t.Fatalf(tests.MismatchFormat, "authorization", "Bearer token2", body)
+	}
+	if n := atomic.LoadInt32(issued); n != 2 {
+		t.Fatalf(tests.MismatchFormat, "issued tokens", 2, n)
+	}
+}
+
+func TestOAuth2_Error(t *testing.T) {
+	ts, _, _ := newOAuth2Server(t, 3600, false)
+	defer ts.Close()
+
+	_, err := Get(ts.URL+"/api", &Options{Auth: NewOAuth2(ts.URL+"/token", "client", "wrong")})
+	e, ok := err.(*OAuth2Error)
+	if !ok {
+		t.Fatalf("expected an OAuth2Error, but got %v", err)
+	}
+	if e.Code != "invalid_client" {
+		t.Fatalf(tests.MismatchFormat, "error code", "invalid_client", e.Code)
+	}
+}
+
+func mustText(t *testing.T, res *Response) string {
+	defer res.Close()
+	text, err := res.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/sleeyax/gotcha/internal/tests"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 )
@@ -118,3 +119,60 @@ func TestOptions_Merge_Bool(t *testing.T) {
 		}
 	}
 }
+
+func TestNewDefaultOptions_CookieJar(t *testing.T) {
+	jar := NewDefaultOptions().CookieJar
+
+	// a.example.co.uk should not be able to set cookies for co.uk
+	u, _ := url.Parse("https://a.example.co.uk")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "public", Value: "1", Domain: "co.uk"},
+		{Name: "private", Value: "2", Domain: "example.co.uk"},
+	})
+
+	u, _ = url.Parse("https://b.other.co.uk")
+	if cookies := jar.Cookies(u); len(cookies) != 0 {
+		t.Fatalf(tests.MismatchFormat, "number of cookies", 0, len(cookies))
+	}
+	u, _ = url.Parse("https://b.example.co.uk")
+	if cookies := jar.Cookies(u); len(cookies) != 1 || cookies[0].Name != "private" {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+}
+
+func TestOptions_OrderedHeaderKeys(t *testing.T) {
+	o := &Options{
+		Headers: http.Header{
+			"user-agent":   {"gotcha"},
+			"Accept":       {"*/*"},
+			"b":            {"1"},
+			"a":            {"2"},
+			HeaderOrderKey: {"b"},
+		},
+		HeaderOrder: []string{"accept", "User-Agent"},
+	}
+
+	expected := "Accept,user-agent,a,b"
+	if keys := strings.Join(o.OrderedHeaderKeys(), ","); keys != expected {
+		t.Fatalf(tests.MismatchFormat, "keys", expected, keys)
+	}
+
+	// falls back to the magic key
+	o.HeaderOrder = nil
+	expected = "b,Accept,a,user-agent"
+	if keys := strings.Join(o.OrderedHeaderKeys(), ","); keys != expected {
+		t.Fatalf(tests.MismatchFormat, "keys", expected, keys)
+	}
+}
+
+func TestAdapterCapabilities(t *testing.T) {
+	if c := AdapterCapabilities(&RawAdapter{}); !c.HeaderOrder || c.PseudoHeaderOrder {
+		t.Fatalf("unexpected capabilities %+v", c)
+	}
+	if c := AdapterCapabilities(&RequestAdapter{}); c.HeaderOrder {
+		t.Fatalf("unexpected capabilities %+v", c)
+	}
+	if c := AdapterCapabilities(&mockAdapter{}); c != (Capabilities{}) {
+		t.Fatalf("unexpected capabilities %+v", c)
+	}
+}
@@ -0,0 +1,132 @@
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var InvalidAkamaiFingerprintError = errors.New("invalid akamai fingerprint")
+
+// akamaiPseudoHeaders maps the abbreviations of an Akamai fingerprint to pseudo headers.
+var akamaiPseudoHeaders = map[string]string{
+	"m": ":method",
+	"a": ":authority",
+	"s": ":scheme",
+	"p": ":path",
+}
+
+// ParseAkamai parses an Akamai HTTP/2 fingerprint, e.g. "1:65536;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p",
+// into the HTTP/2 connection settings and pseudo header order it describes.
+// See https://www.blackhat.com/docs/eu-17/materials/eu-17-Shuster-Passive-Fingerprinting-Of-HTTP2-Clients-wp.pdf.
+//
+// Settings may be separated by either ';' or ','.
+// The priority of HEADERS frames isn't part of the fingerprint, so HTTP2.HeaderPriority is always nil.
+func ParseAkamai(fingerprint string) (HTTP2, []string, error) {
+	var h HTTP2
+
+	parts := strings.Split(fingerprint, "|")
+	if len(parts) != 4 {
+		return h, nil, fmt.Errorf("%w: expected 4 parts, got %d", InvalidAkamaiFingerprintError, len(parts))
+	}
+
+	if parts[0] != "" && parts[0] != "0" {
+		for _, s := range strings.FieldsFunc(parts[0], func(r rune) bool { return r == ';' || r == ',' }) {
+			values, err := parseAkamaiValues(s, 2)
+			if err != nil || values[0] > 0xffff {
+				return h, nil, fmt.Errorf("%w: invalid setting '%s'", InvalidAkamaiFingerprintError, s)
+			}
+			h.Settings = append(h.Settings, HTTP2Setting{ID: uint16(values[0]), Val: values[1]})
+		}
+	}
+
+	flow, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return h, nil, fmt.Errorf("%w: invalid window update '%s'", InvalidAkamaiFingerprintError, parts[1])
+	}
+	h.ConnectionFlow = uint32(flow)
+
+	if parts[2] != "" && parts[2] != "0" {
+		for _, s := range strings.Split(parts[2], ",") {
+			// stream ID:exclusive:stream dependency:weight
+			values, err := parseAkamaiValues(s, 4)
+			if err != nil || values[1] > 1 || values[3] < 1 || values[3] > 256 {
+				return h, nil, fmt.Errorf("%w: invalid priority frame '%s'", InvalidAkamaiFingerprintError, s)
+			}
+			h.PriorityFrames = append(h.PriorityFrames, HTTP2PriorityFrame{
+				StreamID: values[0],
+				HTTP2Priority: HTTP2Priority{
+					StreamDep: values[2],
+					Exclusive: values[1] == 1,
+					Weight:    uint8(values[3] - 1),
+				},
+			})
+		}
+	}
+
+	var pseudoHeaderOrder []string
+	if parts[3] != "" {
+		for _, s := range strings.Split(parts[3], ",") {
+			header, ok := akamaiPseudoHeaders[s]
+			if !ok {
+				return h, nil, fmt.Errorf("%w: unknown pseudo header '%s'", InvalidAkamaiFingerprintError, s)
+			}
+			pseudoHeaderOrder = append(pseudoHeaderOrder, header)
+		}
+	}
+
+	return h, pseudoHeaderOrder, nil
+}
+
+// Akamai returns the Akamai HTTP/2 fingerprint of the profile.
+// See ParseAkamai.
+func (p *Profile) Akamai() string {
+	var settings []string
+	for _, s := range p.HTTP2.Settings {
+		settings = append(settings, fmt.Sprintf("%d:%d", s.ID, s.Val))
+	}
+
+	priorities := []string{"0"}
+	if len(p.HTTP2.PriorityFrames) != 0 {
+		priorities = nil
+		for _, f := range p.HTTP2.PriorityFrames {
+			exclusive := 0
+			if f.Exclusive {
+				exclusive = 1
+			}
+			priorities = append(priorities, fmt.Sprintf("%d:%d:%d:%d", f.StreamID, exclusive, f.StreamDep, int(f.Weight)+1))
+		}
+	}
+
+	var pseudoHeaders []string
+	for _, header := range p.PseudoHeaderOrder {
+		pseudoHeaders = append(pseudoHeaders, header[1:2])
+	}
+
+	return strings.Join([]string{
+		strings.Join(settings, ";"),
+		strconv.FormatUint(uint64(p.HTTP2.ConnectionFlow), 10),
+		strings.Join(priorities, ","),
+		strings.Join(pseudoHeaders, ","),
+	}, "|")
+}
+
+// parseAkamaiValues parses n colon separated numbers.
+func parseAkamaiValues(s string, n int) ([]uint32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != n {
+		return nil, InvalidAkamaiFingerprintError
+	}
+
+	values := make([]uint32, n)
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = uint32(v)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,284 @@
+package profiles
+
+import (
+	"net/http"
+)
+
+var (
+	Chrome72Windows = chrome("72", "72.0.3626.121", Windows, "")
+	Chrome83Windows = chrome("83", "83.0.4103.116", Windows, "")
+	Chrome83MacOS   = chrome("83", "83.0.4103.116", MacOS, "")
+	Chrome83Linux   = chrome("83", "83.0.4103.116", Linux, "")
+	Chrome83Android = chrome("83", "83.0.4103.106", Android, "")
+
+	Chrome131Windows = chrome("131", "131.0.0.0", Windows, chrome131CHUA)
+	Chrome131MacOS   = chrome("131", "131.0.0.0", MacOS, chrome131CHUA)
+	Chrome131Linux   = chrome("131", "131.0.0.0", Linux, chrome131CHUA)
+	Chrome131Android = chrome("131", "131.0.0.0", Android, chrome131CHUA)
+
+	Edge83Windows = edge("83", "83.0.4103.97", "83.0.478.45", Windows, "")
+	Edge83MacOS   = edge("83", "83.0.4103.97", "83.0.478.45", MacOS, "")
+
+	Edge131Windows = edge("131", "131.0.0.0", "131.0.0.0", Windows, edge131CHUA)
+	Edge131MacOS   = edge("131", "131.0.0.0", "131.0.0.0", MacOS, edge131CHUA)
+
+	Firefox63Windows = firefox("63", Windows)
+	Firefox65Windows = firefox("65", Windows)
+	Firefox65MacOS   = firefox("65", MacOS)
+	Firefox65Linux   = firefox("65", Linux)
+
+	Safari12IOS = safari("12.1", "12_1", "12.0", IOS)
+)
+
+// latestChromeClientHello is the version of the latest Chrome ClientHello preset of utls.
+const latestChromeClientHello = "83"
+
+// sec-ch-ua client hints of Chromium based browsers.
+const (
+	chrome131CHUA = `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`
+	edge131CHUA   = `"Microsoft Edge";v="131", "Chromium";v="131", "Not_A Brand";v="24"`
+)
+
+var profiles = []*Profile{
+	Chrome72Windows,
+	Chrome83Windows,
+	Chrome83MacOS,
+	Chrome83Linux,
+	Chrome83Android,
+	Chrome131Windows,
+	Chrome131MacOS,
+	Chrome131Linux,
+	Chrome131Android,
+	Edge83Windows,
+	Edge83MacOS,
+	Edge131Windows,
+	Edge131MacOS,
+	Firefox63Windows,
+	Firefox65Windows,
+	Firefox65MacOS,
+	Firefox65Linux,
+	Safari12IOS,
+}
+
+// chromiumPlatforms are the platform tokens of the User-Agent of Chromium based browsers.
+var chromiumPlatforms = map[string]string{
+	Windows: "Windows NT 10.0; Win64; x64",
+	MacOS:   "Macintosh; Intel Mac OS X 10_15_5",
+	Linux:   "X11; Linux x86_64",
+	Android: "Linux; Android 10; SM-G973F",
+}
+
+// chromiumReducedPlatforms are the frozen platform tokens of the reduced User-Agent, which Chromium sends since version 110.
+var chromiumReducedPlatforms = map[string]string{
+	Windows: "Windows NT 10.0; Win64; x64",
+	MacOS:   "Macintosh; Intel Mac OS X 10_15_7",
+	Linux:   "X11; Linux x86_64",
+	Android: "Linux; Android 10; K",
+}
+
+// chromiumPlatformHints are the values of the sec-ch-ua-platform client hint.
+var chromiumPlatformHints = map[string]string{
+	Windows: `"Windows"`,
+	MacOS:   `"macOS"`,
+	Linux:   `"Linux"`,
+	Android: `"Android"`,
+}
+
+// firefoxPlatforms are the platform tokens of the User-Agent of Firefox, without the rv: token.
+var firefoxPlatforms = map[string]string{
+	Windows: "Windows NT 10.0; Win64; x64",
+	MacOS:   "Macintosh; Intel Mac OS X 10.14",
+	Linux:   "X11; Linux x86_64",
+}
+
+// chromiumHTTP2 is the HTTP/2 fingerprint of Chromium based browsers.
+var chromiumHTTP2 = HTTP2{
+	Settings: []HTTP2Setting{
+		{SettingHeaderTableSize, 65536},
+		{SettingMaxConcurrentStreams, 1000},
+		{SettingInitialWindowSize, 6291456},
+		{SettingMaxHeaderListSize, 262144},
+	},
+	ConnectionFlow: 15663105,
+	HeaderPriority: &HTTP2Priority{StreamDep: 0, Exclusive: true, Weight: 255},
+}
+
+// chromium124HTTP2 is the HTTP/2 fingerprint of Chromium based browsers since version 124,
+// which disables server push.
+var chromium124HTTP2 = HTTP2{
+	Settings: []HTTP2Setting{
+		{SettingHeaderTableSize, 65536},
+		{SettingEnablePush, 0},
+		{SettingInitialWindowSize, 6291456},
+		{SettingMaxHeaderListSize, 262144},
+	},
+	ConnectionFlow: 15663105,
+	HeaderPriority: &HTTP2Priority{StreamDep: 0, Exclusive: true, Weight: 255},
+}
+
+// chrome returns the profile of Google Chrome.
+// secCHUA is the value of the sec-ch-ua client hint, which isn't sent when empty.
+func chrome(version string, fullVersion string, os string, secCHUA string) *Profile {
+	return chromium(Chrome, version, os, chromiumUserAgent(version, fullVersion, os), secCHUA)
+}
+
+// edge returns the profile of Microsoft Edge, which is based on Chromium.
+func edge(version string, chromeVersion string, fullVersion string, os string, secCHUA string) *Profile {
+	return chromium(Edge, version, os, chromiumUserAgent(version, chromeVersion, os)+" Edg/"+fullVersion, secCHUA)
+}
+
+func chromiumUserAgent(version string, chromeVersion string, os string) string {
+	platform := chromiumPlatforms[os]
+	if compareVersions(version, "110") >= 0 {
+		platform = chromiumReducedPlatforms[os]
+	}
+	mobile := ""
+	if os == Android {
+		mobile = "Mobile "
+	}
+	return "Mozilla/5.0 (" + platform + ") AppleWebKit/537.36 (KHTML, like Gecko) Chrome/" + chromeVersion + " " + mobile + "Safari/537.36"
+}
+
+func chromium(browser string, version string, os string, userAgent string, secCHUA string) *Profile {
+	headers := http.Header{
+		"Upgrade-Insecure-Requests": {"1"},
+		"User-Agent":                {userAgent},
+		"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9"},
+		"Sec-Fetch-Site":            {"none"},
+		"Sec-Fetch-Mode":            {"navigate"},
+		"Sec-Fetch-User":            {"?1"},
+		"Sec-Fetch-Dest":            {"document"},
+		"Accept-Encoding":           {"gzip, deflate, br"},
+		"Accept-Language":           {"en-US,en;q=0.9"},
+	}
+	h2 := chromiumHTTP2
+
+	// the navigation headers of version 124 and later, which accept zstd compression and send a priority
+	if compareVersions(version, "124") >= 0 {
+		headers["Accept"] = []string{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"}
+		headers["Accept-Encoding"] = []string{"gzip, deflate, br, zstd"}
+		headers["Priority"] = []string{"u=0, i"}
+		h2 = chromium124HTTP2
+	}
+
+	// utls has no ClientHello presets of versions after 83, which is the closest one
+	clientHello := ClientHello{"Chrome", version}
+	if compareVersions(version, latestChromeClientHello) > 0 {
+		clientHello.Version = latestChromeClientHello
+	}
+
+	var order []string
+	if secCHUA != "" {
+		mobile := "?0"
+		if os == Android {
+			mobile = "?1"
+		}
+		headers["Sec-Ch-Ua"] = []string{secCHUA}
+		headers["Sec-Ch-Ua-Mobile"] = []string{mobile}
+		headers["Sec-Ch-Ua-Platform"] = []string{chromiumPlatformHints[os]}
+		order = []string{"Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Sec-Ch-Ua-Platform"}
+	}
+
+	return &Profile{
+		Browser: browser,
+		Version: version,
+		OS:      os,
+		Headers: headers,
+		HeaderOrder: append([]string{"Host", "Connection"}, append(order,
+			"Upgrade-Insecure-Requests",
+			"User-Agent",
+			"Accept",
+			"Sec-Fetch-Site",
+			"Sec-Fetch-Mode",
+			"Sec-Fetch-User",
+			"Sec-Fetch-Dest",
+			"Accept-Encoding",
+			"Accept-Language",
+			"Cookie",
+			"Priority",
+		)...),
+		PseudoHeaderOrder: []string{":method", ":authority", ":scheme", ":path"},
+		ClientHello:       clientHello,
+		HTTP2:             h2,
+	}
+}
+
+// firefox returns the profile of Mozilla Firefox.
+func firefox(version string, os string) *Profile {
+	return &Profile{
+		Browser: Firefox,
+		Version: version,
+		OS:      os,
+		Headers: http.Header{
+			"User-Agent":                {"Mozilla/5.0 (" + firefoxPlatforms[os] + "; rv:" + version + ".0) Gecko/20100101 Firefox/" + version + ".0"},
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.5"},
+			"Accept-Encoding":           {"gzip, deflate, br"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+		HeaderOrder: []string{
+			"Host",
+			"User-Agent",
+			"Accept",
+			"Accept-Language",
+			"Accept-Encoding",
+			"Connection",
+			"Cookie",
+			"Upgrade-Insecure-Requests",
+		},
+		PseudoHeaderOrder: []string{":method", ":path", ":authority", ":scheme"},
+		ClientHello:       ClientHello{"Firefox", version},
+		HTTP2: HTTP2{
+			Settings: []HTTP2Setting{
+				{SettingHeaderTableSize, 65536},
+				{SettingInitialWindowSize, 131072},
+				{SettingMaxFrameSize, 16384},
+			},
+			ConnectionFlow: 12517377,
+			// Firefox builds a tree of idle streams to group requests by priority
+			PriorityFrames: []HTTP2PriorityFrame{
+				{3, HTTP2Priority{StreamDep: 0, Exclusive: false, Weight: 200}},
+				{5, HTTP2Priority{StreamDep: 0, Exclusive: false, Weight: 100}},
+				{7, HTTP2Priority{StreamDep: 0, Exclusive: false, Weight: 0}},
+				{9, HTTP2Priority{StreamDep: 7, Exclusive: false, Weight: 0}},
+				{11, HTTP2Priority{StreamDep: 3, Exclusive: false, Weight: 0}},
+				{13, HTTP2Priority{StreamDep: 0, Exclusive: false, Weight: 240}},
+			},
+			HeaderPriority: &HTTP2Priority{StreamDep: 13, Exclusive: false, Weight: 41},
+		},
+	}
+}
+
+// safari returns the profile of Safari on iOS.
+// osVersion is the iOS version as it appears in the User-Agent, e.g. "12_1".
+func safari(version string, osVersion string, safariVersion string, os string) *Profile {
+	return &Profile{
+		Browser: Safari,
+		Version: version,
+		OS:      os,
+		Headers: http.Header{
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"User-Agent":      {"Mozilla/5.0 (iPhone; CPU iPhone OS " + osVersion + " like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/" + safariVersion + " Mobile/15E148 Safari/604.1"},
+			"Accept-Language": {"en-us"},
+			"Accept-Encoding": {"br, gzip, deflate"},
+		},
+		HeaderOrder: []string{
+			"Host",
+			"Accept",
+			"Cookie",
+			"User-Agent",
+			"Accept-Language",
+			"Accept-Encoding",
+			"Connection",
+		},
+		PseudoHeaderOrder: []string{":method", ":scheme", ":path", ":authority"},
+		ClientHello:       ClientHello{"iOS", version},
+		HTTP2: HTTP2{
+			Settings: []HTTP2Setting{
+				{SettingInitialWindowSize, 2097152},
+				{SettingMaxConcurrentStreams, 100},
+			},
+			ConnectionFlow: 10485760,
+		},
+	}
+}
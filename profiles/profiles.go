@@ -0,0 +1,237 @@
+// Package profiles contains the identities of real browsers, so requests can impersonate them consistently.
+//
+// A Profile bundles the default headers of a browser version on an operating system (User-Agent, client hints, Accept-Language...),
+// the order in which it sends them and its TLS and HTTP/2 fingerprints,
+// so that none of them contradict each other.
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sleeyax/gotcha"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Browsers.
+const (
+	Chrome  = "chrome"
+	Edge    = "edge"
+	Firefox = "firefox"
+	Safari  = "safari"
+)
+
+// Operating systems.
+const (
+	Windows = "windows"
+	MacOS   = "macos"
+	Linux   = "linux"
+	Android = "android"
+	IOS     = "ios"
+)
+
+// HTTP/2 setting identifiers, see https://httpwg.org/specs/rfc7540.html#SettingValues.
+const (
+	SettingHeaderTableSize      uint16 = 0x1
+	SettingEnablePush           uint16 = 0x2
+	SettingMaxConcurrentStreams uint16 = 0x3
+	SettingInitialWindowSize    uint16 = 0x4
+	SettingMaxFrameSize         uint16 = 0x5
+	SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+var ProfileNotFoundError = errors.New("profile not found")
+
+// Profile is the identity of a browser version on an operating system.
+//
+// Note that Headers advertise the compression algorithms the browser supports,
+// so responses may have to be decompressed depending on their Content-Encoding.
+type Profile struct {
+	// Browser, one of Chrome, Edge, Firefox or Safari.
+	Browser string
+
+	// Version of the browser, e.g. "83" or "12.1".
+	Version string
+
+	// Operating system, one of Windows, MacOS, Linux, Android or IOS.
+	OS string
+
+	// Headers the browser sends when navigating to a page.
+	Headers http.Header
+
+	// Order in which the browser sends headers, see gotcha.Options.HeaderOrder.
+	HeaderOrder []string
+
+	// Order in which the browser sends HTTP/2 pseudo headers, see gotcha.Options.PseudoHeaderOrder.
+	PseudoHeaderOrder []string
+
+	// TLS ClientHello the browser sends.
+	ClientHello ClientHello
+
+	// HTTP/2 connection settings of the browser.
+	HTTP2 HTTP2
+}
+
+// ClientHello identifies a TLS ClientHello by the client and version that send it.
+// The values match the fields of the same name of a utls ClientHelloID.
+type ClientHello struct {
+	Client  string
+	Version string
+}
+
+// HTTP2 describes how a browser sets up HTTP/2 connections and streams.
+type HTTP2 struct {
+	// Settings sent in the initial SETTINGS frame, in order.
+	Settings []HTTP2Setting
+
+	// Window size increment of the WINDOW_UPDATE frame sent for the connection after the SETTINGS frame.
+	// No WINDOW_UPDATE frame is sent when 0.
+	ConnectionFlow uint32
+
+	// PRIORITY frames sent after the connection preface, in order.
+	PriorityFrames []HTTP2PriorityFrame
+
+	// Priority sent in the HEADERS frame of every request.
+	// No priority is sent when nil.
+	HeaderPriority *HTTP2Priority
+}
+
+// HTTP2Setting is a setting of a SETTINGS frame.
+type HTTP2Setting struct {
+	ID  uint16
+	Val uint32
+}
+
+// HTTP2Priority is a stream priority.
+type HTTP2Priority struct {
+	// Stream this stream depends on, 0 for none.
+	StreamDep uint32
+
+	// Whether the dependency is exclusive.
+	Exclusive bool
+
+	// Weight as sent on the wire, which is one less than the actual weight (1 to 256).
+	Weight uint8
+}
+
+// HTTP2PriorityFrame is a PRIORITY frame.
+type HTTP2PriorityFrame struct {
+	StreamID uint32
+	HTTP2Priority
+}
+
+// Adapter is implemented by adapters that can impersonate the fingerprints of a Profile.
+type Adapter interface {
+	gotcha.Adapter
+
+	// ApplyProfile configures the adapter to use the fingerprints of p it supports.
+	// Adapters document which of the TLS and HTTP/2 fingerprint that is,
+	// the other one is left unchanged and doesn't match p.
+	// An error is returned when a supported fingerprint of p can't be used, e.g. because its ClientHello is unknown.
+	ApplyProfile(p *Profile) error
+}
+
+// String returns the name of the profile, e.g. "chrome 83 (windows)".
+func (p *Profile) String() string {
+	return fmt.Sprintf("%s %s (%s)", p.Browser, p.Version, p.OS)
+}
+
+// Apply sets the headers, header order and pseudo header order of p on o.
+// Headers that are already set in o are kept.
+func (p *Profile) Apply(o *gotcha.Options) {
+	if o.Headers == nil {
+		o.Headers = make(http.Header)
+	}
+	for key, values := range p.Headers {
+		if !hasHeader(o.Headers, key) {
+			o.Headers[key] = append([]string{}, values...)
+		}
+	}
+	o.HeaderOrder = append([]string{}, p.HeaderOrder...)
+	o.PseudoHeaderOrder = append([]string{}, p.PseudoHeaderOrder...)
+}
+
+// Options configures adapter with the fingerprints of p and returns Options that use it,
+// together with the headers of p.
+func (p *Profile) Options(adapter Adapter) (*gotcha.Options, error) {
+	if err := adapter.ApplyProfile(p); err != nil {
+		return nil, err
+	}
+
+	o := &gotcha.Options{Adapter: adapter}
+	p.Apply(o)
+
+	return o, nil
+}
+
+// All returns all profiles, sorted by browser, OS and version.
+func All() []*Profile {
+	all := append([]*Profile{}, profiles...)
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Browser != b.Browser {
+			return a.Browser < b.Browser
+		}
+		if a.OS != b.OS {
+			return a.OS < b.OS
+		}
+		return compareVersions(a.Version, b.Version) < 0
+	})
+	return all
+}
+
+// Find returns the profile of browser on os.
+// The latest version is returned when version is empty.
+func Find(browser string, version string, os string) (*Profile, error) {
+	var found *Profile
+	for _, p := range profiles {
+		if !strings.EqualFold(p.Browser, browser) || !strings.EqualFold(p.OS, os) {
+			continue
+		}
+		if version == "" {
+			if found == nil || compareVersions(p.Version, found.Version) > 0 {
+				found = p
+			}
+		} else if p.Version == version {
+			return p, nil
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s %s (%s)", ProfileNotFoundError, browser, version, os)
+	}
+
+	return found, nil
+}
+
+// hasHeader reports whether h contains key, ignoring case.
+func hasHeader(h http.Header, key string) bool {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares the dot separated versions a and b numerically.
+func compareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			fmt.Sscan(as[i], &x)
+		}
+		if i < len(bs) {
+			fmt.Sscan(bs[i], &y)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
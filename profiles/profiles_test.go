@@ -0,0 +1,201 @@
+package profiles
+
+import (
+	"errors"
+	"github.com/sleeyax/gotcha"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mockAdapter struct {
+	profile *Profile
+	err     error
+}
+
+func (ma *mockAdapter) DoRequest(*gotcha.Options) (*gotcha.Response, error) {
+	return nil, nil
+}
+
+func (ma *mockAdapter) ApplyProfile(p *Profile) error {
+	ma.profile = p
+	return ma.err
+}
+
+func TestProfiles_Consistent(t *testing.T) {
+	for _, p := range All() {
+		ua := p.Headers.Get("User-Agent")
+
+		major := strings.Split(p.Version, ".")[0]
+		var token string
+		switch p.Browser {
+		case Chrome:
+			token = "Chrome/" + major + "."
+		case Edge:
+			token = "Edg/" + major + "."
+		case Firefox:
+			token = "Firefox/" + major + ".0"
+		case Safari:
+			token = "OS " + strings.Replace(p.Version, ".", "_", -1) + " like Mac OS X"
+		}
+		if !strings.Contains(ua, token) {
+			t.Errorf("%s: User-Agent %q doesn't contain %q", p, ua, token)
+		}
+
+		if mobile := strings.Contains(ua, "Mobile"); mobile != (p.OS == Android || p.OS == IOS) {
+			t.Errorf("%s: unexpected User-Agent %q", p, ua)
+		}
+
+		if hint := p.Headers.Get("Sec-Ch-Ua"); hint != "" && p.Browser != Chrome && p.Browser != Edge {
+			t.Errorf("%s: unexpected client hint %q", p, hint)
+		}
+
+		if p.ClientHello.Client == "" || len(p.HTTP2.Settings) == 0 || len(p.PseudoHeaderOrder) != 4 {
+			t.Errorf("%s: incomplete fingerprint", p)
+		}
+
+		for key := range p.Headers {
+			if !containsFold(p.HeaderOrder, key) {
+				t.Errorf("%s: header %s is missing from the header order", p, key)
+			}
+		}
+	}
+}
+
+func TestChromium_ClientHints(t *testing.T) {
+	p := chrome("100", "100.0.4896.127", Android, `" Not A;Brand";v="99", "Chromium";v="100", "Google Chrome";v="100"`)
+
+	keys := (&gotcha.Options{Headers: p.Headers, HeaderOrder: p.HeaderOrder}).OrderedHeaderKeys()
+	expected := "Sec-Ch-Ua,Sec-Ch-Ua-Mobile,Sec-Ch-Ua-Platform,Upgrade-Insecure-Requests,User-Agent"
+	if s := strings.Join(keys[:5], ","); s != expected {
+		t.Fatalf(tests.MismatchFormat, "header order", expected, s)
+	}
+	if mobile, platform := p.Headers.Get("Sec-Ch-Ua-Mobile"), p.Headers.Get("Sec-Ch-Ua-Platform"); mobile != "?1" || platform != `"Android"` {
+		t.Fatalf("unexpected client hints %s %s", mobile, platform)
+	}
+}
+
+func TestChromium131(t *testing.T) {
+	for _, test := range []struct {
+		profile   *Profile
+		secCHUA   string
+		mobile    string
+		platform  string
+		userAgent string
+	}{
+		{Chrome131Windows, `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`, "?0", `"Windows"`, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36"},
+		{Chrome131MacOS, `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`, "?0", `"macOS"`, "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36"},
+		{Chrome131Android, `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`, "?1", `"Android"`, "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Mobile Safari/537.36"},
+		{Edge131Windows, `"Microsoft Edge";v="131", "Chromium";v="131", "Not_A Brand";v="24"`, "?0", `"Windows"`, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36 Edg/131.0.0.0"},
+	} {
+		h := test.profile.Headers
+		if got := h.Get("Sec-Ch-Ua"); got != test.secCHUA {
+			t.Fatalf(tests.MismatchFormat, test.profile.String()+" sec-ch-ua", test.secCHUA, got)
+		}
+		if got := h.Get("Sec-Ch-Ua-Mobile"); got != test.mobile {
+			t.Fatalf(tests.MismatchFormat, test.profile.String()+" sec-ch-ua-mobile", test.mobile, got)
+		}
+		if got := h.Get("Sec-Ch-Ua-Platform"); got != test.platform {
+			t.Fatalf(tests.MismatchFormat, test.profile.String()+" sec-ch-ua-platform", test.platform, got)
+		}
+		if got := h.Get("User-Agent"); got != test.userAgent {
+			t.Fatalf(tests.MismatchFormat, test.profile.String()+" User-Agent", test.userAgent, got)
+		}
+		if got := test.profile.ClientHello; got != (ClientHello{"Chrome", "83"}) {
+			t.Fatalf(tests.MismatchFormat, test.profile.String()+" ClientHello", ClientHello{"Chrome", "83"}, got)
+		}
+	}
+
+	const akamai = "1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p"
+	if fp := Chrome131Windows.Akamai(); fp != akamai {
+		t.Fatalf(tests.MismatchFormat, "fingerprint", akamai, fp)
+	}
+	if p, _ := Find(Chrome, "", Windows); p != Chrome131Windows {
+		t.Fatalf(tests.MismatchFormat, "latest profile", Chrome131Windows, p)
+	}
+}
+
+func TestFind(t *testing.T) {
+	p, err := Find("Firefox", "", Windows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != Firefox65Windows {
+		t.Fatalf(tests.MismatchFormat, "profile", Firefox65Windows, p)
+	}
+
+	if p, _ = Find(Chrome, "72", Windows); p != Chrome72Windows {
+		t.Fatalf(tests.MismatchFormat, "profile", Chrome72Windows, p)
+	}
+
+	if _, err = Find(Safari, "", Windows); !errors.Is(err, ProfileNotFoundError) {
+		t.Fatalf(tests.MismatchFormat, "error", ProfileNotFoundError, err)
+	}
+}
+
+func TestProfile_Options(t *testing.T) {
+	adapter := &mockAdapter{}
+	o, err := Chrome83Windows.Options(adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.profile != Chrome83Windows || o.Adapter != adapter {
+		t.Fatal("expected the adapter to be configured")
+	}
+	if ua := o.Headers.Get("User-Agent"); ua != Chrome83Windows.Headers.Get("User-Agent") {
+		t.Fatalf(tests.MismatchFormat, "User-Agent", Chrome83Windows.Headers.Get("User-Agent"), ua)
+	}
+
+	// headers that are already set are kept
+	o = &gotcha.Options{Headers: http.Header{"user-agent": {"custom"}}}
+	Chrome83Windows.Apply(o)
+	if len(o.Headers["user-agent"]) != 1 || len(o.Headers["User-Agent"]) != 0 {
+		t.Fatalf("unexpected headers %v", o.Headers)
+	}
+
+	adapter.err = errors.New("unsupported")
+	if _, err = Chrome83Windows.Options(adapter); err != adapter.err {
+		t.Fatalf(tests.MismatchFormat, "error", adapter.err, err)
+	}
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseAkamai(t *testing.T) {
+	const firefox = "1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s"
+	if fp := Firefox65Windows.Akamai(); fp != firefox {
+		t.Fatalf(tests.MismatchFormat, "fingerprint", firefox, fp)
+	}
+
+	for _, p := range All() {
+		h, order, err := ParseAkamai(p.Akamai())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := p.HTTP2
+		expected.HeaderPriority = nil
+		if !reflect.DeepEqual(h, expected) || !reflect.DeepEqual(order, p.PseudoHeaderOrder) {
+			t.Errorf("%s: unexpected result %+v %v", p, h, order)
+		}
+	}
+
+	// settings separated by commas
+	if h, _, err := ParseAkamai("1:65536,3:1000|0|0|m,a,s,p"); err != nil || len(h.Settings) != 2 {
+		t.Fatalf("unexpected result %+v %v", h, err)
+	}
+
+	for _, fp := range []string{"1:65536|0|0", "1:65536|x|0|m,a,s,p", "1:65536|0|3:2:0:201|m,a,s,p", "1:65536|0|3:0:0:0|m,a,s,p", "1|0|0|m,a,s,p", "1:65536|0|0|m,x"} {
+		if _, _, err := ParseAkamai(fp); !errors.Is(err, InvalidAkamaiFingerprintError) {
+			t.Errorf("%s: expected error '%v', but got '%v' instead", fp, InvalidAkamaiFingerprintError, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package gotcha
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProxyDialTLSError is returned by the RequestAdapter for requests through a SOCKS proxy
+// when its transport has a custom DialTLS or DialTLSContext function, since these would connect to the server directly.
+var ProxyDialTLSError = errors.New("DialTLS and DialTLSContext can't dial through a SOCKS proxy")
+
+// ProxyDialer connects to addresses through a proxy.
+// It's meant for adapters that dial their own connections, e.g. to perform the TLS handshake with a different TLS library.
+type ProxyDialer struct {
+	// Proxy URL with the http, https, socks5, socks5h or socks4a scheme.
+	// Connections are tunneled through HTTP proxies using the CONNECT method.
+	// Host names are resolved by socks5h and socks4a proxies, and before connecting to socks5 proxies.
+	// The username and password of the URL are used to authenticate with the proxy, SOCKS4a proxies only receive the username.
+	//
+	// Addresses are dialed directly when Proxy is nil.
+	Proxy *url.URL
+
+	// Dial connects to the address on the named network.
+	//
+	// Defaults to net.Dialer.DialContext.
+	Dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	// TLSConfig is the tls.Config used to connect to HTTPS proxies.
+	TLSConfig *tls.Config
+}
+
+// DialContext connects to addr on the named network through Proxy.
+func (d *ProxyDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	dial := d.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	if d.Proxy == nil {
+		return dial(ctx, network, addr)
+	}
+
+	conn, err := dialProxy(ctx, dial, d.Proxy, d.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	switch d.Proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5(ctx, conn, addr, d.Proxy.User, d.Proxy.Scheme == "socks5h")
+	case "socks4a":
+		err = socks4a(ctx, conn, addr, d.Proxy.User)
+	default:
+		err = connect(ctx, conn, addr, d.Proxy)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// dialProxy connects to proxy, using tlsConfig for HTTPS proxies.
+func dialProxy(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), proxy *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
+	if !supportedProxyScheme(proxy.Scheme) {
+		return nil, fmt.Errorf("gotcha: unsupported proxy scheme %q", proxy.Scheme)
+	}
+
+	conn, err := dial(ctx, "tcp", canonicalAddr(proxy))
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy.Scheme == "https" {
+		tc, err := tlsClient(ctx, conn, proxy.Hostname(), tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	return conn, nil
+}
+
+// supportedProxyScheme reports whether ProxyDialer can connect through proxies with scheme.
+func supportedProxyScheme(scheme string) bool {
+	switch scheme {
+	case "http", "https", "socks5", "socks5h", "socks4a":
+		return true
+	}
+	return false
+}
+
+// isHTTPProxy reports whether proxy is an HTTP proxy, which can forward plain HTTP requests itself.
+func isHTTPProxy(proxy *url.URL) bool {
+	return proxy.Scheme == "http" || proxy.Scheme == "https"
+}
+
+// connect establishes a tunnel to addr through an HTTP proxy using the CONNECT method.
+func connect(ctx context.Context, conn net.Conn, addr string, proxy *url.URL) error {
+	return withDeadline(ctx, conn, func() error {
+		req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+		if proxy.User != nil {
+			req += "Proxy-Authorization: " + basicAuth(proxy.User) + "\r\n"
+		}
+		if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+			return err
+		}
+
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			return err
+		}
+
+		// the body of a successful response is the tunnel itself
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return fmt.Errorf("gotcha: proxy responded with %s", res.Status)
+		}
+		if br.Buffered() != 0 {
+			return errors.New("gotcha: unexpected data after proxy response")
+		}
+
+		return nil
+	})
+}
+
+// basicAuth returns the value of a basic authorization header for user.
+func basicAuth(user *url.Userinfo) string {
+	password, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password))
+}
@@ -0,0 +1,197 @@
+package gotcha
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newProxy starts an HTTP proxy that tunnels CONNECT requests and responds to all other requests itself.
+// The Proxy-Authorization header of every request is appended to auth.
+func newProxy(auth *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*auth = append(*auth, r.Header.Get("Proxy-Authorization"))
+
+		if r.Method != http.MethodConnect {
+			w.Write([]byte("forwarded " + r.URL.String()))
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+}
+
+// newEcho starts a server that echoes a single message of 5 bytes per connection.
+func newEcho(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b := make([]byte, 5)
+			io.ReadFull(conn, b)
+			conn.Write(b)
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+// echo sends hello over conn and fails if it isn't echoed.
+func echo(t *testing.T, conn net.Conn) {
+	io.WriteString(conn, "hello")
+	b, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf(tests.MismatchFormat, "tunneled data", "hello", string(b))
+	}
+}
+
+func TestProxyDialer(t *testing.T) {
+	ln := newEcho(t)
+	defer ln.Close()
+
+	var auth []string
+	proxy := newProxy(&auth)
+	defer proxy.Close()
+
+	proxyUrl, _ := url.Parse(proxy.URL)
+	proxyUrl.User = url.User("user")
+
+	conn, err := (&ProxyDialer{Proxy: proxyUrl}).DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+	if expected := basicAuth(proxyUrl.User); len(auth) != 1 || auth[0] != expected {
+		t.Fatalf(tests.MismatchFormat, "proxy authorization", expected, auth)
+	}
+
+	proxyUrl.Scheme = "ftp"
+	if _, err = (&ProxyDialer{Proxy: proxyUrl}).DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		t.Fatal("expected an error for an unsupported proxy scheme")
+	}
+}
+
+func TestProxyDialer_SOCKS(t *testing.T) {
+	ln := newEcho(t)
+	defer ln.Close()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	addr := net.JoinHostPort("localhost", port)
+
+	for _, test := range []struct {
+		scheme string
+		user   *url.Userinfo
+		// address the proxy is asked to connect to
+		requested string
+	}{
+		// host names are resolved locally
+		{"socks5", url.UserPassword("user", "pass"), ln.Addr().String()},
+		// host names are resolved by the proxy
+		{"socks5h", url.UserPassword("user", "pass"), addr},
+		{"socks4a", url.User("user"), addr},
+	} {
+		proxy := tests.NewSOCKSServer("user", "pass")
+		proxyUrl := &url.URL{Scheme: test.scheme, Host: proxy.Addr, User: test.user}
+
+		conn, err := (&ProxyDialer{Proxy: proxyUrl}).DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.scheme, err)
+		}
+		echo(t, conn)
+		if requests := proxy.Requests(); len(requests) != 1 || requests[0] != test.requested {
+			t.Fatalf(tests.MismatchFormat, test.scheme+" requested addresses", []string{test.requested}, requests)
+		}
+
+		proxyUrl.User = url.UserPassword("wrong", "wrong")
+		if _, err = (&ProxyDialer{Proxy: proxyUrl}).DialContext(context.Background(), "tcp", addr); err == nil {
+			t.Fatalf("%s: expected an error for invalid credentials", test.scheme)
+		}
+		proxy.Close()
+	}
+}
+
+func TestRequestAdapter_Proxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct"))
+	}))
+	defer target.Close()
+
+	var auth []string
+	httpProxy := newProxy(&auth)
+	defer httpProxy.Close()
+	socksProxy := tests.NewSOCKSServer("user", "pass")
+	defer socksProxy.Close()
+
+	httpProxyUrl, _ := url.Parse(httpProxy.URL)
+	client, err := NewClient(&Options{Adapter: &RequestAdapter{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		proxy    *url.URL
+		expected string
+	}{
+		{httpProxyUrl, "forwarded " + target.URL + "/"},
+		{&url.URL{Scheme: "socks5h", Host: socksProxy.Addr, User: url.UserPassword("user", "pass")}, "direct"},
+		{&url.URL{Scheme: "socks4a", Host: socksProxy.Addr, User: url.User("user")}, "direct"},
+		{nil, "direct"},
+	} {
+		res, err := client.Get(target.URL, &Options{Proxy: test.proxy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := mustText(t, res); text != test.expected {
+			t.Fatalf(tests.MismatchFormat, "body", test.expected, text)
+		}
+	}
+
+	if requests := socksProxy.Requests(); len(requests) != 2 {
+		t.Fatalf(tests.MismatchFormat, "SOCKS requests", 2, len(requests))
+	}
+
+	// a custom TLS dialer would bypass the SOCKS proxy
+	transport := &http.Transport{DialTLSContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return (&tls.Dialer{}).DialContext(ctx, network, addr)
+	}}
+	client, err = NewClient(&Options{Adapter: &RequestAdapter{RoundTripper: transport}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &url.URL{Scheme: "socks5h", Host: socksProxy.Addr, User: url.UserPassword("user", "pass")}
+	if _, err = client.Get("https://example.com", &Options{Proxy: proxy}); !errors.Is(err, ProxyDialTLSError) {
+		t.Fatalf(tests.MismatchFormat, "error", ProxyDialTLSError, err)
+	}
+	if requests := socksProxy.Requests(); len(requests) != 2 {
+		t.Fatalf(tests.MismatchFormat, "SOCKS requests", 2, len(requests))
+	}
+}
@@ -0,0 +1,295 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"github.com/sleeyax/gotcha/internal/utils"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var NoProxyAvailableError = errors.New("no proxy available")
+
+// ProxyStrategy decides which proxy of a ProxyPool is used for a request.
+type ProxyStrategy int
+
+const (
+	// ProxyRoundRobin uses the proxies in turn.
+	ProxyRoundRobin ProxyStrategy = iota
+
+	// ProxyRandom uses a random proxy.
+	ProxyRandom
+
+	// ProxyLeastUsed uses the proxy that was used for the fewest requests so far.
+	ProxyLeastUsed
+
+	// ProxySticky uses the same proxy for all requests with the same ProxyPool.SessionKey.
+	// Sessions are assigned a proxy round-robin, and a new one when their proxy is benched.
+	ProxySticky
+
+	// ProxyWeighted uses a random proxy, picked with a probability proportional to its weight.
+	ProxyWeighted
+)
+
+// ProxyPool picks the proxy of every request from a list of proxies and benches proxies that fail.
+//
+// A request fails because of its proxy when the Adapter returns an error or when the response status code is one of StatusCodes.
+// Such requests are retried on a different proxy when Options.Retry is enabled, following RetryOptions.Limit and RetryOptions.Methods.
+//
+// A ProxyPool is safe for concurrent use and should be shared between requests (e.g. by setting it on the Client).
+type ProxyPool struct {
+	// Strategy used to pick a proxy.
+	Strategy ProxyStrategy
+
+	// SessionKey returns the session a request belongs to, used by the ProxySticky Strategy.
+	// Requests without a session key are sent round-robin.
+	SessionKey func(o *Options) string
+
+	// Response status codes that indicate that the proxy is blocked, e.g. 403 or 429.
+	StatusCodes []int
+
+	// Amount of consecutive failures after which a proxy is benched.
+	// Values below 1 bench a proxy after its first failure.
+	MaxFailures int
+
+	// Duration a benched proxy isn't used.
+	CoolDown time.Duration
+
+	mu       sync.Mutex
+	proxies  []*pooledProxy
+	next     int
+	sessions map[string]*pooledProxy
+	rand     *rand.Rand
+}
+
+// ProxyStats describes the usage and health of a proxy in a ProxyPool.
+type ProxyStats struct {
+	Proxy  *url.URL
+	Weight int
+
+	// Amount of requests the proxy was used for.
+	Uses int
+
+	// Amount of requests that failed because of the proxy.
+	Failures int
+
+	// Time until which the proxy is benched, zero when it isn't benched.
+	BenchedUntil time.Time
+}
+
+// pooledProxy is a proxy of a ProxyPool with its usage.
+type pooledProxy struct {
+	url          *url.URL
+	weight       int
+	uses         int
+	failures     int
+	consecutive  int
+	benchedUntil time.Time
+}
+
+// NewProxyPool creates a ProxyPool that picks from proxies using strategy.
+// Proxies are benched for a minute after their first failure.
+func NewProxyPool(strategy ProxyStrategy, proxies ...*url.URL) *ProxyPool {
+	p := &ProxyPool{
+		Strategy:    strategy,
+		MaxFailures: 1,
+		CoolDown:    time.Minute,
+	}
+	for _, proxy := range proxies {
+		p.Add(proxy, 1)
+	}
+	return p
+}
+
+// Add adds proxy to the pool.
+// The weight is only used by the ProxyWeighted Strategy, proxies with a weight below 1 are never picked by it.
+func (p *ProxyPool) Add(proxy *url.URL, weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.proxies = append(p.proxies, &pooledProxy{url: proxy, weight: weight})
+}
+
+// Stats returns the ProxyStats of all proxies in the order they were added.
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]ProxyStats, len(p.proxies))
+	for i, pp := range p.proxies {
+		stats[i] = ProxyStats{Proxy: pp.url, Weight: pp.weight, Uses: pp.uses, Failures: pp.failures}
+		if pp.benchedUntil.After(now) {
+			stats[i].BenchedUntil = pp.benchedUntil
+		}
+	}
+	return stats
+}
+
+// pick sets o.Proxy to a copy of the proxy picked for the request.
+// The proxy that failed the previous attempt of the request is only picked when no other proxy is available.
+func (p *ProxyPool) pick(o *Options) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	failed := o.failedProxy
+	o.failedProxy = nil
+
+	var available []*pooledProxy
+	var fallback *pooledProxy
+	now := time.Now()
+	for _, pp := range p.proxies {
+		if pp.benchedUntil.After(now) {
+			continue
+		}
+		if pp == failed {
+			fallback = pp
+			continue
+		}
+		available = append(available, pp)
+	}
+	if len(available) == 0 && fallback != nil {
+		available = append(available, fallback)
+	}
+	if len(available) == 0 {
+		return NoProxyAvailableError
+	}
+
+	pp := p.choose(o, available, failed)
+	if pp == nil {
+		return NoProxyAvailableError
+	}
+	pp.uses++
+
+	// the copy keeps merging Options from modifying the proxies of the pool
+	proxy := *pp.url
+	o.Proxy = &proxy
+	o.pooledProxy = pp
+
+	return nil
+}
+
+// choose picks a proxy from available according to Strategy.
+func (p *ProxyPool) choose(o *Options, available []*pooledProxy, failed *pooledProxy) *pooledProxy {
+	switch p.Strategy {
+	case ProxyRandom:
+		return available[p.intn(len(available))]
+	case ProxyLeastUsed:
+		least := available[0]
+		for _, pp := range available[1:] {
+			if pp.uses < least.uses {
+				least = pp
+			}
+		}
+		return least
+	case ProxySticky:
+		var key string
+		if p.SessionKey != nil {
+			key = p.SessionKey(o)
+		}
+		if key == "" {
+			return p.roundRobin(available)
+		}
+		if pp, ok := p.sessions[key]; ok && pp != failed && containsProxy(available, pp) {
+			return pp
+		}
+		pp := p.roundRobin(available)
+		if p.sessions == nil {
+			p.sessions = make(map[string]*pooledProxy)
+		}
+		p.sessions[key] = pp
+		return pp
+	case ProxyWeighted:
+		var total int
+		for _, pp := range available {
+			if pp.weight > 0 {
+				total += pp.weight
+			}
+		}
+		if total == 0 {
+			return nil
+		}
+		n := p.intn(total)
+		for _, pp := range available {
+			if pp.weight <= 0 {
+				continue
+			}
+			if n < pp.weight {
+				return pp
+			}
+			n -= pp.weight
+		}
+		return nil
+	default:
+		return p.roundRobin(available)
+	}
+}
+
+// roundRobin picks the first proxy of available that comes after the proxy picked last time.
+func (p *ProxyPool) roundRobin(available []*pooledProxy) *pooledProxy {
+	for i := 0; i < len(p.proxies); i++ {
+		pp := p.proxies[(p.next+i)%len(p.proxies)]
+		if containsProxy(available, pp) {
+			p.next = (p.next + i + 1) % len(p.proxies)
+			return pp
+		}
+	}
+	return nil
+}
+
+// intn returns a random number in [0, n).
+func (p *ProxyPool) intn(n int) int {
+	if p.rand == nil {
+		p.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return p.rand.Intn(n)
+}
+
+// report records the outcome of a request through the proxy picked for o,
+// benching the proxy when it failed too many times in a row.
+// It reports whether the request failed because of the proxy.
+func (p *ProxyPool) report(o *Options, res *Response, err error) bool {
+	pp := o.pooledProxy
+	o.pooledProxy = nil
+	if pp == nil {
+		return false
+	}
+
+	var failed bool
+	if err != nil {
+		// the request was aborted by the caller, which says nothing about the proxy
+		failed = !errors.Is(err, context.Canceled)
+	} else {
+		failed = utils.IntArrayContains(p.StatusCodes, res.StatusCode)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !failed {
+		pp.consecutive = 0
+		return false
+	}
+
+	pp.failures++
+	pp.consecutive++
+	if pp.consecutive >= p.MaxFailures {
+		pp.consecutive = 0
+		pp.benchedUntil = time.Now().Add(p.CoolDown)
+	}
+	o.failedProxy = pp
+
+	return true
+}
+
+// containsProxy reports whether proxies contains pp.
+func containsProxy(proxies []*pooledProxy, pp *pooledProxy) bool {
+	for _, p := range proxies {
+		if p == pp {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package gotcha
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pickHosts picks a proxy from p n times and returns their hosts.
+func pickHosts(t *testing.T, p *ProxyPool, o *Options, n int) []string {
+	var hosts []string
+	for i := 0; i < n; i++ {
+		if err := p.pick(o); err != nil {
+			t.Fatal(err)
+		}
+		p.report(o, &Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil)
+		hosts = append(hosts, o.Proxy.Host)
+	}
+	return hosts
+}
+
+func newProxyUrls(hosts ...string) []*url.URL {
+	var proxies []*url.URL
+	for _, host := range hosts {
+		proxies = append(proxies, &url.URL{Scheme: "http", Host: host})
+	}
+	return proxies
+}
+
+func TestProxyPool_Strategies(t *testing.T) {
+	proxies := newProxyUrls("a", "b", "c")
+
+	if hosts := pickHosts(t, NewProxyPool(ProxyRoundRobin, proxies...), &Options{}, 4); hosts[0] != "a" || hosts[1] != "b" || hosts[2] != "c" || hosts[3] != "a" {
+		t.Fatalf(tests.MismatchFormat, "round-robin proxies", []string{"a", "b", "c", "a"}, hosts)
+	}
+
+	for _, host := range pickHosts(t, NewProxyPool(ProxyRandom, proxies...), &Options{}, 10) {
+		if host != "a" && host != "b" && host != "c" {
+			t.Fatalf("unexpected random proxy %s", host)
+		}
+	}
+
+	p := NewProxyPool(ProxyLeastUsed, proxies...)
+	p.proxies[0].uses = 5
+	p.proxies[1].uses = 1
+	if hosts := pickHosts(t, p, &Options{}, 3); hosts[0] != "c" || hosts[1] != "b" || hosts[2] != "c" {
+		t.Fatalf(tests.MismatchFormat, "least-used proxies", []string{"c", "b", "c"}, hosts)
+	}
+
+	p = NewProxyPool(ProxySticky, proxies...)
+	p.SessionKey = func(o *Options) string {
+		return o.Headers.Get("X-Session")
+	}
+	first := &Options{Headers: http.Header{"X-Session": {"1"}}}
+	second := &Options{Headers: http.Header{"X-Session": {"2"}}}
+	if hosts := append(pickHosts(t, p, first, 2), pickHosts(t, p, second, 2)...); hosts[0] != "a" || hosts[1] != "a" || hosts[2] != "b" || hosts[3] != "b" {
+		t.Fatalf(tests.MismatchFormat, "sticky proxies", []string{"a", "a", "b", "b"}, hosts)
+	}
+
+	p = &ProxyPool{Strategy: ProxyWeighted}
+	p.Add(proxies[0], 0)
+	p.Add(proxies[1], 3)
+	p.Add(proxies[2], 1)
+	counts := make(map[string]int)
+	for _, host := range pickHosts(t, p, &Options{}, 400) {
+		counts[host]++
+	}
+	if counts["a"] != 0 || counts["b"] <= counts["c"] || counts["c"] == 0 {
+		t.Fatalf("unexpected weighted distribution %v", counts)
+	}
+}
+
+func TestProxyPool_Health(t *testing.T) {
+	p := NewProxyPool(ProxyRoundRobin, newProxyUrls("a", "b")...)
+	p.StatusCodes = []int{http.StatusForbidden}
+	p.MaxFailures = 2
+	p.CoolDown = 50 * time.Millisecond
+	forbidden := &Response{Response: &http.Response{StatusCode: http.StatusForbidden}}
+
+	// the proxy that failed isn't used for the next attempt, but it's only benched after its second failure
+	o := &Options{}
+	p.pick(o)
+	if !p.report(o, forbidden, nil) {
+		t.Fatal("expected the proxy to fail")
+	}
+	if hosts := pickHosts(t, p, o, 1); hosts[0] != "b" {
+		t.Fatalf(tests.MismatchFormat, "proxy", "b", hosts[0])
+	}
+	p.pick(o)
+	p.report(o, nil, &net.OpError{Op: "dial"})
+	if stats := p.Stats(); stats[0].Failures != 2 || stats[0].BenchedUntil.IsZero() || stats[0].Uses != 2 {
+		t.Fatalf("expected proxy a to be benched after 2 failures, but got %+v", stats[0])
+	}
+	if hosts := pickHosts(t, p, o, 2); hosts[0] != "b" || hosts[1] != "b" {
+		t.Fatalf(tests.MismatchFormat, "proxies", []string{"b", "b"}, hosts)
+	}
+
+	p.pick(o)
+	p.report(o, forbidden, nil)
+	p.pick(o)
+	p.report(o, forbidden, nil)
+	if err := p.pick(o); err != NoProxyAvailableError {
+		t.Fatalf(tests.MismatchFormat, "error", NoProxyAvailableError, err)
+	}
+
+	// benched proxies are used again after the cool-down
+	time.Sleep(p.CoolDown)
+	if hosts := pickHosts(t, p, o, 2); hosts[0] != "a" || hosts[1] != "b" {
+		t.Fatalf(tests.MismatchFormat, "proxies", []string{"a", "b"}, hosts)
+	}
+}
+
+func TestClient_ProxyPool(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct"))
+	}))
+	defer target.Close()
+
+	var auth []string
+	proxy := newProxy(&auth)
+	defer proxy.Close()
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer blocked.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	var proxies []*url.URL
+	for _, server := range []*httptest.Server{dead, blocked, proxy} {
+		u, _ := url.Parse(server.URL)
+		proxies = append(proxies, u)
+	}
+	pool := NewProxyPool(ProxyRoundRobin, proxies...)
+	pool.StatusCodes = []int{http.StatusForbidden}
+
+	client, err := NewClient(&Options{Adapter: &RequestAdapter{}, ProxyPool: pool, Retry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the dead and the blocked proxy are benched, the request is retried on the working proxy
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(target.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, text := "forwarded "+target.URL+"/", mustText(t, res); text != expected {
+			t.Fatalf(tests.MismatchFormat, "body", expected, text)
+		}
+	}
+
+	stats := pool.Stats()
+	for i, expected := range []int{1, 1, 0} {
+		if stats[i].Failures != expected || stats[i].BenchedUntil.IsZero() != (expected == 0) {
+			t.Fatalf("unexpected stats of proxy %d: %+v", i, stats[i])
+		}
+	}
+	if stats[2].Uses != 2 {
+		t.Fatalf(tests.MismatchFormat, "uses of the working proxy", 2, stats[2].Uses)
+	}
+	if len(auth) != 2 {
+		t.Fatalf(tests.MismatchFormat, "proxied requests", 2, len(auth))
+	}
+
+	// requests are only retried for the configured methods
+	pool = NewProxyPool(ProxyRoundRobin, proxies[0], proxies[2])
+	if _, err = client.Post(target.URL, &Options{ProxyPool: pool, Retry: true}); err == nil {
+		t.Fatal("expected the POST request through the dead proxy to fail")
+	}
+}
+
+func TestClient_ProxyPool_Concurrency(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct"))
+	}))
+	defer target.Close()
+
+	var proxies []*url.URL
+	for i := 0; i < 2; i++ {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("forwarded " + r.URL.String()))
+		}))
+		defer proxy.Close()
+		u, _ := url.Parse(proxy.URL)
+		proxies = append(proxies, u)
+	}
+	pool := NewProxyPool(ProxyRoundRobin, proxies...)
+
+	// the requests don't have Options of their own, so they all start from the Options of the client
+	client, err := NewClient(&Options{Adapter: &RequestAdapter{}, ProxyPool: pool, PrefixURL: target.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 20
+	var wg sync.WaitGroup
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func(path string) {
+			defer wg.Done()
+			res, err := client.Get(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if expected := "forwarded " + target.URL + "/" + path; err != nil || string(b) != expected {
+				t.Errorf(tests.MismatchFormat, "body", expected, string(b))
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	var uses int
+	for _, stats := range pool.Stats() {
+		uses += stats.Uses
+	}
+	if uses != count {
+		t.Fatalf(tests.MismatchFormat, "proxy uses", count, uses)
+	}
+}
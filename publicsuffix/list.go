@@ -0,0 +1,195 @@
+// Package publicsuffix provides the public suffix list (https://publicsuffix.org) to scope cookies correctly.
+//
+// A copy of the list is embedded, which can be replaced at runtime with a more recent one using List.Update.
+// To update the embedded copy, run go generate.
+package publicsuffix
+
+//go:generate curl -fsSL -o public_suffix_list.dat https://publicsuffix.org/list/public_suffix_list.dat
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"golang.org/x/net/idna"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+//go:embed public_suffix_list.dat
+var embedded []byte
+
+var (
+	defaultList *List
+	defaultOnce sync.Once
+)
+
+// Default returns the list that is embedded in this package.
+// It's shared, so updating it affects every user of Default, such as the default cookie jar of gotcha.
+func Default() *List {
+	defaultOnce.Do(func() {
+		var err error
+		if defaultList, err = Parse(bytes.NewReader(embedded)); err != nil {
+			panic("publicsuffix: failed to parse embedded list: " + err.Error())
+		}
+	})
+	return defaultList
+}
+
+// rule types
+const (
+	normalRule = iota + 1
+	wildcardRule
+	exceptionRule
+)
+
+// rules maps the domain of each rule to its type, e.g. "*.ck" is stored as "ck" with type wildcardRule.
+// Wildcard and normal rules may share a domain, so both are stored as bit flags.
+type rules map[string]uint8
+
+// List is a public suffix list, which implements the PublicSuffixList interface of both gotcha's and net/http's cookiejar.
+//
+// A List is safe for concurrent use.
+type List struct {
+	mu      sync.RWMutex
+	rules   rules
+	version string
+}
+
+// Parse parses a list in the format of https://publicsuffix.org/list/public_suffix_list.dat.
+// Rules of internationalized domain names are converted to punycode, so they match the ASCII form of domains.
+func Parse(r io.Reader) (*List, error) {
+	l := &List{}
+	if err := l.Update(r); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ParseFile parses the list in given file.
+func ParseFile(filename string) (*List, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Update replaces the rules of the List with those parsed from r.
+// The List is left unchanged when r can't be parsed.
+func (l *List) Update(r io.Reader) error {
+	rs := make(rules)
+	version := ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "//") {
+			if v := strings.TrimPrefix(line, "// VERSION:"); v != line {
+				version = strings.TrimSpace(v)
+			}
+			continue
+		}
+
+		// rules end at the first whitespace
+		if i := strings.IndexAny(line, " \t"); i != -1 {
+			line = line[:i]
+		}
+		if line == "" {
+			continue
+		}
+
+		line = strings.ToLower(line)
+
+		var domain string
+		var typ uint8
+		switch {
+		case line == "*":
+			// the implicit default rule
+			continue
+		case strings.HasPrefix(line, "*."):
+			domain, typ = line[2:], wildcardRule
+		case strings.HasPrefix(line, "!"):
+			domain, typ = line[1:], exceptionRule
+		default:
+			domain, typ = line, normalRule
+		}
+
+		domain, err := idna.ToASCII(domain)
+		if err != nil {
+			return fmt.Errorf("publicsuffix: line %d: rule %q: %w", n, line, err)
+		}
+		rs[domain] |= 1 << typ
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(rs) == 0 {
+		return fmt.Errorf("publicsuffix: list doesn't contain any rules")
+	}
+
+	l.mu.Lock()
+	l.rules = rs
+	l.version = version
+	l.mu.Unlock()
+
+	return nil
+}
+
+// PublicSuffix returns the public suffix of domain.
+// When no rule matches, the last label of domain is the public suffix.
+//
+// For example, the public suffix of "foo.example.co.uk" is "co.uk".
+func (l *List) PublicSuffix(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// walk the candidate suffixes from long to short, so that the first match is the prevailing rule
+	for suffix := domain; suffix != ""; {
+		dot := strings.IndexByte(suffix, '.')
+
+		// an exception rule takes precedence, its suffix is the rule without its leftmost label
+		if l.rules[suffix]&(1<<exceptionRule) != 0 {
+			if dot == -1 {
+				return suffix
+			}
+			return suffix[dot+1:]
+		}
+
+		if l.rules[suffix]&(1<<normalRule) != 0 {
+			return suffix
+		}
+
+		if dot == -1 {
+			// implicit "*" rule
+			return suffix
+		}
+
+		parent := suffix[dot+1:]
+		if l.rules[parent]&(1<<wildcardRule) != 0 {
+			return suffix
+		}
+
+		suffix = parent
+	}
+
+	return domain
+}
+
+// String returns the version of the List.
+func (l *List) String() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.version == "" {
+		return "publicsuffix.org list"
+	}
+	return "publicsuffix.org list, version " + l.version
+}
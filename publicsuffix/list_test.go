@@ -0,0 +1,77 @@
+package publicsuffix
+
+import (
+	"github.com/sleeyax/gotcha/internal/tests"
+	"strings"
+	"testing"
+)
+
+func TestList_PublicSuffix(t *testing.T) {
+	testCases := map[string]string{
+		"com":                 "com",
+		"example.com":         "com",
+		"www.example.com":     "com",
+		"foo.example.co.uk":   "co.uk",
+		"CO.UK.":              "co.uk",
+		"example.uk.com":      "uk.com",
+		"a.b.kobe.jp":         "b.kobe.jp",
+		"city.kobe.jp":        "kobe.jp",
+		"www.city.kobe.jp":    "kobe.jp",
+		"www.ck":              "ck",
+		"www.www.ck":          "ck",
+		"test.ck":             "test.ck",
+		"b.test.ck":           "test.ck",
+		"example.example":     "example",
+		"localhost":           "localhost",
+		"foo.xn--fiqs8s":      "xn--fiqs8s",
+		"foo.blogspot.com":    "blogspot.com",
+		"foo.bar.example.org": "org",
+	}
+
+	list := Default()
+	for domain, expected := range testCases {
+		if suffix := list.PublicSuffix(domain); suffix != expected {
+			t.Errorf(tests.MismatchFormat, domain, expected, suffix)
+		}
+	}
+}
+
+func TestList_Update(t *testing.T) {
+	list, err := Parse(strings.NewReader("// VERSION: test\ncom\n*.example.com\n!www.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := list.String(); s != "publicsuffix.org list, version test" {
+		t.Fatalf(tests.MismatchFormat, "version", "publicsuffix.org list, version test", s)
+	}
+	if suffix := list.PublicSuffix("foo.bar.example.com"); suffix != "bar.example.com" {
+		t.Fatalf(tests.MismatchFormat, "suffix", "bar.example.com", suffix)
+	}
+	if suffix := list.PublicSuffix("www.example.com"); suffix != "example.com" {
+		t.Fatalf(tests.MismatchFormat, "suffix", "example.com", suffix)
+	}
+
+	if err = list.Update(strings.NewReader("// no rules\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err = list.Update(strings.NewReader("org\n")); err != nil {
+		t.Fatal(err)
+	}
+	if suffix := list.PublicSuffix("foo.bar.example.com"); suffix != "com" {
+		t.Fatalf(tests.MismatchFormat, "suffix", "com", suffix)
+	}
+
+	// internationalized rules as published on publicsuffix.org
+	if err = list.Update(strings.NewReader("cn\n公司.cn\n*.個人.香港\n")); err != nil {
+		t.Fatal(err)
+	}
+	for domain, expected := range map[string]string{
+		"example.xn--55qx5d.cn":          "xn--55qx5d.cn",
+		"www.foo.xn--gmqw5a.xn--j6w193g": "foo.xn--gmqw5a.xn--j6w193g",
+		"example.cn":                     "cn",
+	} {
+		if suffix := list.PublicSuffix(domain); suffix != expected {
+			t.Fatalf(tests.MismatchFormat, domain, expected, suffix)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package gotcha
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RawAdapter is an Adapter that speaks HTTP/1.1 on its own instead of relying on net/http.
+// Requests are written to the wire exactly as specified: headers keep the casing of their key in Options.Headers
+// and are sent in the order given by Options.HeaderOrder.
+//
+// Connections are kept alive and reused.
+// Use TLSClient to customize the TLS handshake, e.g. to use a different TLS library.
+type RawAdapter struct {
+	// Dial connects to the address on the named network.
+	//
+	// Defaults to net.Dialer.DialContext.
+	Dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	// TLSClient performs the TLS handshake with serverName over conn for requests to HTTPS URLs.
+	// When a proxy is used, conn is the tunnel through the proxy.
+	//
+	// Defaults to a function that uses crypto/tls with TLSConfig.
+	TLSClient func(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error)
+
+	// TLSConfig is the tls.Config used by the default TLSClient and to connect to HTTPS proxies.
+	TLSConfig *tls.Config
+
+	// Maximum amount of idle connections to keep per host.
+	// Defaults to 2, keep-alive is disabled when negative.
+	MaxIdleConnsPerHost int
+
+	// Maximum amount of time an idle connection is kept before it's closed.
+	// Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*rawConn
+}
+
+// rawConn is a connection that can be reused by the RawAdapter.
+type rawConn struct {
+	net.Conn
+	key    string
+	reader *bufio.Reader
+	idleAt time.Time
+}
+
+// rawBody is the body of a Response received by the RawAdapter.
+// It releases the connection once the body has been read or closed.
+type rawBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func(reusable bool)
+}
+
+func (b *rawBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(func() { b.release(err == io.EOF) })
+	}
+	return n, err
+}
+
+func (b *rawBody) Close() error {
+	b.once.Do(func() { b.release(false) })
+	b.ReadCloser.Close()
+	return nil
+}
+
+// countingWriter counts the bytes written to Writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+var headerValueReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// Capabilities reports that header order and casing are honored.
+// Pseudo headers don't exist in HTTP/1.1.
+func (ra *RawAdapter) Capabilities() Capabilities {
+	return Capabilities{HeaderOrder: true, HeaderCasing: true}
+}
+
+func (ra *RawAdapter) DoRequest(options *Options) (*Response, error) {
+	u := options.FullUrl
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("gotcha: unsupported scheme %q", u.Scheme)
+	}
+
+	ctx := options.RequestContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	header := options.Headers.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	if options.CookieJar != nil {
+		if cookies := options.CookieJar.Cookies(u); len(cookies) > 0 {
+			var values []string
+			for _, cookie := range cookies {
+				values = append(values, (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
+			}
+			if key := headerKey(header, "Cookie"); key != "" && len(header[key]) != 0 {
+				header[key] = []string{header[key][0] + "; " + strings.Join(values, "; ")}
+			} else {
+				header["Cookie"] = []string{strings.Join(values, "; ")}
+			}
+		}
+	}
+
+	// only bodies that are buffered in memory can be sent again
+	var data []byte
+	replayable := true
+	if options.Body != nil {
+		defer options.Body.Close()
+		if rb, ok := options.Body.(*replayableBody); ok {
+			data = rb.data
+		} else {
+			replayable = false
+		}
+	}
+
+	for {
+		conn, reused, err := ra.conn(ctx, u, options.Proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		body := io.Reader(options.Body)
+		length := int64(-1)
+		if replayable {
+			body = bytes.NewReader(data)
+			length = int64(len(data))
+		}
+
+		res, retry, err := ra.roundTrip(ctx, conn, options, header, body, length)
+		if err == nil {
+			if options.CookieJar != nil {
+				if rc := res.Cookies(); len(rc) > 0 {
+					options.CookieJar.SetCookies(u, rc)
+				}
+			}
+			return &Response{res, options.UnmarshalJson}, nil
+		}
+
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// the server may have closed an idle connection, try again on a new one
+		if !reused || !retry || !replayable {
+			return nil, err
+		}
+	}
+}
+
+// roundTrip sends the request on conn and reads the response.
+// retry reports whether the request can safely be sent again when an error occurs.
+func (ra *RawAdapter) roundTrip(ctx context.Context, conn *rawConn, options *Options, header http.Header, body io.Reader, length int64) (res *http.Response, retry bool, err error) {
+	done := make(chan struct{})
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-done:
+			}
+		}()
+	}
+	defer func() {
+		if err != nil {
+			close(done)
+		}
+	}()
+
+	// the request can be sent again when none of it reached the server
+	w := &countingWriter{Writer: conn}
+	if err = ra.writeRequest(w, options, header, body, length); err != nil {
+		return nil, w.n == 0, err
+	}
+
+	method := options.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req := &http.Request{
+		Method:     method,
+		URL:        options.FullUrl,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Host:       options.FullUrl.Host,
+	}
+	req = req.WithContext(ctx)
+
+	// a server that closes an idle connection does so without responding,
+	// the request may have been processed anyway, so only idempotent requests are sent again
+	if _, err = conn.reader.Peek(1); err != nil {
+		return nil, isIdempotent(method, header), err
+	}
+
+	for {
+		res, err = http.ReadResponse(conn.reader, req)
+		if err != nil {
+			return nil, false, err
+		}
+		// skip informational responses such as 100 Continue
+		if res.StatusCode < 100 || res.StatusCode >= 200 || res.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+
+	if tc, ok := conn.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		state := tc.ConnectionState()
+		res.TLS = &state
+	}
+
+	keepAlive := !res.Close && res.StatusCode != http.StatusSwitchingProtocols
+	release := func(reusable bool) {
+		close(done)
+		if reusable && keepAlive && ctx.Err() == nil {
+			ra.putIdle(conn)
+		} else {
+			conn.Close()
+		}
+	}
+
+	if res.Body == http.NoBody {
+		release(true)
+	} else {
+		res.Body = &rawBody{ReadCloser: res.Body, release: release}
+	}
+
+	return res, false, nil
+}
+
+// writeRequest writes the request line, headers and body to conn.
+func (ra *RawAdapter) writeRequest(conn io.Writer, options *Options, header http.Header, body io.Reader, length int64) error {
+	u := options.FullUrl
+	w := bufio.NewWriter(conn)
+
+	method := options.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// plain HTTP requests are forwarded by the proxy and use the absolute form
+	target := u.RequestURI()
+	if options.Proxy != nil && u.Scheme == "http" {
+		target = u.Scheme + "://" + u.Host + target
+		if pu := options.Proxy.User; pu != nil && headerKey(header, "Proxy-Authorization") == "" {
+			header = header.Clone()
+			header["Proxy-Authorization"] = []string{basicAuth(pu)}
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", method, target); err != nil {
+		return err
+	}
+
+	// Host goes first unless its position is specified
+	order := options.headerOrder()
+	if !containsFold(order, "Host") {
+		order = append([]string{"Host"}, order...)
+	}
+	if headerKey(header, "Host") == "" {
+		header = header.Clone()
+		header["Host"] = []string{u.Host}
+	}
+
+	chunked := false
+	if headerKey(header, "Content-Length") == "" && headerKey(header, "Transfer-Encoding") == "" {
+		if length < 0 {
+			header = header.Clone()
+			header["Transfer-Encoding"] = []string{"chunked"}
+			chunked = true
+		} else if length > 0 || method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
+			header = header.Clone()
+			header["Content-Length"] = []string{fmt.Sprint(length)}
+		}
+	} else if key := headerKey(header, "Transfer-Encoding"); key != "" {
+		chunked = len(header[key]) != 0 && strings.EqualFold(header[key][0], "chunked")
+	}
+
+	for _, key := range orderHeaderKeys(header, order) {
+		for _, value := range header[key] {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", key, headerValueReplacer.Replace(value)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	if body != nil {
+		if chunked {
+			cw := httputil.NewChunkedWriter(w)
+			if _, err := io.Copy(cw, body); err != nil {
+				return err
+			}
+			if err := cw.Close(); err != nil {
+				return err
+			}
+			if _, err := w.WriteString("\r\n"); err != nil {
+				return err
+			}
+		} else if _, err := io.Copy(w, body); err != nil {
+			return err
+		}
+	} else if chunked {
+		if _, err := w.WriteString("0\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// conn returns an idle connection for u or dials a new one.
+// reused reports whether the connection was used before.
+func (ra *RawAdapter) conn(ctx context.Context, u *url.URL, proxy *url.URL) (conn *rawConn, reused bool, err error) {
+	key := u.Scheme + "://" + canonicalAddr(u)
+	if proxy != nil {
+		key += "|" + proxy.String()
+	}
+
+	timeout := ra.IdleConnTimeout
+	if timeout == 0 {
+		timeout = 90 * time.Second
+	}
+
+	ra.mu.Lock()
+	for conns := ra.idle[key]; len(conns) > 0; conns = ra.idle[key] {
+		c := conns[len(conns)-1]
+		ra.idle[key] = conns[:len(conns)-1]
+		if time.Since(c.idleAt) > timeout {
+			c.Close()
+			continue
+		}
+		ra.mu.Unlock()
+		return c, true, nil
+	}
+	ra.mu.Unlock()
+
+	c, err := ra.dial(ctx, u, proxy)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &rawConn{Conn: c, key: key, reader: bufio.NewReader(c)}, false, nil
+}
+
+// putIdle keeps conn around for reuse.
+func (ra *RawAdapter) putIdle(conn *rawConn) {
+	max := ra.MaxIdleConnsPerHost
+	if max == 0 {
+		max = 2
+	}
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	if len(ra.idle[conn.key]) >= max {
+		conn.Close()
+		return
+	}
+	if ra.idle == nil {
+		ra.idle = make(map[string][]*rawConn)
+	}
+	conn.idleAt = time.Now()
+	ra.idle[conn.key] = append(ra.idle[conn.key], conn)
+}
+
+// CloseIdleConnections closes all connections that are kept for reuse.
+func (ra *RawAdapter) CloseIdleConnections() {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	for key, conns := range ra.idle {
+		for _, c := range conns {
+			c.Close()
+		}
+		delete(ra.idle, key)
+	}
+}
+
+// dial connects to the host of u, optionally through proxy, and performs the TLS handshake for HTTPS URLs.
+func (ra *RawAdapter) dial(ctx context.Context, u *url.URL, proxy *url.URL) (net.Conn, error) {
+	dial := ra.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	var conn net.Conn
+	var err error
+
+	if proxy != nil && u.Scheme != "https" && isHTTPProxy(proxy) {
+		// plain HTTP requests are sent to HTTP proxies themselves
+		if conn, err = dialProxy(ctx, dial, proxy, ra.TLSConfig); err != nil {
+			return nil, err
+		}
+	} else {
+		// other requests are tunneled through the proxy
+		d := &ProxyDialer{Proxy: proxy, Dial: dial, TLSConfig: ra.TLSConfig}
+		if conn, err = d.DialContext(ctx, "tcp", canonicalAddr(u)); err != nil {
+			return nil, err
+		}
+	}
+
+	if u.Scheme == "https" {
+		tlsClient := ra.TLSClient
+		if tlsClient == nil {
+			tlsClient = ra.tlsClient
+		}
+		tc, err := tlsClient(ctx, conn, u.Hostname())
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	return conn, nil
+}
+
+// tlsClient is the default TLSClient.
+func (ra *RawAdapter) tlsClient(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error) {
+	return tlsClient(ctx, conn, serverName, ra.TLSConfig)
+}
+
+// tlsClient performs the TLS handshake with serverName over conn using crypto/tls with config, which may be nil.
+func tlsClient(ctx context.Context, conn net.Conn, serverName string, config *tls.Config) (net.Conn, error) {
+	if config != nil {
+		config = config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	tc := tls.Client(conn, config)
+	if err := withDeadline(ctx, conn, tc.Handshake); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// withDeadline calls fn and makes sure operations on conn are interrupted when ctx is done.
+func withDeadline(ctx context.Context, conn net.Conn, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	err := fn()
+	close(stop)
+	<-stopped
+	conn.SetDeadline(time.Time{})
+
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// canonicalAddr returns the host and port of u, using the default port of the scheme if none is specified.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h", "socks4a":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// isIdempotent reports whether a request with method and header can be sent more than once, see net/http.
+func isIdempotent(method string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return headerKey(header, "Idempotency-Key") != "" || headerKey(header, "X-Idempotency-Key") != ""
+}
@@ -0,0 +1,273 @@
+package gotcha
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/sleeyax/gotcha/cookiejar"
+	"github.com/sleeyax/gotcha/internal/tests"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRawAdapter_HeaderOrder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var head []string
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			head = append(head, strings.TrimSuffix(line, "\r\n"))
+		}
+		lines <- head
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	}()
+
+	client, err := NewClient(&Options{Adapter: &RawAdapter{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Get("http://"+l.Addr().String()+"/path?q=1", &Options{
+		Headers: http.Header{
+			"user-agent":   {"gotcha"},
+			"X-Custom":     {"1"},
+			"accept":       {"*/*"},
+			"zzz":          {"last"},
+			HeaderOrderKey: {"Accept", "x-custom", "User-Agent"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "ok" {
+		t.Fatalf(tests.MismatchFormat, "body", "ok", text)
+	}
+
+	expected := []string{
+		"GET /path?q=1 HTTP/1.1",
+		"Host: " + l.Addr().String(),
+		"accept: */*",
+		"X-Custom: 1",
+		"user-agent: gotcha",
+		"zzz: last",
+	}
+	head := <-lines
+	var got []string
+	for _, line := range head {
+		for _, e := range expected {
+			if line == e {
+				got = append(got, line)
+			}
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf(tests.MismatchFormat, "request head", expected, head)
+	}
+}
+
+func TestRawAdapter_KeepAlive(t *testing.T) {
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(r.TransferEncoding) != 0 {
+			w.Write([]byte(r.TransferEncoding[0] + ":"))
+		}
+		w.Write(body)
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	adapter := &RawAdapter{}
+	defer adapter.CloseIdleConnections()
+
+	client, err := NewClient(&Options{Adapter: adapter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Post(ts.URL, &Options{Json: JSON{"i": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustText(t, res)
+	}
+
+	// bodies of unknown length are chunked
+	res, err := client.Post(ts.URL, &Options{Body: io.NopCloser(strings.NewReader("streamed"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "chunked:streamed" {
+		t.Fatalf(tests.MismatchFormat, "body", "chunked:streamed", text)
+	}
+
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf(tests.MismatchFormat, "connections", 1, n)
+	}
+}
+
+func TestRawAdapter_Retry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// every connection answers its first request and closes without responding to the second one
+	var requests int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for i := 0; ; i++ {
+					req, err := http.ReadRequest(r)
+					if err != nil {
+						return
+					}
+					io.Copy(io.Discard, req.Body)
+					atomic.AddInt32(&requests, 1)
+					if i == 1 {
+						return
+					}
+					conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}()
+		}
+	}()
+
+	adapter := &RawAdapter{}
+	defer adapter.CloseIdleConnections()
+	client, err := NewClient(&Options{Adapter: adapter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := "http://" + l.Addr().String()
+
+	// the second GET is sent again on a new connection
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := mustText(t, res); text != "ok" {
+			t.Fatalf(tests.MismatchFormat, "body", "ok", text)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf(tests.MismatchFormat, "requests", 3, n)
+	}
+
+	// the server may have processed the POST, so it isn't sent again, even though its body can be replayed
+	if _, err = client.Post(u, &Options{Body: newReplayableBody([]byte("once"))}); err == nil {
+		t.Fatal("expected the POST request to fail")
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Fatalf(tests.MismatchFormat, "requests", 4, n)
+	}
+}
+
+func TestRawAdapter_Proxy(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tunneled"))
+	}))
+	defer target.Close()
+
+	var auth []string
+	proxy := newProxy(&auth)
+	defer proxy.Close()
+
+	proxyUrl, _ := url.Parse(proxy.URL)
+	proxyUrl.User = url.UserPassword("user", "pass")
+
+	adapter := &RawAdapter{TLSConfig: &tls.Config{RootCAs: target.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}}
+	defer adapter.CloseIdleConnections()
+
+	client, err := NewClient(&Options{Adapter: adapter, Proxy: proxyUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Get("http://example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "forwarded http://example.com/foo" {
+		t.Fatalf(tests.MismatchFormat, "body", "forwarded http://example.com/foo", text)
+	}
+
+	res, err = client.Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "tunneled" {
+		t.Fatalf(tests.MismatchFormat, "body", "tunneled", text)
+	}
+	if res.TLS == nil {
+		t.Fatal("expected a TLS connection state")
+	}
+
+	expected := basicAuth(proxyUrl.User)
+	if len(auth) != 2 || auth[0] != expected || auth[1] != expected {
+		t.Fatalf(tests.MismatchFormat, "proxy authorization", expected, auth)
+	}
+}
+
+func TestRawAdapter_Cookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client, err := NewClient(&Options{Adapter: &RawAdapter{}, CookieJar: jar})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustText(t, res)
+
+	res, err = client.Get(ts.URL, &Options{Headers: http.Header{"cookie": {"a=b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := mustText(t, res); text != "a=b; session=abc" {
+		t.Fatalf(tests.MismatchFormat, "cookie", "a=b; session=abc", text)
+	}
+}
@@ -0,0 +1,221 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// socks5Replies are the messages of the replies of a SOCKS5 proxy, see RFC 1928.
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5 establishes a tunnel to addr through a SOCKS5 proxy, see RFC 1928.
+// Host names are sent to the proxy when remoteDNS is true, and resolved before they're sent otherwise.
+// The proxy is authenticated with the username and password of user, see RFC 1929.
+func socks5(ctx context.Context, conn net.Conn, addr string, user *url.Userinfo, remoteDNS bool) error {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil && !remoteDNS {
+		if ip, err = lookupIP(ctx, host); err != nil {
+			return err
+		}
+	}
+	if ip == nil && len(host) > 255 {
+		return fmt.Errorf("gotcha: host name %q is too long", host)
+	}
+
+	return withDeadline(ctx, conn, func() error {
+		methods := []byte{0}
+		if user != nil {
+			methods = append(methods, 2)
+		}
+		if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+			return err
+		}
+
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		if b[0] != 5 {
+			return fmt.Errorf("gotcha: unexpected SOCKS version %d", b[0])
+		}
+		switch {
+		case b[1] == 0:
+		case b[1] == 2 && user != nil:
+			if err := socks5Authenticate(conn, user); err != nil {
+				return err
+			}
+		default:
+			return errors.New("gotcha: no acceptable SOCKS5 authentication method")
+		}
+
+		req := []byte{5, 1, 0}
+		if ip == nil {
+			req = append(append(req, 3, byte(len(host))), host...)
+		} else if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, 1), ip4...)
+		} else {
+			req = append(append(req, 4), ip.To16()...)
+		}
+		req = append(req, byte(port>>8), byte(port))
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+
+		return socks5Reply(conn)
+	})
+}
+
+// socks5Authenticate authenticates with the username and password of user, see RFC 1929.
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("gotcha: SOCKS5 username or password is too long")
+	}
+
+	req := append([]byte{1, byte(len(username))}, username...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[1] != 0 {
+		return errors.New("gotcha: SOCKS5 authentication failed")
+	}
+
+	return nil
+}
+
+// socks5Reply reads the reply to a CONNECT request, including the bound address, which is discarded.
+func socks5Reply(conn net.Conn) error {
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if b[1] != 0 {
+		if int(b[1]) < len(socks5Replies) {
+			return fmt.Errorf("gotcha: SOCKS5 proxy responded with %s", socks5Replies[b[1]])
+		}
+		return fmt.Errorf("gotcha: SOCKS5 proxy responded with unknown reply %d", b[1])
+	}
+
+	var n int
+	switch b[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("gotcha: unknown SOCKS5 address type %d", b[3])
+	}
+
+	// the bound address and port
+	_, err := io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// socks4a establishes a tunnel to addr through a SOCKS4a proxy.
+// Host names are sent to the proxy, which resolves them.
+// The username of user is sent as the user ID.
+func socks4a(ctx context.Context, conn net.Conn, addr string, user *url.Userinfo) error {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	req := []byte{4, 1, byte(port >> 8), byte(port)}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		return fmt.Errorf("gotcha: SOCKS4a doesn't support IPv6 address %s", host)
+	}
+	if ip != nil {
+		req = append(req, ip.To4()...)
+	} else {
+		// an invalid IP address of 0.0.0.x tells the proxy the domain name follows the user ID
+		req = append(req, 0, 0, 0, 1)
+	}
+	if user != nil {
+		req = append(req, user.Username()...)
+	}
+	req = append(req, 0)
+	if ip == nil {
+		req = append(append(req, host...), 0)
+	}
+
+	return withDeadline(ctx, conn, func() error {
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		switch b[1] {
+		case 0x5a:
+			return nil
+		case 0x5b:
+			return errors.New("gotcha: SOCKS4a proxy rejected the request")
+		case 0x5c, 0x5d:
+			return errors.New("gotcha: SOCKS4a proxy couldn't verify the user ID")
+		default:
+			return fmt.Errorf("gotcha: SOCKS4a proxy responded with unknown reply %d", b[1])
+		}
+	})
+}
+
+// splitHostPort splits addr into its host and numeric port.
+func splitHostPort(addr string) (string, uint16, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("gotcha: invalid port %q", portString)
+	}
+	return host, uint16(port), nil
+}
+
+// lookupIP resolves host, preferring IPv4 addresses.
+func lookupIP(ctx context.Context, host string) (net.IP, error) {
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			return ip.IP, nil
+		}
+	}
+	return ips[0].IP, nil
+}